@@ -1,13 +1,21 @@
 package main
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/commands"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
+	"github.com/urfave/cli/v2"
 )
 
 func main() {
-	commands.Start()
+	app := cli.NewApp()
+	app.Name = "hello-storage-proxy"
+	app.Usage = "Hello Storage proxy"
+	app.Commands = commands.Commands
+	app.Action = commands.StartCommand.Action
 
-	fmt.Println("Server running!!")
+	if err := app.Run(os.Args); err != nil {
+		event.Log.Fatal(err)
+	}
 }
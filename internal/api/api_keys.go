@@ -1,24 +1,114 @@
 package api
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-// ApiKey
+// defaultApiKeyName is the name of keys created without one.
+const defaultApiKeyName = "default"
+
+// defaultApiKeyFilesLimit is the number of files listed per page by default.
+const defaultApiKeyFilesLimit = 50
+
+// createApiKey creates a new key for a user as part of the transaction tx. The
+// key expires at expiresAt, or never if it is nil.
+func createApiKey(tx *gorm.DB, tokenMaker token.Maker, u *entity.User, name string, expiresAt *time.Time, scopes []token.Scope) (*form.CreateApiKeyResponse, error) {
+	var duration time.Duration
+	if expiresAt != nil {
+		duration = time.Until(*expiresAt)
+	}
+
+	key, payload, err := tokenMaker.CreateApiKey(u.ID, u.UID, u.Name, duration, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := &entity.ApiKey{
+		UserID: u.ID,
+		Name:   name,
+		Scopes: scopes,
+	}
+	apiKey.SetKey(key)
+	if expiresAt != nil {
+		apiKey.ExpiresAt = &payload.ExpiredAt
+	}
+
+	if err := apiKey.TxCreate(tx); err != nil {
+		return nil, err
+	}
+
+	return &form.CreateApiKeyResponse{
+		ApiKey:          apiKey,
+		Key:             key,
+		ApiKeyExpiresAt: payload.ExpiredAt,
+	}, nil
+}
+
+// findOwnApiKey returns the key with the id in the path if it belongs to the
+// user, responding with an error and returning nil otherwise.
+func findOwnApiKey(ctx *gin.Context, userID uint) *entity.ApiKey {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		AbortEntityNotFound(ctx)
+		return nil
+	}
+
+	apiKey, err := query.FindApiKeyByID(uint(id), userID)
+	if err != nil {
+		AbortEntityNotFound(ctx)
+		return nil
+	}
+
+	return apiKey
+}
+
+// ApiKey manages the API keys of the user. Keys are only shown when they are
+// created or rotated, afterwards only their prefix is known. Keys created
+// without scopes can only read. Keys cannot be managed with API keys, so a key
+// cannot grant itself more scopes.
 //
 // POST /api/api_key
+// GET /api/api_key
+// DELETE /api/api_key/:id
+// POST /api/api_key/:id/rotate
+// GET /api/api_key/:id/files
 func ApiKey(router *gin.RouterGroup, tokenMaker token.Maker) {
-	router.POST("/api_key", func(ctx *gin.Context) {
+	router.POST("/api_key", RequireSession(), func(ctx *gin.Context) {
 
 		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
 
+		var f form.CreateApiKeyRequest
+		if err := ctx.ShouldBindJSON(&f); err != nil && !errors.Is(err, io.EOF) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/api_key:00000001"))
+			return
+		}
+
+		if f.Name == "" {
+			f.Name = defaultApiKeyName
+		}
+
+		scopes := token.ReadScopes
+		if len(f.Scopes) > 0 {
+			var err error
+			if scopes, err = token.ParseScopes(f.Scopes); err != nil {
+				ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/api_key:00000003"))
+				return
+			}
+		}
+
 		authMutex.Lock()
 		defer authMutex.Unlock()
 
@@ -28,47 +118,143 @@ func ApiKey(router *gin.RouterGroup, tokenMaker token.Maker) {
 			return
 		}
 
-		// create api key
-		apikey, accessPayload, err := tokenMaker.CreateApiKey(
-			u.ID,
-			u.UID,
-			u.Name,
-		)
+		var expiresAt *time.Time
+		if f.ExpiresIn > 0 {
+			t := time.Now().Add(time.Duration(f.ExpiresIn) * time.Second)
+			expiresAt = &t
+		}
+
+		rsp, err := createApiKey(db.Db(), tokenMaker, u, f.Name, expiresAt, scopes)
+		if err != nil {
+			log.Errorf("failed to create api-key: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, rsp)
+	})
+
+	router.GET("/api_key", RequireSession(), func(c *gin.Context) {
+
+		authPayload := c.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+		keys, err := query.FindApiKeysByUserID(authPayload.UserID)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
+			log.Errorf("failed to find api keys: %v", err)
+			AbortUnexpected(c)
 			return
 		}
+		c.JSON(http.StatusOK, keys)
+	})
 
-		rsp := form.CreateApiKeyResponse{
-			ApiKey:          apikey,
-			ApiKeyExpiresAt: accessPayload.ExpiredAt,
+	router.DELETE("/api_key/:id", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		apiKey := findOwnApiKey(ctx, authPayload.UserID)
+		if apiKey == nil {
+			return
 		}
 
-		apikeyEntity := &entity.ApiKey{
-			UserID: u.ID,
-			ApiKey: apikey,
+		if !apiKey.IsRevoked() {
+			if err := apiKey.TxRevoke(db.Db()); err != nil {
+				log.Errorf("failed to revoke api key %d: %v", apiKey.ID, err)
+				AbortSaveFailed(ctx)
+				return
+			}
 		}
 
-		if err := apikeyEntity.Create(); err != nil {
+		ctx.JSON(http.StatusOK, apiKey)
+	})
+
+	// rotating replaces a key by a new one with the same name, expiry and scopes
+	router.POST("/api_key/:id/rotate", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		apiKey := findOwnApiKey(ctx, authPayload.UserID)
+		if apiKey == nil {
+			return
+		}
+
+		if apiKey.IsRevoked() || apiKey.IsExpired() {
+			ctx.JSON(http.StatusConflict, ErrorResponse(errors.New("only active keys can be rotated"), "/api_key:00000002"))
+			return
+		}
+
+		u, err := query.FindUserByUID(authPayload.UserID)
+		if err != nil {
+			Abort(ctx, http.StatusNotFound, "user not exists!")
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		if err := apiKey.TxRevoke(tx); err != nil {
+			log.Errorf("failed to revoke api key %d: %v", apiKey.ID, err)
+			tx.Rollback()
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		scopes := apiKey.Scopes
+		if scopes == nil {
+			scopes = token.Scopes
+		}
+
+		rsp, err := createApiKey(tx, tokenMaker, u, apiKey.Name, apiKey.ExpiresAt, scopes)
+		if err != nil {
 			log.Errorf("failed to create api-key: %v", err)
-			ctx.JSON(
-				http.StatusInternalServerError,
-				gin.H{"status": "fail", "message": err.Error()},
-			)
+			tx.Rollback()
+			AbortSaveFailed(ctx)
 			return
 		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit rotation of api key %d: %v", apiKey.ID, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
 		ctx.JSON(http.StatusOK, rsp)
 	})
 
-	router.GET("/api_key", func(c *gin.Context) {
+	// files uploaded with a key stay listed after it is revoked
+	router.GET("/api_key/:id/files", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
 
-		authPayload := c.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
-		p, err := query.FindApiKeyByUserID(authPayload.UserID)
+		var f form.ApiKeyFilesRequest
+		if err := ctx.ShouldBindQuery(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/api_key:00000004"))
+			return
+		}
+
+		if f.Page == 0 {
+			f.Page = 1
+		}
+		if f.Limit == 0 {
+			f.Limit = defaultApiKeyFilesLimit
+		}
+
+		apiKey := findOwnApiKey(ctx, authPayload.UserID)
+		if apiKey == nil {
+			return
+		}
+
+		files, total, err := query.FindApiKeyFiles(apiKey.ID, (f.Page-1)*f.Limit, f.Limit)
 		if err != nil {
-			AbortEntityNotFound(c)
+			log.Errorf("failed to find files of api key %d: %v", apiKey.ID, err)
+			AbortUnexpected(ctx)
 			return
 		}
-		c.JSON(http.StatusOK, p)
-	})
 
+		rsp := form.ApiKeyFilesResponse{
+			Files: make([]form.FileResponse, len(files)),
+			Page:  f.Page,
+			Limit: f.Limit,
+			Total: total,
+		}
+		for i := range files {
+			rsp.Files[i] = fileResponse(&files[i])
+		}
+
+		ctx.JSON(http.StatusOK, rsp)
+	})
 }
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// newApiKeyRouter opens a test database with user 1 and returns a router
+// managing their API keys with a session.
+func newApiKeyRouter(t *testing.T) (*gin.Engine, token.Maker) {
+	testdb.Open(t)
+	return apiKeyRouter(t)
+}
+
+// apiKeyRouter creates user 1 and returns a router managing their API keys
+// with a session.
+func apiKeyRouter(t *testing.T) (*gin.Engine, token.Maker) {
+	gin.SetMode(gin.TestMode)
+
+	require.NoError(t, db.Db().Create(&entity.User{ID: 1, UID: "u1", Name: "user"}).Error)
+
+	keyring, err := token.NewKeyring("1", "12345678901234567890123456789012", nil)
+	require.NoError(t, err)
+	tokenMaker, err := token.NewPasetoMaker(keyring)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{UserID: 1})
+	})
+	ApiKey(router.Group("/api"), tokenMaker)
+
+	return router, tokenMaker
+}
+
+func serveJSON(t *testing.T, router *gin.Engine, method, path, body string, v interface{}) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if v != nil && w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+
+	return w.Code
+}
+
+func apiKeyPath(id uint) string {
+	return "/api/api_key/" + strconv.FormatUint(uint64(id), 10)
+}
+
+func TestApiKeyCreate(t *testing.T) {
+	router, tokenMaker := newApiKeyRouter(t)
+
+	var created form.CreateApiKeyResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/api_key", `{"name":"ci","scopes":["files:read"]}`, &created))
+	require.Equal(t, "ci", created.Name)
+	require.Equal(t, []token.Scope{token.ScopeFilesRead}, created.Scopes)
+
+	// only the hash and the prefix of the key are stored
+	m, err := query.FindApiKey(created.Key)
+	require.NoError(t, err)
+	require.Equal(t, created.ID, m.ID)
+	require.Empty(t, m.ApiKey)
+	require.True(t, strings.HasPrefix(created.Key, "v2.local."+m.Prefix))
+
+	payload, err := tokenMaker.VerifyApiKey(created.Key)
+	require.NoError(t, err)
+	require.True(t, payload.HasScope(token.ScopeFilesRead))
+	require.False(t, payload.HasScope(token.ScopeFilesWrite))
+
+	var keys []entity.ApiKey
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodGet, "/api/api_key", "", &keys))
+	require.Len(t, keys, 1)
+
+	require.Equal(t, http.StatusBadRequest, serveJSON(t, router, http.MethodPost, "/api/api_key", `{"scopes":["files:everything"]}`, nil))
+
+	// keys created without scopes can only read
+	var readOnly form.CreateApiKeyResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/api_key", "", &readOnly))
+	require.Equal(t, token.ReadScopes, readOnly.Scopes)
+}
+
+func TestApiKeyRevoke(t *testing.T) {
+	router, _ := newApiKeyRouter(t)
+
+	var created form.CreateApiKeyResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/api_key", `{"scopes":["files:read"]}`, &created))
+
+	var revoked entity.ApiKey
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodDelete, apiKeyPath(created.ID), "", &revoked))
+	require.True(t, revoked.IsRevoked())
+
+	m, err := query.FindApiKey(created.Key)
+	require.NoError(t, err)
+	require.True(t, m.IsRevoked())
+
+	// keys of other users are not found
+	other := &entity.ApiKey{UserID: 2}
+	other.SetKey("v2.local.other")
+	require.NoError(t, other.Create())
+	require.Equal(t, http.StatusNotFound, serveJSON(t, router, http.MethodDelete, apiKeyPath(other.ID), "", nil))
+}
+
+func TestApiKeyRotate(t *testing.T) {
+	router, _ := newApiKeyRouter(t)
+
+	var created form.CreateApiKeyResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/api_key", `{"name":"ci","expires_in":3600,"scopes":["files:read"]}`, &created))
+
+	var rotated form.CreateApiKeyResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, apiKeyPath(created.ID)+"/rotate", "", &rotated))
+	require.NotEqual(t, created.ID, rotated.ID)
+	require.NotEqual(t, created.Key, rotated.Key)
+	require.Equal(t, "ci", rotated.Name)
+	require.Equal(t, created.Scopes, rotated.Scopes)
+	require.WithinDuration(t, *created.ExpiresAt, *rotated.ExpiresAt, time.Second)
+
+	m, err := query.FindApiKey(created.Key)
+	require.NoError(t, err)
+	require.True(t, m.IsRevoked())
+
+	// revoked keys cannot be rotated
+	require.Equal(t, http.StatusConflict, serveJSON(t, router, http.MethodPost, apiKeyPath(created.ID)+"/rotate", "", nil))
+}
+
+func TestApiKeyFiles(t *testing.T) {
+	setupFileTest(t, 1, 2)
+	router, _ := apiKeyRouter(t)
+
+	key := &entity.ApiKey{UserID: 1}
+	key.SetKey("v2.local.key")
+	require.NoError(t, key.Create())
+
+	other := &entity.ApiKey{UserID: 2}
+	other.SetKey("v2.local.other")
+	require.NoError(t, other.Create())
+
+	var uploaded []*entity.File
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file := &entity.File{CID: helloWorldCID, Name: name, Root: "/", Size: 11}
+
+		tx := db.Db().Begin()
+		require.NoError(t, query.TxLockBlob(tx, file.CID))
+		require.NoError(t, createOwnedFile(tx, file, uploader{userID: 1, apiKeyID: &key.ID}))
+		require.NoError(t, tx.Commit().Error)
+
+		uploaded = append(uploaded, file)
+	}
+
+	// files uploaded without the key are not listed
+	uploadTestFile(t, 1, "d.txt")
+
+	var page form.ApiKeyFilesResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodGet, apiKeyPath(key.ID)+"/files?limit=2", "", &page))
+	require.Equal(t, int64(3), page.Total)
+	require.Len(t, page.Files, 2)
+	require.Equal(t, uploaded[2].UID, page.Files[0].UID)
+	require.Equal(t, uploaded[1].UID, page.Files[1].UID)
+
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodGet, apiKeyPath(key.ID)+"/files?limit=2&page=2", "", &page))
+	require.Len(t, page.Files, 1)
+	require.Equal(t, uploaded[0].UID, page.Files[0].UID)
+
+	// deleted files are not listed
+	tx := db.Db().Begin()
+	require.NoError(t, query.DeleteFileByUID(tx, uploaded[0].UID))
+	require.NoError(t, tx.Commit().Error)
+
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodGet, apiKeyPath(key.ID)+"/files", "", &page))
+	require.Equal(t, int64(2), page.Total)
+
+	// files uploaded with keys of other users are not listed
+	require.Equal(t, http.StatusNotFound, serveJSON(t, router, http.MethodGet, apiKeyPath(other.ID)+"/files", "", nil))
+	require.Equal(t, http.StatusBadRequest, serveJSON(t, router, http.MethodGet, apiKeyPath(key.ID)+"/files?page=0&limit=-1", "", nil))
+}
+
+func TestRequestUploader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{UserID: 1})
+	require.Equal(t, uploader{userID: 1}, requestUploader(ctx))
+
+	key := &entity.ApiKey{ID: 7, UserID: 1}
+	ctx.Set(constant.APIKeyContextKey, key)
+	u := requestUploader(ctx)
+	require.Equal(t, uint(1), u.userID)
+	require.Equal(t, uint(7), *u.apiKeyID)
+}
@@ -1,28 +1,36 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/crypto"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/web3"
+	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
 )
 
 var authMutex = sync.Mutex{}
 
-// LoadUser
+// LoadUser returns the user, with the decrypted private key of a custodial
+// wallet. It is only served to login sessions, as no API key scope grants the
+// private key.
 //
 // GET /api/load
 func LoadUser(router *gin.RouterGroup) {
-	router.GET("/load", func(ctx *gin.Context) {
+	router.GET("/load", RequireSession(), func(ctx *gin.Context) {
 		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
 
 		u := query.FindUserWithWallet(authPayload.UserID)
@@ -64,14 +72,49 @@ func LoadUser(router *gin.RouterGroup) {
 	})
 }
 
-// LoginUser
+// errSiweDisabled is returned when neither SIWE_DOMAIN nor SIWE_URI is set.
+var errSiweDisabled = errors.New("sign-in with ethereum is not configured")
+
+// siweOptions returns the values Sign-In with Ethereum messages have to match.
+func siweOptions() web3.SiweOptions {
+	return web3.SiweOptions{
+		Domain:   config.Env().SiweDomain,
+		URI:      config.Env().SiweURI,
+		ChainIDs: config.Env().SiweChainIDs,
+	}
+}
+
+// LoginUser signs in with a Sign-In with Ethereum message, whose nonce can
+// only be used once. The verification method of the signature is recorded as
+// the wallet type.
 //
 // POST /api/login
-func LoginUser(router *gin.RouterGroup, tokenMaker token.Maker) {
+func LoginUser(router *gin.RouterGroup, tokenMaker token.Maker, verifier web3.SignatureVerifier) {
 	router.POST("/login", func(ctx *gin.Context) {
 		var f form.LoginUserRequest
-		if err := ctx.BindJSON(&f); err != nil {
-			AbortBadRequest(ctx)
+		if err := ctx.ShouldBindJSON(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/login:00000001"))
+			return
+		}
+
+		if config.Env().SiweDomain == "" {
+			ctx.JSON(http.StatusServiceUnavailable, ErrorResponse(errSiweDisabled, "/login:00000007"))
+			return
+		}
+
+		msg, err := web3.ParseSiweMessage(f.Message)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/login:00000002"))
+			return
+		}
+
+		if !strings.EqualFold(msg.Address, f.WalletAddress) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(errors.New("message is not signed by the wallet address"), "/login:00000003"))
+			return
+		}
+
+		if err := msg.Verify(siweOptions(), time.Now()); err != nil {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(err, "/login:00000004"))
 			return
 		}
 
@@ -84,62 +127,57 @@ func LoginUser(router *gin.RouterGroup, tokenMaker token.Maker) {
 			return
 		}
 
-		// retrieve nonce
-		nonce, err := u.RetrieveNonce(false, f.Referral)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
-			return
-		}
-
 		// validate signature
-		result := web3.ValidateMessageSignature(
-			f.WalletAddress,
-			f.Signature,
-			constant.BuildLoginMessage(nonce),
-		)
-		if !result {
+		method, err := verifier.VerifySignature(ctx.Request.Context(), msg.ChainID, msg.Address, f.Signature, []byte(f.Message))
+		if errors.Is(err, web3.ErrInvalidSignature) {
 			ctx.JSON(http.StatusBadRequest, "invalide signature")
 			return
+		} else if err != nil {
+			log.Errorf("failed to verify signature: %v", err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/login:00000006"))
+			return
 		}
 
-		// authorization token
-		accessToken, accessPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().AccessTokenDuration,
-		)
+		tx := db.Db().Begin()
+
+		if u.Wallet.Type != string(method) {
+			if err := tx.Model(u.Wallet).Update("type", string(method)).Error; err != nil {
+				tx.Rollback()
+				log.Errorf("failed to update wallet type: %v", err)
+				AbortSaveFailed(ctx)
+				return
+			}
+		}
+
+		consumed, err := query.TxConsumeLoginNonce(tx, msg.Nonce, msg.Address)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
+			tx.Rollback()
+			log.Errorf("failed to consume login nonce: %v", err)
+			AbortUnexpected(ctx)
 			return
 		}
 
-		refreshToken, refreshPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().RefreshTokenDuration,
-		)
+		if !consumed {
+			tx.Rollback()
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errors.New("nonce is invalid, expired or used"), "/login:00000005"))
+			return
+		}
+
+		rsp, err := createLoginSession(tx, ctx, tokenMaker, u, string(entity.Provider))
 		if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to create session: %v", err)
 			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
 			return
 		}
 
-		// TO-DO create session part
-
-		rsp := form.LoginUserResponse{
-			// SessionID:             session.ID,
-			AccessToken:           accessToken,
-			AccessTokenExpiresAt:  accessPayload.ExpiredAt,
-			RefreshToken:          refreshToken,
-			RefreshTokenExpiresAt: refreshPayload.ExpiredAt,
-		}
 		userLogin := &entity.UserLogin{
 			LoginDate:  time.Now(),
 			WalletAddr: u.Wallet.Address,
 		}
 
-		if err := userLogin.Create(); err != nil {
+		if err := userLogin.TxCreate(tx); err != nil {
+			tx.Rollback()
 			log.Errorf("failed to create user login: %v", err)
 			ctx.JSON(
 				http.StatusInternalServerError,
@@ -147,6 +185,13 @@ func LoginUser(router *gin.RouterGroup, tokenMaker token.Maker) {
 			)
 			return
 		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit login: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
 		ctx.JSON(http.StatusOK, rsp)
 	})
 
@@ -187,13 +232,16 @@ func RegisterUser(router *gin.RouterGroup, tokenMaker token.Maker) {
 	})
 }
 
-// RequestNonce
+// RequestNonce creates the user of a wallet if needed and issues a nonce for
+// signing in, with a Sign-In with Ethereum message carrying it.
+//
 // POST /api/nonce
 func RequestNonce(router *gin.RouterGroup) {
 	router.POST("/nonce", func(ctx *gin.Context) {
 		var req struct {
 			WalletAddress string `json:"wallet_address" binding:"required"`
 			ReferrerCode  string `json:"referral"`
+			ChainID       int64  `json:"chain_id"`
 		}
 
 		if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -201,20 +249,71 @@ func RequestNonce(router *gin.RouterGroup) {
 			return
 		}
 
+		if !ethcommon.IsHexAddress(req.WalletAddress) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(errors.New("invalid wallet address"), "/nonce:00000001"))
+			return
+		}
+
+		opts := siweOptions()
+		if opts.Domain == "" {
+			ctx.JSON(http.StatusServiceUnavailable, ErrorResponse(errSiweDisabled, "/nonce:00000003"))
+			return
+		}
+
+		if req.ChainID == 0 {
+			req.ChainID = opts.ChainIDs[0]
+		} else if !slices.Contains(opts.ChainIDs, req.ChainID) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(web3.ErrSiweChain, "/nonce:00000002"))
+			return
+		}
+
 		u := entity.User{
 			Wallet: &entity.Wallet{
 				Address: req.WalletAddress,
 			},
 		}
 
-		nonce, err := u.RetrieveNonce(true, req.ReferrerCode)
-		if err != nil {
+		if _, err := u.RetrieveNonce(false, req.ReferrerCode); err != nil {
 			ctx.JSON(
 				http.StatusInternalServerError,
 				ErrorResponse(err),
 			)
 			return
 		}
-		ctx.JSON(http.StatusOK, nonce)
+
+		if err := query.DeleteExpiredLoginNonces(req.WalletAddress); err != nil {
+			log.Errorf("failed to delete expired login nonces: %v", err)
+		}
+
+		nonce := &entity.LoginNonce{
+			Nonce:     rnd.GenerateRandomString(24),
+			Address:   strings.ToLower(req.WalletAddress),
+			ExpiresAt: time.Now().Add(config.Env().SiweNonceTTL),
+		}
+
+		if err := nonce.Create(); err != nil {
+			log.Errorf("failed to create login nonce: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		msg := web3.SiweMessage{
+			Domain:         opts.Domain,
+			Address:        ethcommon.HexToAddress(req.WalletAddress).Hex(),
+			Statement:      constant.LoginStatement,
+			URI:            opts.URI,
+			Version:        "1",
+			ChainID:        req.ChainID,
+			Nonce:          nonce.Nonce,
+			IssuedAt:       nonce.CreatedAt.Truncate(time.Second),
+			ExpirationTime: &nonce.ExpiresAt,
+		}
+
+		ctx.JSON(http.StatusOK, form.NonceResponse{
+			Nonce:     nonce.Nonce,
+			Message:   msg.String(),
+			IssuedAt:  msg.IssuedAt,
+			ExpiresAt: nonce.ExpiresAt,
+		})
 	})
 }
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
+	"github.com/gin-gonic/gin"
+)
+
+// publishAuthEvent publishes the result of an authentication attempt on the
+// topic "auth.<name>", adding the client of the request to its data.
+func publishAuthEvent(ctx *gin.Context, name string, data event.Data) {
+	data["ip"] = ctx.ClientIP()
+	data["user_agent"] = ctx.Request.UserAgent()
+
+	event.Publish("auth"+event.TopicSep+name, data)
+}
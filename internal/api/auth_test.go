@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiweDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	env := config.Env()
+	t.Cleanup(func() { config.SetEnv(env) })
+	config.SetEnv(config.EnvVar{SiweChainIDs: []int64{1}})
+
+	router := gin.New()
+	RequestNonce(router.Group("/api"))
+	LoginUser(router.Group("/api"), nil, nil)
+
+	const address = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+	require.Equal(t, http.StatusServiceUnavailable, serveJSON(t, router, http.MethodPost, "/api/nonce", `{"wallet_address":"`+address+`"}`, nil))
+	require.Equal(t, http.StatusServiceUnavailable, serveJSON(t, router, http.MethodPost, "/api/login", `{"wallet_address":"`+address+`","message":"m","signature":"0x"}`, nil))
+}
+
+func TestLoadUserRequiresSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testdb.Open(t)
+
+	u := &entity.User{Name: "alice", Wallet: &entity.Wallet{Address: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", AccountType: string(entity.Provider)}}
+	require.NoError(t, db.Db().Create(u).Error)
+
+	testCases := []struct {
+		name   string
+		apiKey *entity.ApiKey
+		scopes []token.Scope
+		status int
+	}{
+		{"session", nil, nil, http.StatusOK},
+		{"default api key", &entity.ApiKey{ID: 1}, token.ReadScopes, http.StatusForbidden},
+		{"api key with all scopes", &entity.ApiKey{ID: 2}, token.Scopes, http.StatusForbidden},
+		{"legacy api key", &entity.ApiKey{ID: 3}, nil, http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(ctx *gin.Context) {
+				ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{UserID: u.ID, Scopes: tc.scopes})
+				if tc.apiKey != nil {
+					ctx.Set(constant.APIKeyContextKey, tc.apiKey)
+				}
+			})
+			LoadUser(router.Group("/api"))
+
+			require.Equal(t, tc.status, serveJSON(t, router, http.MethodGet, "/api/load", "", nil))
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DeleteFile deletes a file owned by the user and refunds its size. A file
+// with other owners is kept for them, and only the user's ownership is
+// deleted. The stored content is deleted once no file references it anymore;
+// content that fails to be deleted is reported by the reconciler.
+//
+// DELETE /api/file/:uid
+func DeleteFile(backend storage.Backend, router *gin.RouterGroup) {
+	router.DELETE("/:uid", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		file, err := query.FindFileByUID(ctx.Param("uid"))
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		isOwner, err := entity.IsFileOwner(file.ID, authPayload.UserID)
+		if err != nil {
+			log.Errorf("failed to check file owner: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+		if !isOwner {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		if err := query.TxLockBlob(tx, file.CID); err != nil {
+			log.Errorf("failed to lock blob %s: %v", file.CID, err)
+			tx.Rollback()
+			AbortDeleteFailed(ctx)
+			return
+		}
+
+		if err := deleteOwnedFile(tx, file, authPayload.UserID); errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			AbortEntityNotFound(ctx)
+			return
+		} else if err != nil {
+			log.Errorf("failed to delete file %s: %v", file.UID, err)
+			tx.Rollback()
+			AbortDeleteFailed(ctx)
+			return
+		}
+
+		if err := query.TxAdjustStorageUsed(tx, authPayload.UserID, &file.ID, -file.Size, entity.UsageFileDeleted); err != nil {
+			log.Errorf("failed to update storage used: %v", err)
+			tx.Rollback()
+			AbortDeleteFailed(ctx)
+			return
+		}
+
+		refs, err := query.TxReleaseBlob(tx, file.CID)
+		if err != nil {
+			log.Errorf("failed to release blob %s: %v", file.CID, err)
+			tx.Rollback()
+			AbortDeleteFailed(ctx)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit delete of %s: %v", file.UID, err)
+			AbortDeleteFailed(ctx)
+			return
+		}
+
+		objectDeleted := false
+		if refs == 0 {
+			objectDeleted, err = deleteUnreferencedObject(ctx.Request.Context(), backend, file.CID)
+			if err != nil {
+				log.Errorf("failed to delete object %s, left to the reconciler: %v", file.CID, err)
+			}
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"uid": file.UID, "object_deleted": objectDeleted})
+	})
+}
+
+// deleteOwnedFile deletes a file owned by a user. The file is kept for its
+// other owners, if any, and only the user's ownership is marked as deleted.
+// The caller must hold the blob lock of the transaction tx.
+func deleteOwnedFile(tx *gorm.DB, file *entity.File, userID uint) error {
+	owners, err := query.TxCountFileOwners(tx, file.ID)
+	if err != nil {
+		return err
+	}
+
+	if owners <= 1 {
+		return query.DeleteFileByUID(tx, file.UID)
+	}
+
+	fileUser, err := query.TxFindFileUser(tx, file.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	// the user's ownership was deleted concurrently
+	if ok, err := fileUser.TxSwapPermission(tx, entity.OwnerPermission, entity.DeletedPermission); err != nil {
+		return err
+	} else if !ok {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// deleteUnreferencedObject deletes the object stored under a CID unless a
+// file references it again, e.g. because the content was uploaded again since
+// its last reference was released. It reports whether the object was deleted.
+func deleteUnreferencedObject(ctx context.Context, backend storage.Backend, objectCID string) (bool, error) {
+	tx := db.Db().Begin()
+
+	if err := query.TxLockBlob(tx, objectCID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if refs, err := query.TxCountBlobRefs(tx, objectCID); err != nil {
+		tx.Rollback()
+		return false, err
+	} else if refs > 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	// the blob lock is held until commit, so no upload can store the object meanwhile
+	if err := backend.Delete(ctx, objectCID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit().Error
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const helloWorldCID = "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"
+
+// setupFileTest opens a test database with the users, a storage backend with
+// the content of helloWorldCID, and a capacity fitting it.
+func setupFileTest(t *testing.T, userIDs ...uint) storage.Backend {
+	testdb.Open(t)
+
+	env := config.Env()
+	t.Cleanup(func() { config.SetEnv(env) })
+	config.SetEnv(config.EnvVar{DefaultStorageCapacity: 1 << 20})
+
+	for _, id := range userIDs {
+		require.NoError(t, db.Db().Create(&entity.UserDetail{UserID: id}).Error)
+	}
+
+	backend, err := storage.NewLocal(t.TempDir(), "http://localhost/api/storage", []byte("secret"))
+	require.NoError(t, err)
+	require.NoError(t, backend.Put(context.Background(), helloWorldCID, strings.NewReader("hello world"), "text/plain"))
+
+	return backend
+}
+
+// uploadTestFile creates a file with the content of helloWorldCID for a user.
+func uploadTestFile(t *testing.T, userID uint, name string) *entity.File {
+	file := &entity.File{CID: helloWorldCID, Name: name, Root: "/", Size: 11}
+
+	tx := db.Db().Begin()
+	require.NoError(t, query.TxLockBlob(tx, file.CID))
+	require.NoError(t, createOwnedFile(tx, file, uploader{userID: userID}))
+	require.NoError(t, tx.Commit().Error)
+
+	return file
+}
+
+func requireStorageUsed(t *testing.T, userID uint, used uint) {
+	t.Helper()
+	require.Equal(t, used, query.FindUserDetailByUserID(userID).StorageUsed)
+}
+
+func requireBlobRefs(t *testing.T, refs int64) {
+	t.Helper()
+	count, err := query.TxCountBlobRefs(db.Db(), helloWorldCID)
+	require.NoError(t, err)
+	require.Equal(t, refs, count)
+}
+
+func TestCreateOwnedFileDeduplicates(t *testing.T) {
+	setupFileTest(t, 1, 2)
+
+	file := uploadTestFile(t, 1, "hello.txt")
+	requireStorageUsed(t, 1, 11)
+	requireBlobRefs(t, 1)
+
+	// uploading a file again keeps it
+	again := uploadTestFile(t, 1, "hello.txt")
+	require.Equal(t, file.ID, again.ID)
+	requireStorageUsed(t, 1, 11)
+	requireBlobRefs(t, 1)
+
+	// another user uploading the file gets a file of their own, which
+	// shares the content
+	other := uploadTestFile(t, 2, "hello.txt")
+	require.NotEqual(t, file.ID, other.ID)
+	require.NotEqual(t, file.UID, other.UID)
+	requireStorageUsed(t, 2, 11)
+	requireBlobRefs(t, 2)
+
+	for _, f := range []*entity.File{file, other} {
+		owners, err := query.TxCountFileOwners(db.Db(), f.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), owners)
+	}
+
+	var files int64
+	require.NoError(t, db.Db().Model(&entity.File{}).Where("c_id = ?", helloWorldCID).Count(&files).Error)
+	require.Equal(t, int64(2), files)
+
+	// uploading it again keeps the user's file
+	again = uploadTestFile(t, 2, "hello.txt")
+	require.Equal(t, other.ID, again.ID)
+	requireBlobRefs(t, 2)
+
+	// a file with another name shares the content
+	renamed := uploadTestFile(t, 2, "copy.txt")
+	require.NotEqual(t, file.ID, renamed.ID)
+	requireStorageUsed(t, 2, 22)
+	requireBlobRefs(t, 3)
+}
+
+func TestDeleteAndRestoreFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	backend := setupFileTest(t, 1, 2)
+
+	file := uploadTestFile(t, 1, "hello.txt")
+	other := uploadTestFile(t, 2, "hello.txt")
+
+	request := func(method, path string, userID uint) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(ctx *gin.Context) {
+			ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{UserID: userID})
+		})
+		DeleteFile(backend, router.Group("/file"))
+		RestoreFile(backend, router.Group("/file"))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	deleted := func(w *httptest.ResponseRecorder) bool {
+		var res struct {
+			ObjectDeleted bool `json:"object_deleted"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.ObjectDeleted
+	}
+
+	// the content is kept for the other user's file
+	w := request(http.MethodDelete, "/file/"+file.UID, 1)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.False(t, deleted(w))
+	requireStorageUsed(t, 1, 0)
+	requireBlobRefs(t, 1)
+
+	_, err := query.FindFileByUID(other.UID)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/file/"+file.UID, 1).Code)
+	require.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/file/"+other.UID, 1).Code)
+
+	w = request(http.MethodPost, "/file/"+file.UID+"/restore", 1)
+	require.Equal(t, http.StatusOK, w.Code)
+	requireStorageUsed(t, 1, 11)
+	requireBlobRefs(t, 2)
+
+	require.Equal(t, http.StatusNotFound, request(http.MethodPost, "/file/"+file.UID+"/restore", 1).Code)
+
+	// the content is deleted with the last file
+	require.False(t, deleted(request(http.MethodDelete, "/file/"+other.UID, 2)))
+	require.True(t, deleted(request(http.MethodDelete, "/file/"+file.UID, 1)))
+	requireStorageUsed(t, 1, 0)
+	requireStorageUsed(t, 2, 0)
+	requireBlobRefs(t, 0)
+
+	_, err = backend.Head(context.Background(), helloWorldCID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.Equal(t, http.StatusGone, request(http.MethodPost, "/file/"+file.UID+"/restore", 1).Code)
+}
+
+func TestDeleteUnreferencedObject(t *testing.T) {
+	backend := setupFileTest(t, 1)
+
+	// the content was uploaded again since its last reference was released
+	uploadTestFile(t, 1, "hello.txt")
+
+	deleted, err := deleteUnreferencedObject(context.Background(), backend, helloWorldCID)
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	_, err = backend.Head(context.Background(), helloWorldCID)
+	require.NoError(t, err)
+}
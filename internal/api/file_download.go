@@ -0,0 +1,241 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// byteRange is an inclusive range of bytes within an object.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// storageRange returns the range to read from the storage backend.
+func (r byteRange) storageRange() *storage.Range {
+	return &storage.Range{Start: r.start, End: r.end}
+}
+
+// contentRange returns the value of the Content-Range response header.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// parseRange parses a Range header for an object of the given size. It returns
+// nil if the whole object should be served, either because no range was
+// requested or because the header is one we choose to ignore (malformed or
+// multiple ranges), which RFC 9110 allows.
+func parseRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || spec == "" || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	// suffix range, e.g. "-500" for the last 500 bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return &byteRange{start: size - n, end: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+
+	return &byteRange{start: start, end: end}, nil
+}
+
+// etagMatches reports whether etag is listed in an If-None-Match or If-Range
+// header value. Weak comparison ignores the W/ prefix on both sides.
+func etagMatches(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		} else if strings.HasPrefix(candidate, "W/") || strings.HasPrefix(etag, "W/") {
+			continue
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since for a GET request.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag, true)
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+// rangeApplies evaluates If-Range, which makes the Range header conditional on
+// the representation not having changed since the client cached it.
+func rangeApplies(r *http.Request, etag string, lastModified time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etagMatches(ir, etag, false)
+	}
+
+	t, err := http.ParseTime(ir)
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+
+	return lastModified.Truncate(time.Second).Equal(t)
+}
+
+// DownloadFile streams a file from the storage backend to a user who has access to it.
+// Range, If-Range, If-None-Match and If-Modified-Since are honoured so that
+// clients can resume interrupted downloads.
+//
+// GET /api/file/:uid/download
+func DownloadFile(backend storage.Backend, router *gin.RouterGroup) {
+	router.GET("/:uid/download", RequireScope(token.ScopeFilesRead), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		file, err := query.FindFileByUID(ctx.Param("uid"))
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		if _, err := query.FindFileUser(file.ID, authPayload.UserID); err != nil {
+			// do not reveal that the file exists
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		head, err := backend.Head(ctx.Request.Context(), file.CID)
+		if err != nil {
+			log.Errorf("failed to stat %s: %v", file.CID, err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(errors.New("file content is unavailable"), "/file/download:00000001"))
+			return
+		}
+
+		size := head.Size
+		etag := head.ETag
+		lastModified := head.LastModified
+
+		headers := ctx.Writer.Header()
+		headers.Set("Accept-Ranges", "bytes")
+		if etag != "" {
+			headers.Set("ETag", etag)
+		}
+		if !lastModified.IsZero() {
+			headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+
+		if notModified(ctx.Request, etag, lastModified) {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+
+		var rng *byteRange
+		if rangeApplies(ctx.Request, etag, lastModified) {
+			rng, err = parseRange(ctx.GetHeader("Range"), size)
+			if err != nil {
+				headers.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				ctx.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+
+		status := http.StatusOK
+		length := size
+		var objectRange *storage.Range
+		if rng != nil {
+			status = http.StatusPartialContent
+			length = rng.length()
+			objectRange = rng.storageRange()
+			headers.Set("Content-Range", rng.contentRange(size))
+		}
+
+		body, err := backend.Get(ctx.Request.Context(), file.CID, objectRange)
+		if err != nil {
+			log.Errorf("failed to get %s: %v", file.CID, err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(errors.New("file content is unavailable"), "/file/download:00000002"))
+			return
+		}
+		defer body.Close()
+
+		contentType := file.Mime
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		ctx.DataFromReader(status, length, contentType, body, map[string]string{
+			"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}),
+		})
+	})
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		size   int64
+		want   *byteRange
+		err    error
+	}{
+		{name: "no range", header: "", size: 100},
+		{name: "first bytes", header: "bytes=0-9", size: 100, want: &byteRange{0, 9}},
+		{name: "open end", header: "bytes=90-", size: 100, want: &byteRange{90, 99}},
+		{name: "end clamped", header: "bytes=50-500", size: 100, want: &byteRange{50, 99}},
+		{name: "suffix", header: "bytes=-10", size: 100, want: &byteRange{90, 99}},
+		{name: "suffix larger than object", header: "bytes=-500", size: 100, want: &byteRange{0, 99}},
+		{name: "start past end", header: "bytes=100-", size: 100, err: errRangeNotSatisfiable},
+		{name: "empty object", header: "bytes=-1", size: 0, err: errRangeNotSatisfiable},
+		{name: "multiple ranges ignored", header: "bytes=0-1,5-6", size: 100},
+		{name: "other unit ignored", header: "items=0-1", size: 100},
+		{name: "malformed ignored", header: "bytes=9-1", size: 100},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRange(tc.header, tc.size)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestConditionalHeaders(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	etag := `"abc"`
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"xyz", W/"abc"`)
+	require.True(t, notModified(req, etag, lastModified))
+
+	req, _ = http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+	require.True(t, notModified(req, etag, lastModified))
+
+	req, _ = http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat))
+	require.False(t, notModified(req, etag, lastModified))
+
+	req, _ = http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Range", `"abc"`)
+	require.True(t, rangeApplies(req, etag, lastModified))
+
+	req.Header.Set("If-Range", `W/"abc"`)
+	require.False(t, rangeApplies(req, etag, lastModified))
+
+	req.Header.Set("If-Range", lastModified.Add(time.Hour).Format(http.TimeFormat))
+	require.False(t, rangeApplies(req, etag, lastModified))
+}
@@ -0,0 +1,485 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/ipfs/go-cid"
+	"gorm.io/gorm"
+)
+
+// errMultipartAborted is returned when the janitor aborted an upload while it
+// was completed, taking it for abandoned.
+var errMultipartAborted = errors.New("upload was aborted")
+
+const (
+	// multipartMinPartSize is the part size used unless the file needs larger
+	// parts to fit in multipartMaxParts.
+	multipartMinPartSize = 16 << 20
+	// multipartMaxParts is the maximum number of parts S3 accepts in one upload.
+	multipartMaxParts = 10000
+	// multipartMaxSize is the maximum size of an S3 object.
+	multipartMaxSize = 5 << 40
+)
+
+// multipartPartSize returns the size of every part but the last for a file of
+// the given size, rounded up to a whole MiB.
+func multipartPartSize(size int64) int64 {
+	partSize := (size + multipartMaxParts - 1) / multipartMaxParts
+	if partSize < multipartMinPartSize {
+		return multipartMinPartSize
+	}
+
+	return (partSize + 1<<20 - 1) &^ (1<<20 - 1)
+}
+
+// multipartPartsCount returns the number of parts a file of the given size is split into.
+func multipartPartsCount(size int64) int32 {
+	partSize := multipartPartSize(size)
+	return int32((size + partSize - 1) / partSize)
+}
+
+// checkUploadedParts verifies that the backend received every part of the
+// upload, with the expected sizes.
+func checkUploadedParts(parts []storage.Part, size int64) error {
+	partSize := multipartPartSize(size)
+	count := multipartPartsCount(size)
+
+	if len(parts) != int(count) {
+		return fmt.Errorf("expected %d parts, received %d", count, len(parts))
+	}
+
+	var total int64
+	for i, p := range parts {
+		if p.PartNumber != int32(i+1) {
+			return fmt.Errorf("part %d is missing", i+1)
+		}
+		if p.PartNumber < count && p.Size != partSize {
+			return fmt.Errorf("part %d has %d bytes, expected %d", p.PartNumber, p.Size, partSize)
+		}
+		total += p.Size
+	}
+
+	if total != size {
+		return fmt.Errorf("expected %d bytes, received %d", size, total)
+	}
+
+	return nil
+}
+
+func multipartUploadResponse(m *entity.MultipartUpload) form.MultipartUploadResponse {
+	return form.MultipartUploadResponse{
+		MultipartUpload: m,
+		PartSize:        multipartPartSize(m.Size),
+		PartsCount:      multipartPartsCount(m.Size),
+	}
+}
+
+// multipartFile returns the file a multipart upload creates.
+func multipartFile(m *entity.MultipartUpload) *entity.File {
+	isInPool := true
+	return &entity.File{
+		Name:                 m.Name,
+		Root:                 m.Root,
+		Path:                 m.Path,
+		CID:                  m.CID,
+		CIDOriginalEncrypted: m.CIDOriginalEncrypted,
+		Mime:                 m.Mime,
+		Size:                 m.Size,
+		IsInPool:             &isInPool,
+		EncryptionStatus:     m.EncryptionStatus,
+	}
+}
+
+// syncMultipartParts records the parts the backend has received for an upload.
+func syncMultipartParts(ctx *gin.Context, backend storage.Backend, m *entity.MultipartUpload) ([]storage.Part, error) {
+	uploaded, err := backend.ListParts(ctx.Request.Context(), m.ObjectKey, m.UploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Parts = make([]entity.MultipartUploadPart, 0, len(uploaded))
+	for _, p := range uploaded {
+		part := entity.MultipartUploadPart{
+			MultipartUploadID: m.ID,
+			PartNumber:        p.PartNumber,
+			ETag:              p.ETag,
+			Size:              p.Size,
+		}
+		if err := part.Upsert(); err != nil {
+			return nil, err
+		}
+		m.Parts = append(m.Parts, part)
+	}
+
+	return uploaded, nil
+}
+
+// MultipartUpload orchestrates multipart uploads of large files. The client
+// uploads each part to a presigned URL, and the proxy tracks the upload so it
+// can be resumed from another session. The parts are assembled under a staging
+// key, and the content is verified against its CID before a file is created.
+//
+// POST   /api/file/multipart
+// GET    /api/file/multipart
+// GET    /api/file/multipart/:uid
+// POST   /api/file/multipart/:uid/parts/:part_number
+// POST   /api/file/multipart/:uid/complete
+// DELETE /api/file/multipart/:uid
+func MultipartUpload(backend storage.Backend, router *gin.RouterGroup) {
+	router.POST("/multipart", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		var f form.CreateMultipartUploadRequest
+		if err := ctx.ShouldBindJSON(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/multipart:00000001"))
+			return
+		}
+
+		if f.Size > multipartMaxSize {
+			ctx.JSON(
+				http.StatusRequestEntityTooLarge,
+				ErrorResponse(fmt.Errorf("size exceeds %d bytes", int64(multipartMaxSize)), "/file/multipart:00000002"),
+			)
+			return
+		}
+
+		if f.Root == "" {
+			f.Root = "/"
+		}
+
+		if f.EncryptionStatus == "" {
+			f.EncryptionStatus = entity.Public
+		}
+
+		if f.MimeType == "" {
+			f.MimeType = "application/octet-stream"
+		}
+
+		if status, err := checkUploadTarget(authPayload.UserID, f.CID, f.Root, f.EncryptionStatus); err != nil {
+			ctx.JSON(status, ErrorResponse(err, "/file/multipart:00000003"))
+			return
+		}
+
+		if _, err := verifiableCID(f.CID); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/multipart:00000020"))
+			return
+		}
+
+		m := entity.MultipartUpload{
+			UserID:           authPayload.UserID,
+			ObjectKey:        stagingKey(), // verified before it is stored under the CID
+			CID:              f.CID,
+			Name:             f.Name,
+			Root:             f.Root,
+			Path:             f.Path,
+			Mime:             f.MimeType,
+			Size:             f.Size,
+			EncryptionStatus: f.EncryptionStatus,
+			Status:           entity.MultipartInProgress,
+			Parts:            []entity.MultipartUploadPart{},
+		}
+		if f.CIDOriginalEncrypted != "" {
+			m.CIDOriginalEncrypted = &f.CIDOriginalEncrypted
+		}
+
+		// the content is already stored, so the upload completes right away
+		file := multipartFile(&m)
+		if attached, err := attachStoredFile(file, requestUploader(ctx)); respondQuotaExceeded(ctx, err, "/file/multipart:00000018") {
+			return
+		} else if err != nil {
+			log.Errorf("failed to attach %s: %v", f.CID, err)
+			AbortSaveFailed(ctx)
+			return
+		} else if attached {
+			m.Size = file.Size
+			m.Status = entity.MultipartCompleted
+			m.FileID = &file.ID
+			if err := m.Create(); err != nil {
+				log.Errorf("failed to create multipart upload: %v", err)
+				AbortSaveFailed(ctx)
+				return
+			}
+
+			ctx.JSON(http.StatusOK, multipartUploadResponse(&m))
+			return
+		}
+
+		if code, err := checkObjectWritable(authPayload.UserID, f.CID); err != nil {
+			ctx.JSON(code, ErrorResponse(err, "/file/multipart:00000016"))
+			return
+		}
+
+		if !checkQuota(ctx, authPayload.UserID, f.Size, "/file/multipart:00000018") {
+			return
+		}
+
+		uploadID, err := backend.CreateMultipart(ctx.Request.Context(), m.ObjectKey, f.MimeType)
+		if err != nil {
+			log.Errorf("failed to create multipart upload: %v", err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/multipart:00000004"))
+			return
+		}
+
+		m.UploadID = uploadID
+
+		if err := m.Create(); err != nil {
+			log.Errorf("failed to create multipart upload: %v", err)
+			if err := backend.AbortMultipart(ctx.Request.Context(), m.ObjectKey, uploadID); err != nil {
+				log.Errorf("failed to abort multipart upload: %v", err)
+			}
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, multipartUploadResponse(&m))
+	})
+
+	router.GET("/multipart", RequireScope(token.ScopeFilesRead), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		uploads, err := query.FindMultipartUploadsInProgress(authPayload.UserID)
+		if err != nil {
+			log.Errorf("failed to find multipart uploads: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		resp := make([]form.MultipartUploadResponse, 0, len(uploads))
+		for i := range uploads {
+			resp = append(resp, multipartUploadResponse(&uploads[i]))
+		}
+
+		ctx.JSON(http.StatusOK, resp)
+	})
+
+	router.GET("/multipart/:uid", RequireScope(token.ScopeFilesRead), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		m, err := query.FindMultipartUpload(ctx.Param("uid"), authPayload.UserID)
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		if m.Status == entity.MultipartInProgress {
+			// the client may have uploaded parts we have not seen yet
+			if _, err := syncMultipartParts(ctx, backend, m); err != nil {
+				log.Errorf("failed to list parts of %s: %v", m.UID, err)
+				ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/multipart:00000005"))
+				return
+			}
+
+			if err := m.Touch(); err != nil {
+				log.Errorf("failed to touch multipart upload %s: %v", m.UID, err)
+			}
+		}
+
+		ctx.JSON(http.StatusOK, multipartUploadResponse(m))
+	})
+
+	router.POST("/multipart/:uid/parts/:part_number", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		m, err := query.FindMultipartUpload(ctx.Param("uid"), authPayload.UserID)
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		if m.Status != entity.MultipartInProgress {
+			ctx.JSON(http.StatusConflict, ErrorResponse(fmt.Errorf("upload is %s", m.Status), "/file/multipart:00000006"))
+			return
+		}
+
+		partNumber, err := strconv.ParseInt(ctx.Param("part_number"), 10, 32)
+		if err != nil || partNumber < 1 || int32(partNumber) > multipartPartsCount(m.Size) {
+			ctx.JSON(
+				http.StatusBadRequest,
+				ErrorResponse(fmt.Errorf("part_number must be between 1 and %d", multipartPartsCount(m.Size)), "/file/multipart:00000007"),
+			)
+			return
+		}
+
+		expiry := config.Env().PresignDefaultExpiry
+
+		presignedRequest, err := backend.PresignPart(ctx.Request.Context(), m.ObjectKey, m.UploadID, int32(partNumber), expiry)
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/multipart:00000008"))
+			return
+		}
+
+		if err := m.Touch(); err != nil {
+			log.Errorf("failed to touch multipart upload %s: %v", m.UID, err)
+		}
+
+		ctx.JSON(http.StatusOK, form.MultipartPartURLResponse{
+			PartNumber:   int32(partNumber),
+			PresignedURL: presignedRequest.URL,
+			Method:       presignedRequest.Method,
+			Headers:      presignedRequest.Header,
+			ExpiresAt:    time.Now().Add(expiry),
+		})
+	})
+
+	router.POST("/multipart/:uid/complete", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		m, err := query.FindMultipartUpload(ctx.Param("uid"), authPayload.UserID)
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		if m.Status != entity.MultipartInProgress {
+			ctx.JSON(http.StatusConflict, ErrorResponse(fmt.Errorf("upload is %s", m.Status), "/file/multipart:00000009"))
+			return
+		}
+
+		// concurrent requests must not assemble the parts twice
+		if claimed, err := m.SwapStatus(entity.MultipartInProgress, entity.MultipartCompleting); err != nil {
+			log.Errorf("failed to claim multipart upload %s: %v", m.UID, err)
+			AbortSaveFailed(ctx)
+			return
+		} else if !claimed {
+			ctx.JSON(http.StatusConflict, ErrorResponse(errors.New("upload is no longer in progress"), "/file/multipart:00000009"))
+			return
+		}
+
+		// the upload can be completed again until its parts are assembled, and
+		// the assembled content is only needed until it is promoted
+		assembled, completed := false, false
+		defer func() {
+			if assembled {
+				if err := backend.Delete(context.WithoutCancel(ctx.Request.Context()), m.ObjectKey); err != nil {
+					log.Errorf("failed to delete assembled upload %s: %v", m.ObjectKey, err)
+				}
+			}
+
+			if completed {
+				return
+			}
+
+			status := entity.MultipartInProgress
+			if assembled {
+				status = entity.MultipartAborted
+			}
+
+			if _, err := m.SwapStatus(entity.MultipartCompleting, status); err != nil {
+				log.Errorf("failed to update multipart upload %s: %v", m.UID, err)
+			}
+		}()
+
+		// another user may have claimed the object since the upload started
+		if code, err := checkObjectWritable(authPayload.UserID, m.CID); err != nil {
+			ctx.JSON(code, ErrorResponse(err, "/file/multipart:00000010"))
+			return
+		}
+
+		if !checkQuota(ctx, authPayload.UserID, m.Size, "/file/multipart:00000018") {
+			return
+		}
+
+		parts, err := syncMultipartParts(ctx, backend, m)
+		if err != nil {
+			log.Errorf("failed to list parts of %s: %v", m.UID, err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/multipart:00000011"))
+			return
+		}
+
+		if err := checkUploadedParts(parts, m.Size); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/multipart:00000012"))
+			return
+		}
+
+		if err := backend.CompleteMultipart(ctx.Request.Context(), m.ObjectKey, m.UploadID, parts); err != nil {
+			log.Errorf("failed to complete multipart upload %s: %v", m.UID, err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/multipart:00000013"))
+			return
+		}
+		assembled = true
+
+		if _, err := verifyStagedObject(ctx.Request.Context(), backend, m.ObjectKey, cid.MustParse(m.CID)); errors.Is(err, errCIDMismatch) {
+			ctx.JSON(http.StatusUnprocessableEntity, ErrorResponse(err, "/file/multipart:00000021"))
+			return
+		} else if err != nil {
+			log.Errorf("failed to verify multipart upload %s: %v", m.UID, err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/multipart:00000022"))
+			return
+		}
+
+		file := multipartFile(m)
+		u := requestUploader(ctx)
+
+		err = promoteStaged(ctx.Request.Context(), backend, m.ObjectKey, file.CID, file.Size, func(tx *gorm.DB) error {
+			if err := createOwnedFile(tx, file, u); err != nil {
+				return err
+			}
+
+			m.FileID = &file.ID
+			if swapped, err := m.TxSwapStatus(tx, entity.MultipartCompleting, entity.MultipartCompleted); err != nil {
+				return err
+			} else if !swapped {
+				return errMultipartAborted
+			}
+
+			return nil
+		})
+		if respondQuotaExceeded(ctx, err, "/file/multipart:00000018") {
+			return
+		} else if errors.Is(err, errMultipartAborted) {
+			ctx.JSON(http.StatusConflict, ErrorResponse(err, "/file/multipart:00000019"))
+			return
+		} else if err != nil {
+			log.Errorf("failed to complete multipart upload %s: %v", m.UID, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+		completed = true
+
+		ctx.JSON(http.StatusOK, fileResponse(file))
+	})
+
+	router.DELETE("/multipart/:uid", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		m, err := query.FindMultipartUpload(ctx.Param("uid"), authPayload.UserID)
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		// a concurrent request may be completing the upload
+		if aborted, err := m.SwapStatus(entity.MultipartInProgress, entity.MultipartAborted); err != nil {
+			log.Errorf("failed to update multipart upload %s: %v", m.UID, err)
+			AbortSaveFailed(ctx)
+			return
+		} else if !aborted {
+			ctx.JSON(http.StatusConflict, ErrorResponse(errors.New("upload is not in progress"), "/file/multipart:00000014"))
+			return
+		}
+
+		if err := backend.AbortMultipart(ctx.Request.Context(), m.ObjectKey, m.UploadID); err != nil {
+			log.Errorf("failed to abort multipart upload %s: %v", m.UID, err)
+			if _, err := m.SwapStatus(entity.MultipartAborted, entity.MultipartInProgress); err != nil {
+				log.Errorf("failed to update multipart upload %s: %v", m.UID, err)
+			}
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/multipart:00000015"))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, multipartUploadResponse(m))
+	})
+}
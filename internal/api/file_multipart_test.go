@@ -0,0 +1,135 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipartPartSize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		size     int64
+		partSize int64
+		count    int32
+	}{
+		{"small file", 1, multipartMinPartSize, 1},
+		{"exact part", multipartMinPartSize, multipartMinPartSize, 1},
+		{"two parts", multipartMinPartSize + 1, multipartMinPartSize, 2},
+		{"10 GB", 10 << 30, multipartMinPartSize, 640},
+		{"1 TB", 1 << 40, 105 << 20, 9987},
+		{"max size", multipartMaxSize, 525 << 20, 9987},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.partSize, multipartPartSize(tc.size))
+			require.Equal(t, tc.count, multipartPartsCount(tc.size))
+			require.LessOrEqual(t, tc.count, int32(multipartMaxParts))
+		})
+	}
+}
+
+func TestCheckUploadedParts(t *testing.T) {
+	const size = 2*multipartMinPartSize + 10
+
+	testCases := []struct {
+		name  string
+		parts []storage.Part
+		ok    bool
+	}{
+		{
+			name: "complete",
+			parts: []storage.Part{
+				{PartNumber: 1, Size: multipartMinPartSize},
+				{PartNumber: 2, Size: multipartMinPartSize},
+				{PartNumber: 3, Size: 10},
+			},
+			ok: true,
+		},
+		{
+			name: "missing part",
+			parts: []storage.Part{
+				{PartNumber: 1, Size: multipartMinPartSize},
+				{PartNumber: 3, Size: 10},
+			},
+		},
+		{
+			name: "gap in part numbers",
+			parts: []storage.Part{
+				{PartNumber: 1, Size: multipartMinPartSize},
+				{PartNumber: 3, Size: multipartMinPartSize},
+				{PartNumber: 4, Size: 10},
+			},
+		},
+		{
+			name: "short part",
+			parts: []storage.Part{
+				{PartNumber: 1, Size: multipartMinPartSize},
+				{PartNumber: 2, Size: multipartMinPartSize - 1},
+				{PartNumber: 3, Size: 11},
+			},
+		},
+		{
+			name: "wrong total",
+			parts: []storage.Part{
+				{PartNumber: 1, Size: multipartMinPartSize},
+				{PartNumber: 2, Size: multipartMinPartSize},
+				{PartNumber: 3, Size: 9},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkUploadedParts(tc.parts, size)
+			if tc.ok {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMultipartUploadScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testdb.Open(t)
+
+	backend, err := storage.NewLocal(t.TempDir(), "http://localhost/api/storage", []byte("secret"))
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{UserID: 1, Scopes: token.ReadScopes})
+	})
+	MultipartUpload(backend, router.Group("/file"))
+
+	testCases := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/file/multipart", http.StatusOK},
+		{http.MethodGet, "/file/multipart/unknown", http.StatusNotFound},
+		{http.MethodPost, "/file/multipart", http.StatusForbidden},
+		{http.MethodPost, "/file/multipart/unknown/complete", http.StatusForbidden},
+		{http.MethodDelete, "/file/multipart/unknown", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}")))
+			require.Equal(t, tc.status, w.Code)
+		})
+	}
+}
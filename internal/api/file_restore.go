@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/quota"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RestoreFile restores a file the user deleted and charges its size again.
+// That is either a deleted file the user owned, or the user's ownership of a
+// file that was kept for its other owners. Files can only be restored while
+// their content is still stored, which is the case as long as another file
+// shares it.
+//
+// POST /api/file/:uid/restore
+func RestoreFile(backend storage.Backend, router *gin.RouterGroup) {
+	router.POST("/:uid/restore", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		file, fileUser, err := findRestorableFile(ctx.Param("uid"), authPayload.UserID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			AbortEntityNotFound(ctx)
+			return
+		} else if err != nil {
+			log.Errorf("failed to find deleted file: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		if err := query.TxLockBlob(tx, file.CID); err != nil {
+			log.Errorf("failed to lock blob %s: %v", file.CID, err)
+			tx.Rollback()
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		// the content is deleted with the last file referencing it
+		if _, err := backend.Head(ctx.Request.Context(), file.CID); errors.Is(err, storage.ErrNotFound) {
+			tx.Rollback()
+			ctx.JSON(http.StatusGone, ErrorResponse(errors.New("content of the file is no longer stored"), "/file/restore:00000001"))
+			return
+		} else if err != nil {
+			log.Errorf("failed to stat %s: %v", file.CID, err)
+			tx.Rollback()
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/restore:00000002"))
+			return
+		}
+
+		if err := quota.TxCheck(tx, authPayload.UserID, file.Size); respondQuotaExceeded(ctx, err, "/file/restore:00000003") {
+			tx.Rollback()
+			return
+		} else if err != nil {
+			log.Errorf("failed to check quota: %v", err)
+			tx.Rollback()
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if _, err := query.TxAcquireBlob(tx, file.CID, file.Size); err != nil {
+			log.Errorf("failed to acquire blob %s: %v", file.CID, err)
+			tx.Rollback()
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := restoreOwnedFile(tx, file, fileUser); errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			AbortEntityNotFound(ctx)
+			return
+		} else if err != nil {
+			log.Errorf("failed to restore file %s: %v", file.UID, err)
+			tx.Rollback()
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := query.TxAdjustStorageUsed(tx, authPayload.UserID, &file.ID, file.Size, entity.UsageFileRestored); err != nil {
+			log.Errorf("failed to update storage used: %v", err)
+			tx.Rollback()
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit restore of %s: %v", file.UID, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, fileResponse(file))
+	})
+}
+
+// findRestorableFile returns a file the user deleted: either a deleted file
+// the user owns, or a file kept for its other owners, together with the
+// user's relation to it that was marked as deleted.
+func findRestorableFile(uid string, userID uint) (*entity.File, *entity.FileUser, error) {
+	if file, err := query.FindDeletedFileByUID(uid); err == nil {
+		isOwner, err := entity.IsFileOwner(file.ID, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isOwner {
+			return nil, nil, gorm.ErrRecordNotFound
+		}
+
+		return file, nil, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+
+	file, err := query.FindFileByUID(uid)
+	if err != nil {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	fileUser, err := query.TxFindFileUser(db.Db(), file.ID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fileUser.Permission != entity.DeletedPermission {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	return file, fileUser, nil
+}
+
+// restoreOwnedFile restores a deleted file, or the user's ownership of a file
+// if fileUser is the relation that was marked as deleted.
+func restoreOwnedFile(tx *gorm.DB, file *entity.File, fileUser *entity.FileUser) error {
+	if fileUser == nil {
+		return query.TxRestoreFile(tx, file)
+	}
+
+	// the user's ownership was restored concurrently
+	if ok, err := fileUser.TxSwapPermission(tx, entity.DeletedPermission, entity.OwnerPermission); err != nil {
+		return err
+	} else if !ok {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,434 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/quota"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/contentid"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/ipfs/go-cid"
+	"gorm.io/gorm"
+)
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// checkUploadTarget validates the object key, folder and encryption status of
+// a new file, returning the http status to respond with if they are not acceptable.
+func checkUploadTarget(userID uint, objectCID, root string, status entity.EncryptionStatus) (int, error) {
+	if status != entity.Public && status != entity.Encrypted {
+		return http.StatusBadRequest, fmt.Errorf("invalid encryption status: %s", status)
+	}
+
+	if err := validObjectCID(objectCID); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if root == "/" {
+		return http.StatusOK, nil
+	}
+
+	folder, err := query.FindFolderByUID(root)
+	if err != nil {
+		return http.StatusNotFound, errors.New("root folder not found")
+	}
+
+	isOwner, err := entity.IsFolderOwner(folder.ID, userID)
+	if err != nil {
+		log.Errorf("failed to check folder owner: %v", err)
+		return http.StatusInternalServerError, errors.New("failed to check folder owner")
+	}
+	if !isOwner {
+		return http.StatusForbidden, errors.New("permission denied")
+	}
+
+	return http.StatusOK, nil
+}
+
+// uploader is who creates a file: a user, and the API key the request was
+// authorized by, if any.
+type uploader struct {
+	userID   uint
+	apiKeyID *uint
+}
+
+// requestUploader returns the uploader of the files created by a request.
+func requestUploader(ctx *gin.Context) uploader {
+	authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+	u := uploader{userID: authPayload.UserID}
+	if apiKey, ok := ctx.Get(constant.APIKeyContextKey); ok {
+		u.apiKeyID = &apiKey.(*entity.ApiKey).ID
+	}
+
+	return u
+}
+
+// createOwnedFile creates a file owned by a user, referencing the blob stored
+// under its CID, and charges its size to the user's storage. Users uploading
+// the same content get files of their own, which share the blob. If the user
+// has a file with the same content, name and parent folder, file is set to it
+// instead, and the user owns it again if they deleted it. Files uploaded with
+// an API key are recorded for the key. It returns an *quota.ExceededError if
+// the file does not fit into the user's quota. The caller must hold the blob
+// lock of the transaction tx.
+func createOwnedFile(tx *gorm.DB, file *entity.File, u uploader) error {
+	duplicate, err := query.TxFindDuplicateFile(tx, file, u.userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return addFileOwner(tx, file, nil, u)
+	} else if err != nil {
+		return err
+	}
+
+	*file = *duplicate
+
+	fileUser, err := query.TxFindFileUser(tx, file.ID, u.userID)
+	if err != nil {
+		return err
+	}
+
+	// the user already owns the file
+	if fileUser.Permission == entity.OwnerPermission {
+		return nil
+	}
+
+	return addFileOwner(tx, file, fileUser, u)
+}
+
+// addFileOwner makes a user an owner of a file, creating the file if it has
+// no ID yet, and the relation between the file and the user if fileUser is
+// nil. See createOwnedFile.
+func addFileOwner(tx *gorm.DB, file *entity.File, fileUser *entity.FileUser, u uploader) error {
+	userID := u.userID
+
+	if err := quota.TxCheck(tx, userID, file.Size); err != nil {
+		return err
+	}
+
+	if _, err := query.TxAcquireBlob(tx, file.CID, file.Size); err != nil {
+		return err
+	}
+
+	if file.ID == 0 {
+		if err := file.TxCreate(tx); err != nil {
+			return err
+		}
+	}
+
+	if fileUser == nil {
+		fileUser = &entity.FileUser{
+			FileID:     file.ID,
+			UserID:     userID,
+			Permission: entity.OwnerPermission,
+		}
+
+		if err := fileUser.TxCreate(tx); err != nil {
+			return err
+		}
+	} else if ok, err := fileUser.TxSwapPermission(tx, fileUser.Permission, entity.OwnerPermission); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("permission of file %s changed concurrently", file.UID)
+	}
+
+	if u.apiKeyID != nil {
+		apiKeyFile := entity.ApiKeyFile{
+			FileID:   file.ID,
+			UserID:   userID,
+			ApiKeyID: u.apiKeyID,
+		}
+
+		if err := apiKeyFile.TxCreate(tx); err != nil {
+			return err
+		}
+	}
+
+	return query.TxAdjustStorageUsed(tx, userID, &file.ID, file.Size, entity.UsageFileCreated)
+}
+
+// attachStoredFile creates a file for content that is already stored and
+// verified, so it does not have to be uploaded again. It returns false if no
+// verified blob is stored under the file CID.
+func attachStoredFile(file *entity.File, u uploader) (bool, error) {
+	tx := db.Db().Begin()
+
+	if err := query.TxLockBlob(tx, file.CID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	blob, err := query.TxFindBlobByCID(tx, file.CID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !blob.Verified) {
+		tx.Rollback()
+		return false, nil
+	} else if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	file.Size = blob.Size
+
+	if err := createOwnedFile(tx, file, u); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit().Error
+}
+
+// stagingKey returns a new object key to store unverified content under.
+func stagingKey() string {
+	return storage.StagingPrefix + rnd.GenerateRandomString(32)
+}
+
+// errCIDMismatch is returned for content that does not hash to its claimed CID.
+var errCIDMismatch = errors.New("content does not match cid")
+
+// verifyStagedObject reads the content staged under key and checks that it
+// hashes to the claimed CID, returning its size.
+func verifyStagedObject(ctx context.Context, backend storage.Backend, key string, claimed cid.Cid) (int64, error) {
+	r, err := backend.Get(ctx, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	hasher := contentid.NewHasher()
+	size, err := io.Copy(hasher, r)
+	if err != nil {
+		return 0, err
+	}
+
+	computed, err := hasher.CID()
+	if err != nil {
+		return 0, err
+	}
+
+	if !computed.Equals(claimed) {
+		return 0, fmt.Errorf("%w %s, computed %s", errCIDMismatch, claimed, computed)
+	}
+
+	return size, nil
+}
+
+// promoteStaged stores verified content that was staged under key under its
+// CID, replacing unverified content stored there, unless verified content was
+// stored meanwhile. fn is called as part of the transaction before the blob is
+// marked as verified, e.g. to create the file referencing it.
+func promoteStaged(ctx context.Context, backend storage.Backend, key, objectCID string, size int64, fn func(tx *gorm.DB) error) error {
+	tx := db.Db().Begin()
+
+	if err := query.TxLockBlob(tx, objectCID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	blob, err := query.TxFindBlobByCID(tx, objectCID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		return err
+	}
+
+	if blob == nil || !blob.Verified {
+		if err := backend.Copy(ctx, key, objectCID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := query.TxVerifyBlob(tx, objectCID, size); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// promoteStagedFile creates a file for verified content that was staged under
+// key, see promoteStaged.
+func promoteStagedFile(ctx context.Context, backend storage.Backend, key string, file *entity.File, u uploader) error {
+	return promoteStaged(ctx, backend, key, file.CID, file.Size, func(tx *gorm.DB) error {
+		return createOwnedFile(tx, file, u)
+	})
+}
+
+// fileResponse returns the API representation of a file.
+func fileResponse(file *entity.File) form.FileResponse {
+	return form.FileResponse{
+		ID:                   file.ID,
+		Name:                 file.Name,
+		UID:                  file.UID,
+		Root:                 file.Root,
+		CID:                  file.CID,
+		CIDOriginalEncrypted: file.CIDOriginalEncrypted,
+		Mime:                 file.Mime,
+		Size:                 file.Size,
+		EnryptionStatus:      file.EncryptionStatus,
+		IsInPool:             file.IsInPool,
+		CreatedAt:            file.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:            file.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// PutUploadFile streams the request body into the storage backend and
+// registers the file for the authenticated user. The CID of the content is
+// computed while it is stored, and the upload is rejected if it does not match
+// the claimed CID. If verified content with the same CID is already stored,
+// the file shares it and the body is not read.
+//
+// PUT /api/file/upload?name=...&cid=...
+func PutUploadFile(backend storage.Backend, router *gin.RouterGroup) {
+	router.PUT("/upload", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		var f form.FileUploadRequest
+		if err := ctx.ShouldBindQuery(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/upload:00000001"))
+			return
+		}
+
+		if f.Root == "" {
+			f.Root = "/"
+		}
+
+		if f.EncryptionStatus == "" {
+			f.EncryptionStatus = entity.Public
+		}
+
+		if status, err := checkUploadTarget(authPayload.UserID, f.CID, f.Root, f.EncryptionStatus); err != nil {
+			ctx.JSON(status, ErrorResponse(err, "/file/upload:00000002"))
+			return
+		}
+
+		// only content addressed by its raw sha2-256 hash can be checked
+		claimed := cid.MustParse(f.CID)
+		if !contentid.Verifiable(claimed) {
+			ctx.JSON(
+				http.StatusBadRequest,
+				ErrorResponse(errors.New("cid must be a CIDv1 with raw codec and sha2-256 hash"), "/file/upload:00000003"),
+			)
+			return
+		}
+
+		mimeType := f.MimeType
+		if mimeType == "" {
+			mimeType = ctx.ContentType()
+		}
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+
+		isInPool := true
+		file := entity.File{
+			Name:             f.Name,
+			Root:             f.Root,
+			Path:             f.Path,
+			CID:              f.CID,
+			Mime:             mimeType,
+			IsInPool:         &isInPool,
+			EncryptionStatus: f.EncryptionStatus,
+		}
+		if f.CIDOriginalEncrypted != "" {
+			file.CIDOriginalEncrypted = &f.CIDOriginalEncrypted
+		}
+
+		// the content is already stored, the body does not need to be read
+		if attached, err := attachStoredFile(&file, requestUploader(ctx)); respondQuotaExceeded(ctx, err, "/file/upload:00000004") {
+			return
+		} else if err != nil {
+			log.Errorf("failed to attach %s: %v", f.CID, err)
+			AbortSaveFailed(ctx)
+			return
+		} else if attached {
+			ctx.JSON(http.StatusOK, fileResponse(&file))
+			return
+		}
+
+		// reject uploads that cannot fit before reading them
+		if ctx.Request.ContentLength > 0 && !checkQuota(ctx, authPayload.UserID, ctx.Request.ContentLength, "/file/upload:00000004") {
+			return
+		}
+
+		hasher := contentid.NewHasher()
+		body := &countingReader{r: io.TeeReader(ctx.Request.Body, hasher)}
+
+		// the content is staged until it is verified, so that it never
+		// replaces the content stored under its CID unchecked
+		key := stagingKey()
+		defer func() {
+			if err := backend.Delete(context.WithoutCancel(ctx.Request.Context()), key); err != nil {
+				log.Errorf("failed to delete staged upload %s: %v", key, err)
+			}
+		}()
+
+		start := time.Now()
+		if err := backend.Put(ctx.Request.Context(), key, body, mimeType); err != nil {
+			log.Errorf("failed to upload %s: %v", f.CID, err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/upload:00000005"))
+			return
+		}
+		log.Debugf("file: uploaded %s (%d bytes) [%s]", f.CID, body.n, time.Since(start))
+
+		if ctx.Request.ContentLength >= 0 && body.n != ctx.Request.ContentLength {
+			ctx.JSON(
+				http.StatusBadRequest,
+				ErrorResponse(fmt.Errorf("expected %d bytes, received %d", ctx.Request.ContentLength, body.n), "/file/upload:00000006"),
+			)
+			return
+		}
+
+		computed, err := hasher.CID()
+		if err != nil {
+			log.Errorf("failed to compute cid of %s: %v", f.CID, err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		if !computed.Equals(claimed) {
+			ctx.JSON(
+				http.StatusUnprocessableEntity,
+				ErrorResponse(fmt.Errorf("content does not match cid %s, computed %s", f.CID, computed), "/file/upload:00000007"),
+			)
+			return
+		}
+
+		file.Size = body.n
+
+		if err := promoteStagedFile(ctx.Request.Context(), backend, key, &file, requestUploader(ctx)); respondQuotaExceeded(ctx, err, "/file/upload:00000004") {
+			return
+		} else if err != nil {
+			log.Errorf("failed to create file: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, fileResponse(&file))
+	})
+}
@@ -14,7 +14,7 @@ import (
 //
 // GET /api/load/miner
 func LoadMiner(router *gin.RouterGroup) {
-	router.GET("/load/miner", func(ctx *gin.Context) {
+	router.GET("/load/miner", RequireScope(token.ScopeAccountRead), func(ctx *gin.Context) {
 		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
 
 		u := query.FindUserWithWallet(authPayload.UserID)
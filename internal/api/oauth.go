@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
@@ -14,13 +13,156 @@ import (
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/oauth"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-// OAuthGoogle
+// oauthProvisionQuery returns the custodial wallet and referrer code in the
+// query of the OAuth routes.
+func oauthProvisionQuery(ctx *gin.Context) form.OAuthProvisionRequest {
+	return form.OAuthProvisionRequest{
+		WalletAddress: ctx.Query("wallet_address"),
+		PrivateKey:    ctx.Query("private_key"),
+		ReferrerCode:  ctx.Query("referrer_code"),
+	}
+}
+
+// provisionOAuthUser creates a user who signed in with an OAuth provider for
+// the first time as part of the transaction tx, with the custodial wallet and
+// referrer code of req. On failure, it rolls back tx and responds with an
+// error coded below route.
+func provisionOAuthUser(tx *gorm.DB, ctx *gin.Context, u *entity.User, req form.OAuthProvisionRequest, accountType entity.AccountType, route string) bool {
+	isValidEthereumAddress := crypto.IsValidEthereumAddress(req.WalletAddress)
+	isValidEthereumPrivateKey := crypto.IsValidEthereumPrivateKey(req.PrivateKey)
+	if !isValidEthereumAddress || !isValidEthereumPrivateKey {
+		log.Errorf("invalid ethereum address or private key")
+		tx.Rollback()
+		ctx.JSON(
+			http.StatusBadRequest,
+			ErrorResponse(errors.New("invalid wallet address or private key"), route+":00000003"),
+		)
+		return false
+	}
+
+	encryptedPrivateKey, err := crypto.Encrypt(req.PrivateKey)
+	if err != nil {
+		log.Errorf("failed to encrypt private key: %v", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, route+":00000004"))
+		return false
+	}
+
+	u.Wallet = &entity.Wallet{
+		Address:     req.WalletAddress,
+		PrivateKey:  encryptedPrivateKey,
+		AccountType: string(accountType),
+	}
+
+	if err := u.TxCreate(tx); err != nil {
+		log.Errorf("failed to create user: %v", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, route+":00000005"))
+		return false
+	}
+
+	// check if referral code is valid
+	if req.ReferrerCode == "ns" {
+		referral := entity.ReferredUser{
+			ReferredID: u.ID,
+			Referrer:   req.ReferrerCode,
+		}
+		if err := referral.TxCreate(tx); err != nil {
+			log.Errorf("failed to save referral: %v", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, route+":00000006"))
+			return false
+		}
+	}
+
+	referrer_id, err := query.CheckReferralCode(req.ReferrerCode)
+
+	if err != nil {
+		log.Errorf("failed to check referral code: %v", err)
+	}
+
+	// initialize user detail
+	user_detail := entity.UserDetail{
+		StorageUsed: 0,
+		UserID:      u.ID,
+		ReferredBy:  referrer_id,
+	}
+
+	if err := user_detail.TxCreate(tx); err != nil {
+		log.Errorf("failed to create user detail: %v", err)
+		tx.Rollback()
+		ctx.JSON(
+			http.StatusInternalServerError,
+			ErrorResponse(err, route+":00000007"),
+		)
+		return false
+	}
+
+	if err == nil && referrer_id != 0 && user_detail.ID != 0 && u.ID != 0 {
+		err := query.CreateReferral(referrer_id, u.ID, user_detail.ID)
+
+		if err != nil {
+			log.Errorf("failed to create referral: %v", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, route+":00000008"))
+			return false
+		}
+	}
+
+	return true
+}
+
+// finishOAuthLogin signs in a user who authenticated with an OAuth provider
+// as part of the transaction tx, commits it and responds with the session, or
+// the two-factor challenge of the user. On failure, it rolls back tx and
+// responds with an error coded below route.
+func finishOAuthLogin(tx *gorm.DB, ctx *gin.Context, tokenMaker token.Maker, u *entity.User, method entity.AccountType, route string) (*form.LoginUserResponse, bool) {
+	rsp, err := createLoginSession(tx, ctx, tokenMaker, u, string(method))
+	if err != nil {
+		tx.Rollback()
+		log.Errorf("failed to create session: %v", err)
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, route+":00000010"))
+		return nil, false
+	}
+
+	userLogin := &entity.UserLogin{
+		LoginDate:  time.Now(),
+		WalletAddr: u.Wallet.Address,
+	}
+
+	if err := userLogin.TxCreate(tx); err != nil {
+		log.Errorf("failed to create user login: %v", err)
+		tx.Rollback()
+		ctx.JSON(
+			http.StatusInternalServerError,
+			ErrorResponse(err, route+":00000012"),
+		)
+		return nil, false
+	}
+
+	tx.Commit()
+	ctx.JSON(http.StatusOK, rsp)
+	return rsp, true
+}
+
+// OAuthGoogle signs in with the code of Google's OAuth web flow, identifying
+// users by their Google email. New users are created with the wallet in the
+// query.
+//
+// Deprecated: configure Google as an OpenID Connect provider in
+// OIDC_PROVIDERS and sign in with OIDC, which verifies the ID token, binds
+// the login to the client and links users by their subject. Set
+// OIDC_GOOGLE_TRUST_EMAIL=true, so that users who signed in here before are
+// found by their email. The route is kept until clients have moved, and
+// responds with a Deprecation header.
 //
 // GET /api/oauth/google
 func OAuthGoogle(router *gin.RouterGroup, tokenMaker token.Maker) {
 	router.GET("/oauth/google", func(ctx *gin.Context) {
+		ctx.Header("Deprecation", "true")
 
 		code := ctx.Query("code")
 
@@ -49,228 +191,63 @@ func OAuthGoogle(router *gin.RouterGroup, tokenMaker token.Maker) {
 		tx := db.Db().Begin()
 
 		if u == nil {
-
-			var req struct {
-				WalletAddress string `json:"wallet_address" binding:"required"`
-				PrivateKey    string `json:"private_key" binding:"required"`
-				ReferralCode  string `json:"referrer_code" binding:"required"`
-			}
-
-			req.WalletAddress = ctx.Query("wallet_address")
-			req.PrivateKey = ctx.Query("private_key")
-			req.ReferralCode = ctx.Query("referrer_code")
-
-			isValidEthereumAddress := crypto.IsValidEthereumAddress(req.WalletAddress)
-			isValidEthereumPrivateKey := crypto.IsValidEthereumPrivateKey(req.PrivateKey)
-			if !isValidEthereumAddress || !isValidEthereumPrivateKey {
-				log.Errorf("invalid ethereum address or private key")
-				ctx.JSON(
-					http.StatusBadRequest,
-					ErrorResponse(errors.New("invalid wallet address or private key"), "/oauth/google:00000003"),
-				)
-				return
-			}
-
-			encryptedPrivateKey, err := crypto.Encrypt(req.PrivateKey)
-			if err != nil {
-				log.Errorf("failed to encrypt private key: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/oauth/google:00000004"))
-				return
-			}
-
 			// create new user
 			new := entity.User{
 				Name: google_user.Name,
 				Email: &entity.Email{
 					Email: google_user.Email,
 				},
-				Wallet: &entity.Wallet{
-					Address:     req.WalletAddress,
-					PrivateKey:  encryptedPrivateKey,
-					AccountType: string(entity.Google),
-				},
-			}
-
-			//decrypt and print private key
-			//decryptedPrivateKey, err := crypto.Decrypt(encryptedPrivateKey)
-			if err := new.Create(); err != nil {
-				log.Errorf("failed to create user: %v", err)
-				ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/oauth/google:00000005"))
-				return
-			}
-
-			// check if referral code is valid
-			if req.ReferralCode == "ns" {
-				referral := entity.ReferredUser{
-					ReferredID: new.ID,
-					Referrer:   req.ReferralCode,
-				}
-				if err := referral.TxCreate(tx); err != nil {
-					log.Errorf("failed to save referral: %v", err)
-					tx.Rollback()
-					ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/oauth/google:00000006"))
-					return
-				}
-			}
-
-			referrer_id, err := query.CheckReferralCode(req.ReferralCode)
-
-			if err != nil {
-				log.Errorf("failed to check referral code: %v", err)
-			}
-
-			// initialize user detail
-			user_detail := entity.UserDetail{
-				StorageUsed: 0,
-				UserID:      new.ID,
-				ReferredBy:  referrer_id,
 			}
 
-			if err := user_detail.TxCreate(tx); err != nil {
-				log.Errorf("failed to create user detail: %v", err)
-				tx.Rollback()
-				ctx.JSON(
-					http.StatusInternalServerError,
-					ErrorResponse(err, "/oauth/google:00000007"),
-				)
+			if !provisionOAuthUser(tx, ctx, &new, oauthProvisionQuery(ctx), entity.Google, "/oauth/google") {
 				return
 			}
 
-			if err == nil && referrer_id != 0 && user_detail.ID != 0 && new.ID != 0 {
-				err := query.CreateReferral(referrer_id, new.ID, user_detail.ID)
-
-				if err != nil {
-					log.Errorf("failed to create referral: %v", err)
-					ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/otp/start:00000008"))
-					return
-				}
-			}
-
 			u = &new
 		}
 
-		// authorization token
-		accessToken, accessPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().AccessTokenDuration,
-		)
-		if err != nil {
-			tx.Rollback()
-			log.Errorf("failed to create access token: %v", err)
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/oauth/google:00000010"))
-			return
-		}
-
-		refreshToken, refreshPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().RefreshTokenDuration,
-		)
-		if err != nil {
-			log.Errorf("failed to create refresh token: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/oauth/google:00000011"))
-			return
-		}
-
-		// TO-DO create session part
-
-		rsp := form.LoginUserResponse{
-			// SessionID:             session.ID,
-			AccessToken:           accessToken,
-			AccessTokenExpiresAt:  accessPayload.ExpiredAt,
-			RefreshToken:          refreshToken,
-			RefreshTokenExpiresAt: refreshPayload.ExpiredAt,
-		}
-
-		userLogin := &entity.UserLogin{
-			LoginDate:  time.Now(),
-			WalletAddr: u.Wallet.Address,
-		}
-
-		if err := userLogin.TxCreate(tx); err != nil {
-			log.Errorf("failed to create user login: %v", err)
-			tx.Rollback()
-			ctx.JSON(
-				http.StatusInternalServerError,
-				ErrorResponse(err, "/oauth/google:00000012"),
-			)
-			return
-		}
-
-		tx.Commit()
-		ctx.JSON(http.StatusOK, rsp)
+		finishOAuthLogin(tx, ctx, tokenMaker, u, entity.Google, "/oauth/google")
 	})
 }
 
-// OAuthGithub
+// OAuthGithub signs in with GitHub: the code of GitHub's OAuth web flow is
+// exchanged for the GitHub user. New users are created with the wallet in
+// the query.
 //
 // GET /api/oauth/github
-func OAuthGithub(router *gin.RouterGroup, tokenMaker token.Maker) {
+func OAuthGithub(router *gin.RouterGroup, tokenMaker token.Maker, github *oauth.GithubClient) {
 	router.GET("/oauth/github", func(ctx *gin.Context) {
-		log.Printf("github oauth call")
 		code := ctx.Query("code")
 
 		if code == "" {
 			log.Errorf("Authorization code not provided!")
 			ctx.JSON(
 				http.StatusUnauthorized,
-				gin.H{"status": "fail", "message": "Authorization code not provided!"},
+				ErrorResponse(errors.New("code not provided"), "/oauth/github:00000001"),
 			)
 			return
 		}
 
-		token, err := oauth.GetGithubOAuthToken(code)
-
+		accessToken, err := github.GetGithubOAuthToken(code)
 		if err != nil {
-			ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+			log.Errorf("failed to get github token: %v", err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/oauth/github:00000002"))
 			return
 		}
 
-		github_user, err := oauth.GetGithubUser(token)
+		github_user, err := github.GetGithubUser(accessToken)
 		if err != nil {
-			ctx.JSON(http.StatusBadGateway, gin.H{"status": "fail", "message": err.Error()})
+			log.Errorf("failed to get github user: %v", err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/oauth/github:00000002"))
 			return
 		}
 
 		u := query.FindUserByGithub(github_user.ID)
+
 		// Start a new transaction
 		tx := db.Db().Begin()
-		if u == nil {
-			var req struct {
-				WalletAddress string `json:"wallet_address" binding:"required"`
-				PrivateKey    string `json:"private_key" binding:"required"`
-				ReferralCode  string `json:"referral_code" binding:"required"`
-			}
-
-			req.WalletAddress = ctx.Query("wallet_address")
-			req.PrivateKey = ctx.Query("private_key")
-			req.ReferralCode = ctx.Query("referrer_code")
-
-			isValidEthereumAddress := crypto.IsValidEthereumAddress(req.WalletAddress)
-			isValidEthereumPrivateKey := crypto.IsValidEthereumPrivateKey(req.PrivateKey)
-			if !isValidEthereumAddress || !isValidEthereumPrivateKey {
-				log.Errorf("invalid ethereum address or private key")
-				tx.Rollback()
-				ctx.JSON(
-					http.StatusBadRequest,
-					gin.H{"status": "fail", "message": "invalid ethereum address or private key"},
-				)
-				return
-			}
-
-			encryptedPrivateKey, err := crypto.Encrypt(req.PrivateKey)
-			if err != nil {
-				log.Errorf("failed to encrypt private key: %v", err)
-				tx.Rollback()
-				ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
-				return
-			}
 
+		if u == nil {
 			// create new user
 			new := entity.User{
 				Name: github_user.Name,
@@ -279,107 +256,15 @@ func OAuthGithub(router *gin.RouterGroup, tokenMaker token.Maker) {
 					Name:     github_user.Name,
 					Avatar:   github_user.Avatar,
 				},
-				Detail: &entity.UserDetail{
-					StorageUsed: 0,
-				},
-				Wallet: &entity.Wallet{
-					Address:     req.WalletAddress,
-					PrivateKey:  encryptedPrivateKey,
-					AccountType: string(entity.GitHub),
-				},
 			}
 
-			if err := new.TxCreate(tx); err != nil {
-				log.Errorf("failed to create user: %v", err)
-				tx.Rollback()
-				ctx.JSON(
-					http.StatusInternalServerError,
-					gin.H{"status": "fail", "message": err.Error()},
-				)
+			if !provisionOAuthUser(tx, ctx, &new, oauthProvisionQuery(ctx), entity.GitHub, "/oauth/github") {
 				return
 			}
 
-			// check if referral code is valid
-			if req.ReferralCode == "ns" {
-				referral := entity.ReferredUser{
-					ReferredID: u.ID,
-					Referrer:   req.ReferralCode,
-				}
-				if err := referral.TxCreate(tx); err != nil {
-					log.Errorf("failed to save referral: %v", err)
-					tx.Rollback()
-					ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
-					return
-				}
-			}
-			referrer_id, err := query.CheckReferralCode(req.ReferralCode)
-
-			// initialize user detail
-			user_detail := entity.UserDetail{
-				StorageUsed: 0,
-				UserID:      new.ID,
-				ReferredBy:  referrer_id,
-			}
-
-			if err := user_detail.TxCreate(tx); err != nil {
-				log.Errorf("failed to create user detail: %v", err)
-				tx.Rollback()
-				ctx.JSON(
-					http.StatusInternalServerError,
-					gin.H{"status": "fail", "message": err.Error()},
-				)
-				return
-			}
-
-			if err == nil && referrer_id != 0 && user_detail.ID != 0 && new.ID != 0 {
-				err := query.CreateReferral(referrer_id, new.ID, user_detail.ID)
-
-				if err != nil {
-					log.Errorf("failed to create referral: %v", err)
-					ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/otp/start:00000008"))
-					return
-				}
-			}
-
 			u = &new
 		}
 
-		// authorization token
-		accessToken, accessPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().AccessTokenDuration,
-		)
-		if err != nil {
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
-			return
-		}
-
-		refreshToken, refreshPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().RefreshTokenDuration,
-		)
-		if err != nil {
-			log.Errorf("failed to create refresh token: %v", err)
-			tx.Rollback()
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
-			return
-		}
-
-		// TO-DO create session part
-
-		rsp := form.LoginUserResponse{
-			// SessionID:             session.ID,
-			AccessToken:           accessToken,
-			AccessTokenExpiresAt:  accessPayload.ExpiredAt,
-			RefreshToken:          refreshToken,
-			RefreshTokenExpiresAt: refreshPayload.ExpiredAt,
-		}
-		tx.Commit()
-		ctx.JSON(http.StatusOK, rsp)
+		finishOAuthLogin(tx, ctx, tokenMaker, u, entity.GitHub, "/oauth/github")
 	})
 }
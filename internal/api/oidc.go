@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/oauth"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// oidcAuthRequestTTL is how long a user may take to sign in at a provider.
+const oidcAuthRequestTTL = 10 * time.Minute
+
+// oidcMaxNameLength is the length of user names.
+const oidcMaxNameLength = 50
+
+var errOIDCState = errors.New("login not found or expired")
+
+// oidcProvider is an OpenID Connect provider, implemented by
+// oauth.OIDCProvider.
+type oidcProvider interface {
+	Name() string
+	TrustEmail() bool
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	Exchange(ctx context.Context, code, nonce, verifier string) (*oauth.OIDCClaims, error)
+}
+
+// OIDC signs in with the OpenID Connect providers in OIDC_PROVIDERS, using
+// the authorization code flow with PKCE. The URL returned by authorize is
+// opened by the user, who returns to the redirect URL of the provider with a
+// code and the state, which are posted to callback with the binding returned
+// by authorize.
+//
+// Users are identified by the subject of the provider. Users signing in for
+// the first time are linked to the user with their email if the provider is
+// trusted with it and verified it, and are created with the wallet in the
+// body otherwise.
+//
+// GET /api/oidc
+// GET /api/oidc/:provider/authorize
+// POST /api/oidc/:provider/callback
+func OIDC(router *gin.RouterGroup, tokenMaker token.Maker, providers map[string]*oauth.OIDCProvider) {
+	p := make(map[string]oidcProvider, len(providers))
+	for name, provider := range providers {
+		p[name] = provider
+	}
+
+	registerOIDC(router, tokenMaker, p)
+}
+
+func registerOIDC(router *gin.RouterGroup, tokenMaker token.Maker, providers map[string]oidcProvider) {
+	router.GET("/oidc", func(ctx *gin.Context) {
+		names := make([]string, 0, len(providers))
+		for name := range providers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		ctx.JSON(http.StatusOK, names)
+	})
+
+	router.GET("/oidc/:provider/authorize", func(ctx *gin.Context) {
+		provider, ok := providers[ctx.Param("provider")]
+		if !ok {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		if err := query.DeleteExpiredOIDCAuthRequests(); err != nil {
+			log.Errorf("failed to delete expired oidc auth requests: %v", err)
+		}
+
+		binding := rnd.GenerateRandomString(32)
+		req := &entity.OIDCAuthRequest{
+			State:        rnd.GenerateRandomString(32),
+			Provider:     provider.Name(),
+			Nonce:        rnd.GenerateRandomString(32),
+			CodeVerifier: oauth.GenerateVerifier(),
+			BindingHash:  entity.HashOIDCBinding(binding),
+			ExpiresAt:    time.Now().Add(oidcAuthRequestTTL),
+		}
+
+		url, err := provider.AuthCodeURL(ctx, req.State, req.Nonce, req.CodeVerifier)
+		if err != nil {
+			log.Errorf("failed to discover oidc provider: %v", err)
+			ctx.JSON(http.StatusBadGateway, ErrorResponse(err, "/oidc/authorize:00000001"))
+			return
+		}
+
+		if err := req.Create(); err != nil {
+			log.Errorf("failed to create oidc auth request: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, form.OIDCAuthorizeResponse{
+			URL:       url,
+			State:     req.State,
+			Binding:   binding,
+			ExpiresAt: req.ExpiresAt,
+		})
+	})
+
+	router.POST("/oidc/:provider/callback", func(ctx *gin.Context) {
+		provider, ok := providers[ctx.Param("provider")]
+		if !ok {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		var f form.OIDCCallbackRequest
+		if err := ctx.ShouldBindJSON(&f); err != nil {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(err, "/oidc/callback:00000001"))
+			return
+		}
+
+		// the state is used up before the code, which can only be
+		// exchanged once anyway
+		req, err := query.TxTakeOIDCAuthRequest(db.Db(), provider.Name(), f.State, f.Binding)
+		if err != nil {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errOIDCState, "/oidc/callback:00000011"))
+			return
+		}
+
+		claims, err := provider.Exchange(ctx, f.Code, req.Nonce, req.CodeVerifier)
+		if err != nil {
+			log.Errorf("failed to sign in with oidc provider %s: %v", provider.Name(), err)
+			publishAuthEvent(ctx, "oidc.failed", event.Data{"provider": provider.Name()})
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(err, "/oidc/callback:00000002"))
+			return
+		}
+
+		identity, err := query.FindOIDCIdentity(provider.Name(), claims.Subject)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("failed to find oidc identity: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		// emails are only known to belong to the user once verified
+		email := ""
+		if claims.EmailVerified {
+			email = claims.Email
+		}
+
+		var u *entity.User
+		if identity != nil {
+			if u = query.FindUserWithWallet(identity.UserID); u == nil {
+				Abort(ctx, http.StatusNotFound, "user not exists!")
+				return
+			}
+		} else if email != "" {
+			u = query.FindUserByEmail(email)
+
+			// the email is someone else's unless the provider is trusted
+			// with it, so the new user is created without it
+			if u != nil && !provider.TrustEmail() {
+				u = nil
+				email = ""
+			}
+		}
+
+		// Start a new transaction
+		tx := db.Db().Begin()
+
+		if u == nil {
+			// create new user
+			new := entity.User{
+				Name: oidcUserName(claims),
+			}
+
+			if email != "" {
+				new.Email = &entity.Email{
+					Email: email,
+				}
+			}
+
+			if !provisionOAuthUser(tx, ctx, &new, f.OAuthProvisionRequest, entity.OIDC, "/oidc/callback") {
+				return
+			}
+
+			u = &new
+		}
+
+		if identity == nil {
+			identity = &entity.OIDCIdentity{
+				UserID:   u.ID,
+				Provider: provider.Name(),
+				Subject:  claims.Subject,
+			}
+
+			if err := identity.TxCreate(tx); err != nil {
+				log.Errorf("failed to create oidc identity: %v", err)
+				tx.Rollback()
+				ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/oidc/callback:00000013"))
+				return
+			}
+		}
+
+		if err := identity.TxLogin(tx, claims.Email); err != nil {
+			log.Errorf("failed to update oidc identity: %v", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/oidc/callback:00000014"))
+			return
+		}
+
+		rsp, ok := finishOAuthLogin(tx, ctx, tokenMaker, u, entity.OIDC, "/oidc/callback")
+		if !ok {
+			return
+		}
+
+		// the login is only verified once the second factor is
+		if rsp.TwoFactor != nil {
+			publishAuthEvent(ctx, "oidc.challenged", event.Data{"provider": provider.Name(), "user_id": u.ID})
+			return
+		}
+
+		publishAuthEvent(ctx, "oidc.verified", event.Data{"provider": provider.Name(), "user_id": u.ID})
+	})
+}
+
+// oidcUserName returns the name a user signing in with an OpenID Connect
+// provider is created with.
+func oidcUserName(claims *oauth.OIDCClaims) string {
+	name := []rune(claims.DisplayName())
+	if len(name) == 0 {
+		name = []rune(claims.Subject)
+	}
+
+	if len(name) > oidcMaxNameLength {
+		name = name[:oidcMaxNameLength]
+	}
+
+	return string(name)
+}
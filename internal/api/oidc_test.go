@@ -0,0 +1,254 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/oauth"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOIDCProvider signs in with the claims of the codes it knows.
+type fakeOIDCProvider struct {
+	name   string
+	trust  bool
+	claims map[string]*oauth.OIDCClaims
+}
+
+func (p *fakeOIDCProvider) Name() string { return p.name }
+
+func (p *fakeOIDCProvider) TrustEmail() bool { return p.trust }
+
+func (p *fakeOIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	return "https://idp.example.com/authorize?state=" + state, nil
+}
+
+func (p *fakeOIDCProvider) Exchange(ctx context.Context, code, nonce, verifier string) (*oauth.OIDCClaims, error) {
+	claims, ok := p.claims[code]
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+
+	return claims, nil
+}
+
+func newOIDCRouter(t *testing.T, claims map[string]*oauth.OIDCClaims) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	testdb.Open(t)
+
+	env := config.Env()
+	t.Cleanup(func() { config.SetEnv(env) })
+	config.SetEnv(config.EnvVar{
+		AccessTokenDuration:   time.Minute,
+		RefreshTokenDuration:  time.Hour,
+		TwoFactorChallengeTTL: time.Minute,
+	})
+	t.Setenv("ENCRYPTION_KEY", "12345678901234567890123456789012")
+
+	keyring, err := token.NewKeyring("1", "12345678901234567890123456789012", nil)
+	require.NoError(t, err)
+	tokenMaker, err := token.NewPasetoMaker(keyring)
+	require.NoError(t, err)
+
+	router := gin.New()
+	registerOIDC(router.Group("/api"), tokenMaker, map[string]oidcProvider{
+		"trusted":   &fakeOIDCProvider{name: "trusted", trust: true, claims: claims},
+		"untrusted": &fakeOIDCProvider{name: "untrusted", claims: claims},
+	})
+
+	return router
+}
+
+// createOIDCUser creates a user with an email and a wallet.
+func createOIDCUser(t *testing.T, name, email string) *entity.User {
+	t.Helper()
+
+	u := &entity.User{
+		Name:   name,
+		Email:  &entity.Email{Email: email},
+		Wallet: &entity.Wallet{Address: newOIDCWallet(t).WalletAddress, AccountType: string(entity.OIDC)},
+	}
+	require.NoError(t, db.Db().Create(u).Error)
+
+	return u
+}
+
+// newOIDCWallet returns a custodial wallet new users are created with.
+func newOIDCWallet(t *testing.T) form.OAuthProvisionRequest {
+	t.Helper()
+
+	key, err := ethcrypto.GenerateKey()
+	require.NoError(t, err)
+
+	return form.OAuthProvisionRequest{
+		WalletAddress: ethcrypto.PubkeyToAddress(key.PublicKey).Hex(),
+		PrivateKey:    hex.EncodeToString(ethcrypto.FromECDSA(key)),
+	}
+}
+
+// authorizeOIDC starts a login at a provider.
+func authorizeOIDC(t *testing.T, router *gin.Engine, provider string) form.OIDCAuthorizeResponse {
+	t.Helper()
+
+	var rsp form.OIDCAuthorizeResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodGet, "/api/oidc/"+provider+"/authorize", "", &rsp))
+	require.NotEmpty(t, rsp.State)
+	require.NotEmpty(t, rsp.Binding)
+
+	return rsp
+}
+
+// callbackOIDC finishes a login at a provider, creating new users with a new
+// wallet.
+func callbackOIDC(t *testing.T, router *gin.Engine, provider, code, state, binding string, rsp interface{}) int {
+	t.Helper()
+
+	body, err := json.Marshal(form.OIDCCallbackRequest{
+		Code:                  code,
+		State:                 state,
+		Binding:               binding,
+		OAuthProvisionRequest: newOIDCWallet(t),
+	})
+	require.NoError(t, err)
+
+	return serveJSON(t, router, http.MethodPost, "/api/oidc/"+provider+"/callback", string(body), rsp)
+}
+
+// loginOIDC signs in at a provider as the subject and returns the user
+// signed in to.
+func loginOIDC(t *testing.T, router *gin.Engine, provider, code, subject string) (*entity.User, *form.LoginUserResponse) {
+	t.Helper()
+
+	auth := authorizeOIDC(t, router, provider)
+
+	var rsp form.LoginUserResponse
+	require.Equal(t, http.StatusOK, callbackOIDC(t, router, provider, code, auth.State, auth.Binding, &rsp))
+
+	identity, err := query.FindOIDCIdentity(provider, subject)
+	require.NoError(t, err)
+
+	u := &entity.User{}
+	require.NoError(t, db.Db().Preload("Wallet").Preload("Email").First(u, identity.UserID).Error)
+
+	return u, &rsp
+}
+
+func TestOIDCState(t *testing.T) {
+	router := newOIDCRouter(t, map[string]*oauth.OIDCClaims{
+		"code": {Subject: "alice"},
+	})
+
+	auth := authorizeOIDC(t, router, "trusted")
+
+	// the login is kept if another client returns with its state
+	require.Equal(t, http.StatusUnauthorized, callbackOIDC(t, router, "trusted", "code", auth.State, "other", nil))
+	require.Equal(t, http.StatusUnauthorized, callbackOIDC(t, router, "untrusted", "code", auth.State, auth.Binding, nil))
+
+	// logins are single-use
+	require.Equal(t, http.StatusOK, callbackOIDC(t, router, "trusted", "code", auth.State, auth.Binding, nil))
+	require.Equal(t, http.StatusUnauthorized, callbackOIDC(t, router, "trusted", "code", auth.State, auth.Binding, nil))
+
+	// and expire
+	auth = authorizeOIDC(t, router, "trusted")
+	require.NoError(t, db.Db().Model(&entity.OIDCAuthRequest{}).Where("true").Update("expires_at", time.Now().Add(-time.Second)).Error)
+	require.Equal(t, http.StatusUnauthorized, callbackOIDC(t, router, "trusted", "code", auth.State, auth.Binding, nil))
+}
+
+func TestOIDCLinking(t *testing.T) {
+	router := newOIDCRouter(t, map[string]*oauth.OIDCClaims{
+		"verified":   {Subject: "alice", Email: "alice@example.com", EmailVerified: true},
+		"returning":  {Subject: "alice", Email: "alice@example.org", EmailVerified: true},
+		"unverified": {Subject: "mallory", Email: "alice@example.com"},
+		"new":        {Subject: "carol", Email: "carol@example.com", EmailVerified: true, Name: "Carol"},
+	})
+
+	alice := createOIDCUser(t, "alice", "alice@example.com")
+
+	t.Run("trusted verified email", func(t *testing.T) {
+		u, _ := loginOIDC(t, router, "trusted", "verified", "alice")
+		require.Equal(t, alice.ID, u.ID)
+	})
+
+	t.Run("returning subject", func(t *testing.T) {
+		// users are found by the subject, whatever their email is now
+		u, _ := loginOIDC(t, router, "trusted", "returning", "alice")
+		require.Equal(t, alice.ID, u.ID)
+
+		var identities []entity.OIDCIdentity
+		require.NoError(t, db.Db().Where("user_id = ?", alice.ID).Find(&identities).Error)
+		require.Len(t, identities, 1)
+		require.Equal(t, "alice@example.org", identities[0].Email)
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		u, _ := loginOIDC(t, router, "trusted", "unverified", "mallory")
+		require.NotEqual(t, alice.ID, u.ID)
+		require.Nil(t, u.Email)
+	})
+
+	t.Run("untrusted email", func(t *testing.T) {
+		// the email is someone else's, so the new user is created without it
+		u, _ := loginOIDC(t, router, "untrusted", "verified", "alice")
+		require.NotEqual(t, alice.ID, u.ID)
+		require.Nil(t, u.Email)
+	})
+
+	t.Run("new user", func(t *testing.T) {
+		u, rsp := loginOIDC(t, router, "untrusted", "new", "carol")
+		require.Equal(t, "Carol", u.Name)
+		require.Equal(t, "carol@example.com", u.Email.Email)
+		require.Equal(t, string(entity.OIDC), u.Wallet.AccountType)
+		require.NotEmpty(t, rsp.AccessToken)
+
+		// a trusted provider links the subject to the user with the email
+		other, _ := loginOIDC(t, router, "trusted", "new", "carol")
+		require.Equal(t, u.ID, other.ID)
+	})
+}
+
+func TestOIDCTwoFactor(t *testing.T) {
+	router := newOIDCRouter(t, map[string]*oauth.OIDCClaims{
+		"verified": {Subject: "bob", Email: "bob@example.com", EmailVerified: true},
+	})
+
+	bob := createOIDCUser(t, "bob", "bob@example.com")
+	now := time.Now()
+	require.NoError(t, db.Db().Create(&entity.TwoFactor{UserID: bob.ID, Secret: []byte("secret"), EnabledAt: &now}).Error)
+
+	s := event.Subscribe("auth.oidc.*")
+	t.Cleanup(func() { event.Unsubscribe(s) })
+
+	u, rsp := loginOIDC(t, router, "trusted", "verified", "bob")
+	require.Equal(t, bob.ID, u.ID)
+	require.NotNil(t, rsp.TwoFactor)
+	require.Empty(t, rsp.AccessToken)
+
+	// the login is not verified until the second factor is
+	select {
+	case msg := <-s.Receiver:
+		require.Equal(t, "auth.oidc.challenged", msg.Name)
+	case <-time.After(time.Second):
+		t.Fatal("no oidc event published")
+	}
+
+	select {
+	case msg := <-s.Receiver:
+		t.Fatalf("unexpected event %s", msg.Name)
+	default:
+	}
+}
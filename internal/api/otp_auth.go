@@ -2,20 +2,31 @@ package api
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
-	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/crypto"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/mg"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/gin-gonic/gin"
-	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// otpCodeLength is the number of digits of emailed codes.
+const otpCodeLength = 6
+
+var (
+	errOtpInvalid = errors.New("invalid code")
+	errOtpLocked  = errors.New("too many failed attempts, try again later")
 )
 
 // OTP Auth (one-time-passcode auth)
@@ -36,13 +47,19 @@ func StartOTP(router *gin.RouterGroup) {
 			return
 		}
 
-		key, err := totp.Generate(totp.GenerateOpts{
-			Issuer:      "hello.app",
-			AccountName: f.Email,
-			Period:      30 * 60,
-		})
+		until, err := otpLockedUntil(f.Email, ctx.ClientIP())
+		if err != nil {
+			log.Errorf("failed to find otp lockouts: %v", err)
+			AbortUnexpected(ctx)
+			return
+		} else if until != nil {
+			abortOtpLocked(ctx, *until, "/otp/start:00000013")
+			return
+		}
+
+		code, err := rnd.GenerateDigits(otpCodeLength)
 		if err != nil {
-			log.Errorf("failed to generate key: %v", err)
+			log.Errorf("failed to generate code: %v", err)
 			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/otp/start:00000002"))
 			return
 		}
@@ -74,8 +91,7 @@ func StartOTP(router *gin.RouterGroup) {
 			u := entity.User{
 				Name: strings.Split(f.Email, "@")[0],
 				Email: &entity.Email{
-					Email:  f.Email,
-					Secret: key.Secret(),
+					Email: f.Email,
 				},
 				Wallet: &entity.Wallet{
 					Address:     f.WalletAddress,
@@ -131,9 +147,9 @@ func StartOTP(router *gin.RouterGroup) {
 			}
 			uSearch = &u
 
-		} else {
-			email := uSearch.Email
-			email.Secret = key.Secret()
+		} else if email := uSearch.Email; email.Secret != "" {
+			// codes are no longer derived from a secret of the user
+			email.Secret = ""
 
 			if err := email.Save(); err != nil {
 				log.Errorf("failed to save email: %v", err)
@@ -158,9 +174,18 @@ func StartOTP(router *gin.RouterGroup) {
 			return
 		}
 
-		code, err := totp.GenerateCode(key.Secret(), time.Now())
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/otp/start:00000011"))
+		if err := query.TxConsumeOtpChallenges(tx, f.Email); err != nil {
+			log.Errorf("failed to consume otp challenges: %v", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/otp/start:00000011"))
+			return
+		}
+
+		challenge := entity.NewOtpChallenge(f.Email, code, ctx.ClientIP(), config.Env().OtpMaxAttempts, config.Env().OtpTTL)
+		if err := challenge.TxCreate(tx); err != nil {
+			log.Errorf("failed to create otp challenge: %v", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/otp/start:00000011"))
 			return
 		}
 
@@ -184,6 +209,7 @@ func StartOTP(router *gin.RouterGroup) {
 
 		if err != nil {
 			log.Errorf("failed to send email: %v", err)
+			tx.Rollback()
 			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/otp/start:00000012"))
 			return
 		}
@@ -196,6 +222,10 @@ func StartOTP(router *gin.RouterGroup) {
 
 // OTP Auth (one-time-passcode auth)
 //
+// A code can be used once, and only a few times wrong before it is consumed.
+// Failed attempts also count against the email and the IP address, which are
+// locked out for longer each time they fail too often.
+//
 // POST /api/otp/verify
 func VerifyOTP(router *gin.RouterGroup, tokenMaker token.Maker) {
 	router.POST("/otp/verify", func(ctx *gin.Context) {
@@ -206,54 +236,148 @@ func VerifyOTP(router *gin.RouterGroup, tokenMaker token.Maker) {
 		}
 
 		if err := ctx.ShouldBindJSON(&f); err != nil {
-			ctx.JSON(http.StatusBadRequest, ErrorResponse(err))
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/otp/verify:00000001"))
 			return
 		}
 
-		u := query.FindUserByEmail(f.Email)
-		if u == nil {
-			ctx.JSON(http.StatusNotFound, "user not found")
+		until, err := otpLockedUntil(f.Email, ctx.ClientIP())
+		if err != nil {
+			log.Errorf("failed to find otp lockouts: %v", err)
+			AbortUnexpected(ctx)
+			return
+		} else if until != nil {
+			publishAuthEvent(ctx, "otp.locked", event.Data{"email": f.Email, "until": *until})
+			abortOtpLocked(ctx, *until, "/otp/verify:00000002")
 			return
 		}
 
-		result := totp.Validate(f.Code, u.Email.Secret)
-		log.Infof("code: %s, secret: %s", f.Code, u.Email.Secret)
-		if !result {
-			ctx.JSON(http.StatusBadRequest, "invalide code")
+		tx := db.Db().Begin()
+
+		// emails without an open challenge fail like wrong codes
+		challenge, err := query.TxFindOtpChallenge(tx, f.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			log.Errorf("failed to find otp challenge: %v", err)
+			AbortUnexpected(ctx)
 			return
 		}
 
-		// authorization token
-		accessToken, accessPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().AccessTokenDuration,
-		)
+		ok := false
+		attemptsLeft := 0
+		if challenge != nil {
+			ok = challenge.Attempt(f.Code)
+			attemptsLeft = challenge.AttemptsLeft()
+
+			if err := challenge.TxSave(tx); err != nil {
+				tx.Rollback()
+				log.Errorf("failed to save otp challenge: %v", err)
+				AbortSaveFailed(ctx)
+				return
+			}
+		}
+
+		locked, err := txRecordOtpResult(tx, f.Email, ctx.ClientIP(), ok)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
+			tx.Rollback()
+			log.Errorf("failed to save otp lockouts: %v", err)
+			AbortSaveFailed(ctx)
 			return
 		}
 
-		refreshToken, refreshPayload, err := tokenMaker.CreateToken(
-			u.ID,
-			u.UID,
-			u.Name,
-			config.Env().RefreshTokenDuration,
-		)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit otp attempt: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if !ok {
+			publishAuthEvent(ctx, "otp.failed", event.Data{"email": f.Email, "attempts_left": attemptsLeft, "locked": locked})
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(errOtpInvalid, "/otp/verify:00000003"))
 			return
 		}
 
-		rsp := form.LoginUserResponse{
-			// SessionID:             session.ID,
-			AccessToken:           accessToken,
-			AccessTokenExpiresAt:  accessPayload.ExpiredAt,
-			RefreshToken:          refreshToken,
-			RefreshTokenExpiresAt: refreshPayload.ExpiredAt,
+		u := query.FindUserByEmail(f.Email)
+		if u == nil {
+			ctx.JSON(http.StatusNotFound, ErrorResponse(errors.New("user not found"), "/otp/verify:00000004"))
+			return
 		}
 
+		rsp, err := createLoginSession(db.Db(), ctx, tokenMaker, u, string(entity.Mail))
+		if err != nil {
+			log.Errorf("failed to create session: %v", err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/otp/verify:00000005"))
+			return
+		}
+
+		publishAuthEvent(ctx, "otp.verified", event.Data{"email": f.Email, "user_id": u.ID})
+
 		ctx.JSON(http.StatusOK, rsp)
 	})
 }
+
+// otpLockoutPolicy returns the configured lockout of failed OTP attempts.
+func otpLockoutPolicy() entity.OtpLockoutPolicy {
+	return entity.OtpLockoutPolicy{
+		Failures: config.Env().OtpLockoutFailures,
+		Base:     config.Env().OtpLockoutBase,
+		Max:      config.Env().OtpLockoutMax,
+	}
+}
+
+// otpLockedUntil returns when the lockout of an email or an IP address ends,
+// or nil if neither is locked out.
+func otpLockedUntil(email, ip string) (*time.Time, error) {
+	lockouts, err := query.FindOtpLockouts(email, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	var until *time.Time
+	now := time.Now()
+	for i := range lockouts {
+		if lockouts[i].IsLocked(now) && (until == nil || lockouts[i].LockedUntil.After(*until)) {
+			until = lockouts[i].LockedUntil
+		}
+	}
+
+	return until, nil
+}
+
+// abortOtpLocked responds that OTP sign-ins are locked out until a time.
+func abortOtpLocked(ctx *gin.Context, until time.Time, code string) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	ctx.JSON(http.StatusTooManyRequests, ErrorResponse(errOtpLocked, code))
+}
+
+// txRecordOtpResult counts a failed attempt against the email and the IP
+// address, or forgives the email after a successful one, as part of the
+// transaction tx. It reports whether a failure locked either out.
+func txRecordOtpResult(tx *gorm.DB, email, ip string, ok bool) (bool, error) {
+	now := time.Now()
+	policy := otpLockoutPolicy()
+
+	if ok {
+		return false, query.TxForgiveOtpLockout(tx, entity.OtpLockoutEmail, strings.ToLower(email))
+	}
+
+	emailLockout, err := query.TxFindOtpLockout(tx, entity.OtpLockoutEmail, strings.ToLower(email))
+	if err != nil {
+		return false, err
+	}
+
+	ipLockout, err := query.TxFindOtpLockout(tx, entity.OtpLockoutIP, ip)
+	if err != nil {
+		return false, err
+	}
+
+	locked := emailLockout.Fail(now, policy)
+	if err := emailLockout.TxSave(tx); err != nil {
+		return false, err
+	}
+
+	if ipLockout.Fail(now, policy) {
+		locked = true
+	}
+
+	return locked, ipLockout.TxSave(tx)
+}
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// defaultPasskeyName is the name of passkeys registered without one.
+const defaultPasskeyName = "passkey"
+
+var errPasskeyCeremony = errors.New("passkey ceremony not found or expired")
+
+// passkeyUser is a user as seen by the WebAuthn relying party. Its handle is
+// the UID of the user.
+type passkeyUser struct {
+	*entity.User
+	passkeys entity.Passkeys
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(u.UID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.Name
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.Name
+}
+
+func (u *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+	for i := range u.passkeys {
+		credentials[i] = u.passkeys[i].Credential()
+	}
+
+	return credentials
+}
+
+// startPasskeyCeremony stores the session of a ceremony started by the user,
+// or of a login if userID is nil, and responds with the options for the
+// authenticator.
+func startPasskeyCeremony(ctx *gin.Context, userID *uint, session *webauthn.SessionData, options interface{}) {
+	if err := query.DeleteExpiredPasskeyCeremonies(); err != nil {
+		log.Errorf("failed to delete expired passkey ceremonies: %v", err)
+	}
+
+	ceremony := &entity.PasskeyCeremony{
+		Token:     rnd.GenerateRandomString(32),
+		UserID:    userID,
+		Session:   *session,
+		ExpiresAt: session.Expires,
+	}
+	if ceremony.ExpiresAt.IsZero() {
+		ceremony.ExpiresAt = time.Now().Add(config.Env().WebAuthnCeremonyTTL)
+	}
+
+	if err := ceremony.Create(); err != nil {
+		log.Errorf("failed to create passkey ceremony: %v", err)
+		AbortSaveFailed(ctx)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, form.PasskeyOptionsResponse{
+		Ceremony:  ceremony.Token,
+		Options:   options,
+		ExpiresAt: ceremony.ExpiresAt,
+	})
+}
+
+// findPasskeyUser returns a user with their passkeys.
+func findPasskeyUser(userID uint) (*passkeyUser, error) {
+	u, err := query.FindUserByUID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := query.FindPasskeysByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{User: u, passkeys: passkeys}, nil
+}
+
+// Passkey registers and lists the passkeys of the user. Registration is a
+// WebAuthn ceremony in two steps: the options returned by the first request
+// are passed to navigator.credentials.create(), and the credential it returns
+// is posted to the second one with the ceremony token.
+//
+// POST /api/passkey/register/begin
+// POST /api/passkey/register/finish?ceremony=:token&name=:name
+// GET /api/passkey
+// DELETE /api/passkey/:id
+func Passkey(router *gin.RouterGroup) {
+	router.POST("/passkey/register/begin", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		wa, err := config.WebAuthn()
+		if err != nil {
+			log.Errorf("cannot create webauthn relying party: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		u, err := findPasskeyUser(authPayload.UserID)
+		if err != nil {
+			Abort(ctx, http.StatusNotFound, "user not exists!")
+			return
+		}
+
+		// passkeys are discoverable, so that logins do not need a user name
+		exclusions := make([]protocol.CredentialDescriptor, len(u.passkeys))
+		for i := range u.passkeys {
+			exclusions[i] = u.passkeys[i].Credential().Descriptor()
+		}
+
+		options, session, err := wa.BeginRegistration(u,
+			webauthn.WithExclusions(exclusions),
+			webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+				ResidentKey:        protocol.ResidentKeyRequirementRequired,
+				RequireResidentKey: protocol.ResidentKeyRequired(),
+				UserVerification:   protocol.VerificationRequired,
+			}),
+		)
+		if err != nil {
+			log.Errorf("failed to begin passkey registration: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		startPasskeyCeremony(ctx, &u.ID, session, options)
+	})
+
+	router.POST("/passkey/register/finish", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		var f form.FinishPasskeyRequest
+		if err := ctx.ShouldBindQuery(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/passkey:00000001"))
+			return
+		}
+
+		if f.Name == "" {
+			f.Name = defaultPasskeyName
+		}
+
+		wa, err := config.WebAuthn()
+		if err != nil {
+			log.Errorf("cannot create webauthn relying party: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		u, err := findPasskeyUser(authPayload.UserID)
+		if err != nil {
+			Abort(ctx, http.StatusNotFound, "user not exists!")
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		ceremony, err := query.TxTakePasskeyCeremony(tx, f.Ceremony, &u.ID)
+		if err != nil {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(errPasskeyCeremony, "/passkey:00000002"))
+			return
+		}
+
+		credential, err := wa.FinishRegistration(u, ceremony.Session, ctx.Request)
+		if err != nil {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/passkey:00000003"))
+			return
+		}
+
+		passkey := entity.NewPasskey(u.ID, f.Name, credential)
+		if err := passkey.TxCreate(tx); err != nil {
+			tx.Rollback()
+			log.Errorf("failed to create passkey: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit passkey registration: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, passkey)
+	})
+
+	router.GET("/passkey", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		passkeys, err := query.FindPasskeysByUserID(authPayload.UserID)
+		if err != nil {
+			log.Errorf("failed to find passkeys: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, passkeys)
+	})
+
+	router.DELETE("/passkey/:id", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		if err := query.DeletePasskey(uint(id), authPayload.UserID); err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"id": id})
+	})
+}
+
+// PasskeyLogin signs in with a discoverable passkey, without a user name: the
+// options returned by the first request are passed to
+// navigator.credentials.get(), and the assertion it returns is posted to the
+// second one with the ceremony token. Passkeys verify the user themselves, so
+// the login is not stepped up for two-factor authentication.
+//
+// POST /api/passkey/login/begin
+// POST /api/passkey/login/finish?ceremony=:token
+func PasskeyLogin(router *gin.RouterGroup, tokenMaker token.Maker) {
+	router.POST("/passkey/login/begin", func(ctx *gin.Context) {
+		wa, err := config.WebAuthn()
+		if err != nil {
+			log.Errorf("cannot create webauthn relying party: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		options, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			log.Errorf("failed to begin passkey login: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		startPasskeyCeremony(ctx, nil, session, options)
+	})
+
+	router.POST("/passkey/login/finish", func(ctx *gin.Context) {
+		var f form.FinishPasskeyRequest
+		if err := ctx.ShouldBindQuery(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/passkey/login:00000001"))
+			return
+		}
+
+		wa, err := config.WebAuthn()
+		if err != nil {
+			log.Errorf("cannot create webauthn relying party: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		ceremony, err := query.TxTakePasskeyCeremony(tx, f.Ceremony, nil)
+		if err != nil {
+			tx.Rollback()
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errPasskeyCeremony, "/passkey/login:00000002"))
+			return
+		}
+
+		// the user is the owner of the passkey, which must match the user
+		// handle the authenticator stored with it
+		var passkey *entity.Passkey
+		var u *passkeyUser
+		handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+			var err error
+			if passkey, err = query.FindPasskeyByCredentialID(rawID); err != nil {
+				return nil, err
+			}
+
+			if u, err = findPasskeyUser(passkey.UserID); err != nil {
+				return nil, err
+			}
+
+			if string(userHandle) != u.UID {
+				return nil, errors.New("user handle does not match the passkey")
+			}
+
+			return u, nil
+		}
+
+		credential, err := wa.FinishDiscoverableLogin(handler, ceremony.Session, ctx.Request)
+		if err != nil {
+			tx.Rollback()
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(err, "/passkey/login:00000003"))
+			return
+		}
+
+		if credential.Authenticator.CloneWarning {
+			log.Warnf("sign count of passkey %d went backwards, it may have been cloned", passkey.ID)
+		}
+
+		if err := passkey.TxUse(tx, credential); err != nil {
+			tx.Rollback()
+			log.Errorf("failed to update passkey %d: %v", passkey.ID, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		rsp, err := createSession(tx, ctx, tokenMaker, u.User, nil)
+		if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to create session: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit passkey login: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, rsp)
+	})
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasskeyUser(t *testing.T) {
+	credential := &webauthn.Credential{
+		ID:              []byte("credential"),
+		PublicKey:       []byte("public key"),
+		AttestationType: "none",
+		Transport:       []protocol.AuthenticatorTransport{protocol.Internal, protocol.Hybrid},
+		Flags:           webauthn.CredentialFlags{BackupEligible: true, BackupState: true},
+		Authenticator:   webauthn.Authenticator{AAGUID: []byte("aaguid"), SignCount: 7},
+	}
+
+	u := &passkeyUser{
+		User:     &entity.User{ID: 1, UID: "u123", Name: "alice"},
+		passkeys: entity.Passkeys{*entity.NewPasskey(1, "laptop", credential)},
+	}
+
+	require.Equal(t, []byte("u123"), u.WebAuthnID())
+	require.Equal(t, "alice", u.WebAuthnName())
+	require.Equal(t, []webauthn.Credential{*credential}, u.WebAuthnCredentials())
+}
+
+// passkeyOrigin is the origin of the relying party of passkey tests.
+const passkeyOrigin = "http://localhost:3000"
+
+// testAuthenticator is a software passkey of the relying party localhost.
+type testAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   string
+	signCount    uint32
+}
+
+func newTestAuthenticator(t *testing.T, userHandle string) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return &testAuthenticator{key: key, credentialID: []byte("credential-" + userHandle), userHandle: userHandle}
+}
+
+// authenticatorData returns the authenticator data of a user verified
+// ceremony, with the credential if it is attested.
+func (a *testAuthenticator) authenticatorData(t *testing.T, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if !attested {
+		return data
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+
+	data = append(data, make([]byte, 16)...) // aaguid
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, publicKey...)
+}
+
+func (a *testAuthenticator) clientData(t *testing.T, ceremony, challenge string) []byte {
+	data, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": passkeyOrigin})
+	require.NoError(t, err)
+
+	return data
+}
+
+// register returns the credential navigator.credentials.create() returns for
+// the challenge.
+func (a *testAuthenticator) register(t *testing.T, challenge string) string {
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(t, true),
+	})
+	require.NoError(t, err)
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64(a.clientData(t, "webauthn.create", challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// login returns the assertion navigator.credentials.get() returns for the
+// challenge.
+func (a *testAuthenticator) login(t *testing.T, challenge string) string {
+	a.signCount++
+
+	authData := a.authenticatorData(t, false)
+	clientData := a.clientData(t, "webauthn.get", challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64([]byte(a.userHandle)),
+	})
+}
+
+func (a *testAuthenticator) credential(t *testing.T, response map[string]string) string {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newPasskeyRouter opens a test database with users 1 and 2, and returns user
+// 1 and a router signing in with passkeys under /api, and managing the
+// passkeys of user 1 under /api and of user 2 under /other.
+func newPasskeyRouter(t *testing.T) (*gin.Engine, *entity.User) {
+	gin.SetMode(gin.TestMode)
+	testdb.Open(t)
+
+	env := config.Env()
+	t.Cleanup(func() { config.SetEnv(env) })
+	config.SetEnv(config.EnvVar{
+		AccessTokenDuration:  time.Minute,
+		RefreshTokenDuration: time.Hour,
+		WebAuthnRPID:         "localhost",
+		WebAuthnRPName:       "hello",
+		WebAuthnRPOrigins:    []string{passkeyOrigin},
+		WebAuthnCeremonyTTL:  time.Minute,
+	})
+
+	users := []*entity.User{{ID: 1, Name: "alice"}, {ID: 2, Name: "bob"}}
+	for _, u := range users {
+		require.NoError(t, db.Db().Create(u).Error)
+	}
+
+	keyring, err := token.NewKeyring("1", "12345678901234567890123456789012", nil)
+	require.NoError(t, err)
+	tokenMaker, err := token.NewPasetoMaker(keyring)
+	require.NoError(t, err)
+
+	router := gin.New()
+	PasskeyLogin(router.Group("/api"), tokenMaker)
+
+	for prefix, userID := range map[string]uint{"/api": 1, "/other": 2} {
+		userID := userID
+		authorized := router.Group(prefix)
+		authorized.Use(func(ctx *gin.Context) {
+			ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{UserID: userID})
+		})
+		Passkey(authorized)
+	}
+
+	return router, users[0]
+}
+
+// beginPasskeyCeremony returns the ceremony token and challenge of a ceremony
+// started at path.
+func beginPasskeyCeremony(t *testing.T, router *gin.Engine, path string) (string, string) {
+	var rsp struct {
+		Ceremony string `json:"ceremony"`
+		Options  struct {
+			PublicKey struct {
+				Challenge string `json:"challenge"`
+			} `json:"publicKey"`
+		} `json:"options"`
+	}
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, path, "", &rsp))
+	require.NotEmpty(t, rsp.Ceremony)
+	require.NotEmpty(t, rsp.Options.PublicKey.Challenge)
+
+	return rsp.Ceremony, rsp.Options.PublicKey.Challenge
+}
+
+func TestPasskeyCeremonies(t *testing.T) {
+	router, u := newPasskeyRouter(t)
+	authenticator := newTestAuthenticator(t, u.UID)
+
+	// registration
+	ceremony, challenge := beginPasskeyCeremony(t, router, "/api/passkey/register/begin")
+	credential := authenticator.register(t, challenge)
+
+	// only the user who started it can finish it
+	require.Equal(t, http.StatusBadRequest, serveJSON(t, router, http.MethodPost, "/other/passkey/register/finish?ceremony="+ceremony, credential, nil))
+
+	var passkey entity.Passkey
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/passkey/register/finish?ceremony="+ceremony+"&name=laptop", credential, &passkey))
+	require.Equal(t, uint(1), passkey.UserID)
+	require.Equal(t, "laptop", passkey.Name)
+
+	// the ceremony is single-use
+	require.Equal(t, http.StatusBadRequest, serveJSON(t, router, http.MethodPost, "/api/passkey/register/finish?ceremony="+ceremony, credential, nil))
+
+	var passkeys entity.Passkeys
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodGet, "/api/passkey", "", &passkeys))
+	require.Len(t, passkeys, 1)
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodGet, "/other/passkey", "", &passkeys))
+	require.Empty(t, passkeys)
+
+	// login
+	ceremony, challenge = beginPasskeyCeremony(t, router, "/api/passkey/login/begin")
+
+	// the assertion must sign the challenge of the ceremony
+	_, otherChallenge := beginPasskeyCeremony(t, router, "/api/passkey/login/begin")
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/passkey/login/finish?ceremony="+ceremony, authenticator.login(t, otherChallenge), nil))
+
+	assertion := authenticator.login(t, challenge)
+	var login form.LoginUserResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/passkey/login/finish?ceremony="+ceremony, assertion, &login))
+	require.NotEmpty(t, login.AccessToken)
+	require.NotEmpty(t, login.RefreshToken)
+
+	// the ceremony is single-use, so the assertion cannot be replayed
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/passkey/login/finish?ceremony="+ceremony, assertion, nil))
+
+	used, err := query.FindPasskeyByCredentialID(authenticator.credentialID)
+	require.NoError(t, err)
+	require.Equal(t, authenticator.signCount, used.SignCount)
+	require.NotNil(t, used.LastUsedAt)
+
+	// a registration ceremony cannot be used to log in
+	ceremony, challenge = beginPasskeyCeremony(t, router, "/api/passkey/register/begin")
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/passkey/login/finish?ceremony="+ceremony, authenticator.login(t, challenge), nil))
+}
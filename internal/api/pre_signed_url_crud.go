@@ -1,69 +1,512 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
-	"github.com/Hello-Storage/hello-storage-proxy/pkg/s3"
-	s3V2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/quota"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/contentid"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/gin-gonic/gin"
+	"github.com/ipfs/go-cid"
+	"gorm.io/gorm"
 )
 
-// GeneratePutPresignedURL function will generate a presigned URL for client to upload directly to S3
+var (
+	errUIDOrCID = errors.New("exactly one of uid or cid is required")
+	// errUploadCompleted is returned when a presigned upload is completed twice.
+	errUploadCompleted = errors.New("upload was already completed")
+	// errFileNotOwned is returned when the content of a file is replaced by a
+	// user who no longer owns it.
+	errFileNotOwned = errors.New("file is not owned by the user")
+)
+
+// presignExpiry returns the lifetime of a presigned URL, given the number of
+// seconds requested by the client (0 for the default).
+func presignExpiry(requested int64) (time.Duration, error) {
+	if requested == 0 {
+		return config.Env().PresignDefaultExpiry, nil
+	}
+
+	expiry := time.Duration(requested) * time.Second
+	if expiry < config.Env().PresignMinExpiry || expiry > config.Env().PresignMaxExpiry {
+		return 0, fmt.Errorf(
+			"expires_in must be between %d and %d seconds",
+			int64(config.Env().PresignMinExpiry.Seconds()),
+			int64(config.Env().PresignMaxExpiry.Seconds()),
+		)
+	}
+
+	return expiry, nil
+}
+
+// validObjectCID checks that s is a CID in its canonical string form, so that
+// it can safely be used as an object key.
+func validObjectCID(s string) error {
+	c, err := cid.Decode(s)
+	if err != nil {
+		return fmt.Errorf("invalid cid: %w", err)
+	}
+
+	if c.String() != s {
+		return fmt.Errorf("cid must be in canonical form: %s", c.String())
+	}
+
+	return nil
+}
+
+// verifiableCID parses a CID the content uploaded for it can be checked against.
+func verifiableCID(s string) (cid.Cid, error) {
+	c, err := cid.Decode(s)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid cid: %w", err)
+	}
+
+	// only content addressed by its raw sha2-256 hash can be checked
+	if !contentid.Verifiable(c) {
+		return cid.Undef, errors.New("cid must be a CIDv1 with raw codec and sha2-256 hash")
+	}
+
+	return c, nil
+}
+
+// checkObjectWritable checks that a user may write the object stored under a
+// CID. Verified objects are never overwritten, and other objects only by a
+// user who is the only one referencing them, since other files share them.
+func checkObjectWritable(userID uint, objectCID string) (int, error) {
+	blob, err := query.FindBlobByCID(objectCID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("failed to find blob by cid: %v", err)
+		return http.StatusInternalServerError, errors.New("failed to find object")
+	}
+	if blob != nil && blob.Verified {
+		return http.StatusConflict, errors.New("object is verified and cannot be overwritten")
+	}
+
+	users, err := query.FindUsersByFileCID(objectCID)
+	if err != nil {
+		log.Errorf("failed to find users by cid: %v", err)
+		return http.StatusInternalServerError, errors.New("failed to find object owners")
+	}
+
+	if slices.ContainsFunc(users, func(u uint) bool { return u != userID }) {
+		return http.StatusConflict, errors.New("object is shared with other files")
+	}
+
+	return http.StatusOK, nil
+}
 
-func GeneratePutPresignedObject(s3V2Client *s3V2.Client, router *gin.RouterGroup) {
+// redactPresignedURL removes the signature and credentials from a presigned
+// URL, so that audit records cannot be replayed and do not reveal the access
+// key of the bucket.
+func redactPresignedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	q := u.Query()
+	q.Del("X-Amz-Signature")
+	q.Del("X-Amz-Credential")
+	q.Del("X-Amz-Security-Token")
+	q.Del(storage.LocalSignatureParam)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// auditPresignedURL records an issued presigned URL for the object key, which
+// holds the content of the CID.
+func auditPresignedURL(ctx *gin.Context, userID uint, fileID *uint, objectKey, objectCID, contentType string, contentLength int64, req *storage.PresignedRequest, expiresAt time.Time) error {
+	m := entity.PresignedURLLog{
+		UserID:        userID,
+		FileID:        fileID,
+		Method:        req.Method,
+		ObjectKey:     objectKey,
+		CID:           objectCID,
+		ContentType:   contentType,
+		ContentLength: contentLength,
+		URL:           redactPresignedURL(req.URL),
+		ClientIP:      ctx.ClientIP(),
+		ExpiresAt:     expiresAt,
+	}
+
+	return m.Create()
+}
 
-	router.PUT(("presigned-url"), func(c *gin.Context) {
+// GeneratePutPresignedObject generates a presigned URL for the client to upload a
+// file directly to the storage backend. The content type and length are signed
+// so any other upload is rejected. The content is staged under the returned
+// object key until the upload is completed, which verifies it against the CID.
+//
+// PUT /api/file/presigned-url
+func GeneratePutPresignedObject(backend storage.Backend, router *gin.RouterGroup) {
 
-		presigner := s3.Presigner{
-			PresignClient: s3V2.NewPresignClient(s3V2Client),
+	router.PUT("/presigned-url", RequireScope(token.ScopeFilesWrite), func(c *gin.Context) {
+		authPayload := c.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		var f form.PutPresignedURLRequest
+		if err := c.ShouldBindJSON(&f); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000001"))
+			return
+		}
+
+		if (f.UID == "") == (f.CID == "") {
+			c.JSON(http.StatusBadRequest, ErrorResponse(errUIDOrCID, "/file/presigned-url:00000002"))
+			return
 		}
 
-		presignedHTTPRequest, err := presigner.PutObject(config.Env().StorageBucket, "objectKey.pdf", 60*15)
+		if f.ContentLength > config.Env().PresignMaxContentLength {
+			c.JSON(
+				http.StatusRequestEntityTooLarge,
+				ErrorResponse(fmt.Errorf("content_length exceeds %d bytes", config.Env().PresignMaxContentLength), "/file/presigned-url:00000003"),
+			)
+			return
+		}
 
-		log.Printf("Presigned URL: %s", presignedHTTPRequest.URL)
-		log.Printf("Presigned Method: %s", presignedHTTPRequest.Method)
-		log.Printf("Presigned Headers: %v", presignedHTTPRequest.SignedHeader)
+		if _, _, err := mime.ParseMediaType(f.ContentType); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(fmt.Errorf("invalid content_type: %w", err), "/file/presigned-url:00000004"))
+			return
+		}
 
+		expiry, err := presignExpiry(f.ExpiresIn)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000005"))
+			return
+		}
+
+		var objectCID string
+		var fileID *uint
+		required := f.ContentLength
+
+		if f.UID != "" {
+			file, err := query.FindFileByUID(f.UID)
+			if err != nil {
+				AbortEntityNotFound(c)
+				return
+			}
+
+			isOwner, err := entity.IsFileOwner(file.ID, authPayload.UserID)
+			if err != nil {
+				log.Errorf("failed to check file owner: %v", err)
+				AbortUnexpected(c)
+				return
+			}
+			if !isOwner {
+				AbortEntityNotFound(c)
+				return
+			}
+
+			if code, err := checkObjectWritable(authPayload.UserID, file.CID); err != nil {
+				c.JSON(code, ErrorResponse(err, "/file/presigned-url:00000013"))
+				return
+			}
+
+			if _, err := verifiableCID(file.CID); err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000022"))
+				return
+			}
+
+			objectCID = file.CID
+			fileID = &file.ID
+			required -= file.Size
+		} else {
+			if err := validObjectCID(f.CID); err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000006"))
+				return
+			}
+
+			if _, err := verifiableCID(f.CID); err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000006"))
+				return
+			}
+
+			if code, err := checkObjectWritable(authPayload.UserID, f.CID); err != nil {
+				c.JSON(code, ErrorResponse(err, "/file/presigned-url:00000007"))
+				return
+			}
+
+			objectCID = f.CID
+		}
+
+		// the content replaces the content of the CID once it is verified
+		objectKey := stagingKey()
+
+		if !checkQuota(c, authPayload.UserID, required, "/file/presigned-url:00000014") {
+			return
+		}
+
+		presignedRequest, err := backend.PresignPut(c.Request.Context(), objectKey, f.ContentType, f.ContentLength, expiry)
 		if err != nil {
 			log.Errorf("failed to generate presigned URL, %v", err)
+			c.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/presigned-url:00000008"))
+			return
+		}
+
+		expiresAt := time.Now().Add(expiry)
+		if err := auditPresignedURL(c, authPayload.UserID, fileID, objectKey, objectCID, f.ContentType, f.ContentLength, presignedRequest, expiresAt); err != nil {
+			log.Errorf("failed to audit presigned URL, %v", err)
+			AbortSaveFailed(c)
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"presigned_url": presignedHTTPRequest.URL,
-			"method":        presignedHTTPRequest.Method,
-			"headers":       presignedHTTPRequest.SignedHeader,
+		c.JSON(http.StatusOK, form.PresignedURLResponse{
+			PresignedURL: presignedRequest.URL,
+			Method:       presignedRequest.Method,
+			Headers:      presignedRequest.Header,
+			ObjectKey:    objectKey,
+			ExpiresAt:    expiresAt,
 		})
 	})
 
 }
 
-// GenerateGetPresignedURL function will generate a presigned URL for client to upload directly to S3
+// CompletePutPresignedObject completes an upload to a presigned URL. The staged
+// content is stored under its CID once it is verified against it, and a file
+// is created for an upload by CID. Content that does not match is deleted.
+//
+// POST /api/file/presigned-url/complete
+func CompletePutPresignedObject(backend storage.Backend, router *gin.RouterGroup) {
 
-func GenerateGetPresignedObject(s3V2Client *s3V2.Client, router *gin.RouterGroup) {
+	router.POST("/presigned-url/complete", RequireScope(token.ScopeFilesWrite), func(c *gin.Context) {
+		authPayload := c.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
 
-	router.GET(("presigned-url"), func(c *gin.Context) {
-		//get object key from query parameter
-		objectKey := c.Query("objectKey")
+		var f form.CompletePresignedUploadRequest
+		if err := c.ShouldBindJSON(&f); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000015"))
+			return
+		}
+
+		upload, err := query.FindPresignedUpload(f.ObjectKey, authPayload.UserID)
+		if err != nil {
+			AbortEntityNotFound(c)
+			return
+		}
+
+		if upload.CompletedAt != nil {
+			c.JSON(http.StatusConflict, ErrorResponse(errUploadCompleted, "/file/presigned-url:00000016"))
+			return
+		}
+
+		var file *entity.File
+		if upload.FileID != nil {
+			// the file may have been deleted since the URL was issued
+			file, err = query.FindFileByID(*upload.FileID)
+			if err != nil {
+				AbortEntityNotFound(c)
+				return
+			}
+		} else {
+			if f.Name == "" {
+				c.JSON(http.StatusBadRequest, ErrorResponse(errors.New("name is required"), "/file/presigned-url:00000017"))
+				return
+			}
+
+			if f.Root == "" {
+				f.Root = "/"
+			}
+
+			if f.EncryptionStatus == "" {
+				f.EncryptionStatus = entity.Public
+			}
+
+			if f.MimeType == "" {
+				f.MimeType = upload.ContentType
+			}
+
+			if status, err := checkUploadTarget(authPayload.UserID, upload.CID, f.Root, f.EncryptionStatus); err != nil {
+				c.JSON(status, ErrorResponse(err, "/file/presigned-url:00000018"))
+				return
+			}
+
+			isInPool := true
+			file = &entity.File{
+				Name:             f.Name,
+				Root:             f.Root,
+				Path:             f.Path,
+				CID:              upload.CID,
+				Mime:             f.MimeType,
+				IsInPool:         &isInPool,
+				EncryptionStatus: f.EncryptionStatus,
+			}
+			if f.CIDOriginalEncrypted != "" {
+				file.CIDOriginalEncrypted = &f.CIDOriginalEncrypted
+			}
+		}
+
+		size, err := verifyStagedObject(c.Request.Context(), backend, upload.ObjectKey, cid.MustParse(upload.CID))
+		if errors.Is(err, errCIDMismatch) {
+			if err := backend.Delete(c.Request.Context(), upload.ObjectKey); err != nil {
+				log.Errorf("failed to delete staged upload %s: %v", upload.ObjectKey, err)
+			}
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse(err, "/file/presigned-url:00000019"))
+			return
+		} else if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse(errors.New("no content was uploaded"), "/file/presigned-url:00000020"))
+			return
+		} else if err != nil {
+			log.Errorf("failed to verify staged upload %s: %v", upload.ObjectKey, err)
+			c.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/presigned-url:00000021"))
+			return
+		}
+
+		if upload.FileID == nil {
+			file.Size = size
+		}
+
+		u := requestUploader(c)
+		err = promoteStaged(c.Request.Context(), backend, upload.ObjectKey, upload.CID, size, func(tx *gorm.DB) error {
+			if completed, err := upload.TxComplete(tx); err != nil {
+				return err
+			} else if !completed {
+				return errUploadCompleted
+			}
+
+			if upload.FileID != nil {
+				return txReplaceFileContent(tx, file, size, u.userID)
+			}
+
+			return createOwnedFile(tx, file, u)
+		})
+		if errors.Is(err, errUploadCompleted) {
+			c.JSON(http.StatusConflict, ErrorResponse(err, "/file/presigned-url:00000016"))
+			return
+		} else if errors.Is(err, errFileNotOwned) {
+			AbortEntityNotFound(c)
+			return
+		} else if respondQuotaExceeded(c, err, "/file/presigned-url:00000014") {
+			return
+		} else if err != nil {
+			log.Errorf("failed to complete presigned upload %s: %v", upload.ObjectKey, err)
+			AbortSaveFailed(c)
+			return
+		}
+
+		if err := backend.Delete(c.Request.Context(), upload.ObjectKey); err != nil {
+			log.Errorf("failed to delete staged upload %s: %v", upload.ObjectKey, err)
+		}
+
+		c.JSON(http.StatusOK, fileResponse(file))
+	})
+
+}
+
+// txReplaceFileContent records the size of the verified content replacing the
+// content of a file as part of the transaction tx, and charges the difference
+// to the storage of the user who replaced it. It returns errFileNotOwned if
+// the user no longer owns the file, and an *quota.ExceededError if the larger
+// content does not fit into the user's quota. The caller must hold the blob
+// lock.
+func txReplaceFileContent(tx *gorm.DB, file *entity.File, size int64, userID uint) error {
+	if err := tx.First(file, file.ID).Error; err != nil {
+		return err
+	}
+
+	fileUser, err := query.TxFindFileUser(tx, file.ID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errFileNotOwned
+	} else if err != nil {
+		return err
+	} else if fileUser.Permission != entity.OwnerPermission {
+		return errFileNotOwned
+	}
+
+	delta := size - file.Size
+	if delta == 0 {
+		return nil
+	}
+
+	if err := quota.TxCheck(tx, userID, delta); err != nil {
+		return err
+	}
+
+	if err := tx.Model(file).UpdateColumn("size", size).Error; err != nil {
+		return err
+	}
+	file.Size = size
+
+	return query.TxAdjustStorageUsed(tx, userID, &file.ID, delta, entity.UsageFileReplaced)
+}
 
-		presigner := s3.Presigner{
-			PresignClient: s3V2.NewPresignClient(s3V2Client),
+// GenerateGetPresignedObject generates a presigned URL for the client to download
+// a file it has access to directly from the storage backend.
+//
+// GET /api/file/presigned-url?uid=...
+func GenerateGetPresignedObject(backend storage.Backend, router *gin.RouterGroup) {
+
+	router.GET("/presigned-url", RequireScope(token.ScopeFilesRead), func(c *gin.Context) {
+		authPayload := c.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		var f form.GetPresignedURLRequest
+		if err := c.ShouldBindQuery(&f); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000009"))
+			return
+		}
+
+		if (f.UID == "") == (f.CID == "") {
+			c.JSON(http.StatusBadRequest, ErrorResponse(errUIDOrCID, "/file/presigned-url:00000010"))
+			return
 		}
 
-		presignedHTTPRequest, err := presigner.GetObject(config.Env().StorageBucket, objectKey, 60*15)
+		expiry, err := presignExpiry(f.ExpiresIn)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(err, "/file/presigned-url:00000011"))
+			return
+		}
 
-		log.Printf("Presigned URL: %s", presignedHTTPRequest.URL)
-		log.Printf("Presigned Method: %s", presignedHTTPRequest.Method)
-		log.Printf("Presigned Headers: %v", presignedHTTPRequest.SignedHeader)
+		var file *entity.File
+		if f.UID != "" {
+			file, err = query.FindFileByUID(f.UID)
+			if err != nil {
+				AbortEntityNotFound(c)
+				return
+			}
 
+			if _, err := query.FindFileUser(file.ID, authPayload.UserID); err != nil {
+				AbortEntityNotFound(c)
+				return
+			}
+		} else {
+			files, err := query.FindFilesByUserAndFileCID(authPayload.UserID, f.CID)
+			if err != nil || len(files) == 0 {
+				AbortEntityNotFound(c)
+				return
+			}
+
+			file = &files[0]
+		}
+
+		presignedRequest, err := backend.PresignGet(c.Request.Context(), file.CID, expiry)
 		if err != nil {
 			log.Errorf("failed to generate presigned URL, %v", err)
+			c.JSON(http.StatusBadGateway, ErrorResponse(err, "/file/presigned-url:00000012"))
+			return
+		}
+
+		expiresAt := time.Now().Add(expiry)
+		if err := auditPresignedURL(c, authPayload.UserID, &file.ID, file.CID, file.CID, "", 0, presignedRequest, expiresAt); err != nil {
+			log.Errorf("failed to audit presigned URL, %v", err)
+			AbortSaveFailed(c)
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"presigned_url": presignedHTTPRequest.URL,
-			"method":        presignedHTTPRequest.Method,
-			"headers":       presignedHTTPRequest.SignedHeader,
+		c.JSON(http.StatusOK, form.PresignedURLResponse{
+			PresignedURL: presignedRequest.URL,
+			Method:       presignedRequest.Method,
+			Headers:      presignedRequest.Header,
+			ObjectKey:    file.CID,
+			ExpiresAt:    expiresAt,
 		})
 	})
 
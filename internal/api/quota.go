@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/quota"
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaErrorResponse returns the response to requests exceeding the storage
+// quota, which includes the quota so clients can tell how much space is left.
+func QuotaErrorResponse(err *quota.ExceededError, code string) gin.H {
+	response := ErrorResponse(err, code)
+	response["quota"] = err
+
+	return response
+}
+
+// respondQuotaExceeded responds with a quota error and returns true if err is
+// an *quota.ExceededError.
+func respondQuotaExceeded(ctx *gin.Context, err error, code string) bool {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+
+	ctx.JSON(exceeded.Status(), QuotaErrorResponse(exceeded, code))
+	return true
+}
+
+// checkQuota checks that size more bytes fit into the quota of a user,
+// responding with an error and returning false if they do not.
+func checkQuota(ctx *gin.Context, userID uint, size int64, code string) bool {
+	err := quota.Check(userID, size)
+	if err == nil {
+		return true
+	}
+
+	if !respondQuotaExceeded(ctx, err, code) {
+		log.Errorf("failed to check quota of user %d: %v", userID, err)
+		AbortUnexpected(ctx)
+	}
+
+	return false
+}
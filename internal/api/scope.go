@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope returns a handler that rejects requests whose authorization
+// does not grant a scope. Routes declare the scope they need with it:
+//
+//	router.GET("/path", RequireScope(token.ScopeFilesRead), func(ctx *gin.Context) { ... })
+func RequireScope(scope token.Scope) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		if !authPayload.HasScope(scope) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse(fmt.Errorf("missing scope %s", scope), "/scope:00000001"))
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// RequireSession returns a handler that rejects requests authorized by an
+// API key rather than by a login session.
+func RequireSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := ctx.Get(constant.APIKeyContextKey); ok {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse(errors.New("not allowed with an api key"), "/scope:00000002"))
+			return
+		}
+
+		ctx.Next()
+	}
+}
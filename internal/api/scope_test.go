@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	testCases := []struct {
+		name   string
+		scopes []token.Scope
+		status int
+	}{
+		{"session", nil, http.StatusOK},
+		{"granted", []token.Scope{token.ScopeFilesRead, token.ScopeFilesWrite}, http.StatusOK},
+		{"missing", []token.Scope{token.ScopeFilesRead}, http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(ctx *gin.Context) {
+				ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{Scopes: tc.scopes})
+			})
+			router.PUT("/upload", RequireScope(token.ScopeFilesWrite), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/upload", nil))
+			require.Equal(t, tc.status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errSessionInvalid = errors.New("session is invalid")
+
+// createSession issues an access token and a refresh token for a user and
+// records the refresh token as a session, as part of the transaction tx. The
+// session continues the family of prev if it is not nil, and starts a new one
+// otherwise.
+func createSession(tx *gorm.DB, ctx *gin.Context, tokenMaker token.Maker, u *entity.User, prev *entity.Session) (*form.LoginUserResponse, error) {
+	accessToken, accessPayload, err := tokenMaker.CreateAccessToken(
+		u.ID,
+		u.UID,
+		u.Name,
+		config.Env().AccessTokenDuration,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshPayload, err := tokenMaker.CreateRefreshToken(
+		u.ID,
+		u.UID,
+		u.Name,
+		config.Env().RefreshTokenDuration,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &entity.Session{
+		ID:        refreshPayload.TokenID,
+		FamilyID:  refreshPayload.TokenID,
+		UserID:    u.ID,
+		Device:    truncate(ctx.GetHeader(constant.DeviceHeaderKey), 256),
+		IP:        truncate(ctx.ClientIP(), 64),
+		UserAgent: truncate(ctx.Request.UserAgent(), 512),
+		StartedAt: refreshPayload.IssuedAt,
+		ExpiresAt: refreshPayload.ExpiredAt,
+	}
+	if prev != nil {
+		session.FamilyID = prev.FamilyID
+		session.StartedAt = prev.StartedAt
+		if session.Device == "" {
+			session.Device = prev.Device
+		}
+	}
+
+	if err := session.TxCreate(tx); err != nil {
+		return nil, err
+	}
+
+	return &form.LoginUserResponse{
+		SessionID:             session.FamilyID,
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessPayload.ExpiredAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshPayload.ExpiredAt,
+	}, nil
+}
+
+// findRefreshSession returns the session of a refresh token. Presenting a
+// token that was already rotated revokes its whole family.
+func findRefreshSession(tokenMaker token.Maker, refreshToken string) (*entity.Session, error) {
+	payload, err := tokenMaker.VerifyRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := query.FindSession(payload.TokenID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && session.UserID != payload.UserID) {
+		return nil, errSessionInvalid
+	} else if err != nil {
+		return nil, err
+	}
+
+	if session.RotatedAt != nil && session.RevokedAt == nil {
+		if err := revokeReusedSession(db.Db(), session); err != nil {
+			log.Errorf("failed to revoke session %s: %v", session.FamilyID, err)
+		}
+	}
+
+	if !session.IsActive() {
+		return nil, errSessionInvalid
+	}
+
+	return session, nil
+}
+
+// revokeReusedSession revokes the family of a session whose refresh token was
+// used more than once, as part of the transaction tx.
+func revokeReusedSession(tx *gorm.DB, session *entity.Session) error {
+	log.Warnf("refresh token of session %s reused, revoking it", session.FamilyID)
+
+	// the family was revoked concurrently
+	if err := query.TxRevokeSessionFamily(tx, session.FamilyID, session.UserID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+
+	return s
+}
+
+// Logout revokes the session of a refresh token.
+//
+// POST /api/logout
+func Logout(router *gin.RouterGroup, tokenMaker token.Maker) {
+	router.POST("/logout", func(ctx *gin.Context) {
+		var f form.LogoutRequest
+		if err := ctx.ShouldBindJSON(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/logout:00000001"))
+			return
+		}
+
+		session, err := findRefreshSession(tokenMaker, f.RefreshToken)
+		if err != nil {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(err, "/logout:00000002"))
+			return
+		}
+
+		if err := query.RevokeSessionFamily(session.FamilyID, session.UserID); errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errSessionInvalid, "/logout:00000002"))
+			return
+		} else if err != nil {
+			log.Errorf("failed to revoke session %s: %v", session.FamilyID, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"session_id": session.FamilyID})
+	})
+}
+
+// Sessions lists and revokes the sessions of the user, so that devices can be
+// logged out remotely. Revoked sessions cannot be renewed; their access tokens
+// stay valid until they expire.
+//
+// GET /api/sessions
+// DELETE /api/sessions/:id
+func Sessions(router *gin.RouterGroup) {
+	router.GET("/sessions", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		sessions, err := query.FindActiveSessionsByUserID(authPayload.UserID)
+		if err != nil {
+			log.Errorf("failed to find sessions: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, sessions)
+	})
+
+	router.DELETE("/sessions/:id", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		id, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			AbortEntityNotFound(ctx)
+			return
+		}
+
+		err = query.RevokeSessionFamily(id, authPayload.UserID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			AbortEntityNotFound(ctx)
+			return
+		} else if err != nil {
+			log.Errorf("failed to revoke session %s: %v", id, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"session_id": id})
+	})
+}
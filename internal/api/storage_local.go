@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// LocalStorage serves the presigned requests of the local storage driver, which
+// S3 would otherwise serve. Requests are authorized by their signature only.
+//
+// GET /api/storage/*key
+// PUT /api/storage/*key
+func LocalStorage(backend *storage.Local, router *gin.RouterGroup) {
+	verify := func(ctx *gin.Context) (string, bool) {
+		key := strings.TrimPrefix(ctx.Param("key"), "/")
+
+		if err := backend.Verify(ctx.Request, key); err != nil {
+			ctx.JSON(http.StatusForbidden, ErrorResponse(err, "/storage:00000001"))
+			return "", false
+		}
+
+		return key, true
+	}
+
+	router.GET("/storage/*key", func(ctx *gin.Context) {
+		key, ok := verify(ctx)
+		if !ok {
+			return
+		}
+
+		f, info, err := backend.Open(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			AbortNotFound(ctx)
+			return
+		} else if err != nil {
+			log.Errorf("failed to open %s: %v", key, err)
+			AbortUnexpected(ctx)
+			return
+		}
+		defer f.Close()
+
+		if info.ETag != "" {
+			ctx.Header("ETag", info.ETag)
+		}
+		if info.ContentType != "" {
+			ctx.Header("Content-Type", info.ContentType)
+		}
+
+		http.ServeContent(ctx.Writer, ctx.Request, "", info.LastModified, f)
+	})
+
+	router.PUT("/storage/*key", func(ctx *gin.Context) {
+		key, ok := verify(ctx)
+		if !ok {
+			return
+		}
+
+		uploadID := ctx.Query(storage.LocalUploadIDParam)
+		if uploadID == "" {
+			if err := backend.Put(ctx.Request.Context(), key, ctx.Request.Body, ctx.ContentType()); err != nil {
+				log.Errorf("failed to store %s: %v", key, err)
+				AbortSaveFailed(ctx)
+				return
+			}
+
+			ctx.Status(http.StatusOK)
+			return
+		}
+
+		partNumber, err := strconv.ParseInt(ctx.Query(storage.LocalPartNumberParam), 10, 32)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/storage:00000002"))
+			return
+		}
+
+		etag, err := backend.PutPart(ctx.Request.Context(), key, uploadID, int32(partNumber), ctx.Request.Body)
+		if errors.Is(err, storage.ErrNotFound) {
+			AbortNotFound(ctx)
+			return
+		} else if err != nil {
+			log.Errorf("failed to store part %d of %s: %v", partNumber, key, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.Header("ETag", etag)
+		ctx.Status(http.StatusOK)
+	})
+}
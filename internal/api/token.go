@@ -3,46 +3,88 @@ package api
 import (
 	"net/http"
 
-	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/gin-gonic/gin"
 )
 
-// RenewAccessToken
+// RenewAccessToken renews the session of a refresh token, rotating the
+// refresh token. Reusing a rotated refresh token revokes the session. The
+// response includes the new refresh token, which clients must store in place
+// of the one they sent, as well as the id of the session.
 //
 // POST /api/token/renew
 func RenewAccessToken(router *gin.RouterGroup, tokenMaker token.Maker) {
 	router.POST("/token/renew", func(ctx *gin.Context) {
 		var req form.RenewAccessTokenRequest
 		if err := ctx.ShouldBindJSON(&req); err != nil {
-			ctx.JSON(http.StatusBadRequest, ErrorResponse(err))
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/token/renew:00000001"))
 			return
 		}
 
-		refreshPayload, err := tokenMaker.VerifyToken(req.RefreshToken)
+		session, err := findRefreshSession(tokenMaker, req.RefreshToken)
 		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, ErrorResponse(err))
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(err, "/token/renew:00000002"))
 			return
 		}
 
-		// TO-DO check session
+		u, err := query.FindUserByUID(session.UserID)
+		if err != nil {
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errSessionInvalid, "/token/renew:00000002"))
+			return
+		}
+
+		tx := db.Db().Begin()
 
-		accessToken, accessPayload, err := tokenMaker.CreateToken(
-			refreshPayload.UserID,
-			refreshPayload.UserUID,
-			refreshPayload.UserName,
-			config.Env().AccessTokenDuration,
-		)
+		rotated, err := query.TxRotateSession(tx, session.ID)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err))
+			tx.Rollback()
+			log.Errorf("failed to rotate session %s: %v", session.FamilyID, err)
+			AbortSaveFailed(ctx)
 			return
 		}
 
-		rsp := form.RenewAccessTokenResponse{
-			AccessToken:          accessToken,
-			AccessTokenExpiresAt: accessPayload.ExpiredAt,
+		// the token was used concurrently
+		if !rotated {
+			if err := revokeReusedSession(tx, session); err != nil {
+				tx.Rollback()
+				log.Errorf("failed to revoke session %s: %v", session.FamilyID, err)
+				AbortSaveFailed(ctx)
+				return
+			}
+
+			if err := tx.Commit().Error; err != nil {
+				log.Errorf("failed to commit revocation of session %s: %v", session.FamilyID, err)
+				AbortSaveFailed(ctx)
+				return
+			}
+
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errSessionInvalid, "/token/renew:00000002"))
+			return
 		}
-		ctx.JSON(http.StatusOK, rsp)
+
+		rsp, err := createSession(tx, ctx, tokenMaker, u, session)
+		if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to renew session %s: %v", session.FamilyID, err)
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse(err, "/token/renew:00000003"))
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit renewal of session %s: %v", session.FamilyID, err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, form.RenewAccessTokenResponse{
+			SessionID:             rsp.SessionID,
+			AccessToken:           rsp.AccessToken,
+			AccessTokenExpiresAt:  rsp.AccessTokenExpiresAt,
+			RefreshToken:          rsp.RefreshToken,
+			RefreshTokenExpiresAt: rsp.RefreshTokenExpiresAt,
+		})
 	})
 }
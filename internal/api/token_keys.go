@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenKeys publishes the public keys tokens are signed with, so that other
+// services can verify them. Tokens name their key in the kid of their footer.
+//
+// GET /.well-known/paseto-keys
+func TokenKeys(maker *token.PublicMaker, router *gin.RouterGroup) {
+	router.GET("/.well-known/paseto-keys", func(ctx *gin.Context) {
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(http.StatusOK, gin.H{"keys": maker.PublicKeys()})
+	})
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// newSessionRouter opens a test database with user 1 and a session of them,
+// and returns a router renewing and revoking sessions.
+func newSessionRouter(t *testing.T) (*gin.Engine, *form.LoginUserResponse) {
+	gin.SetMode(gin.TestMode)
+	testdb.Open(t)
+
+	env := config.Env()
+	t.Cleanup(func() { config.SetEnv(env) })
+	config.SetEnv(config.EnvVar{AccessTokenDuration: time.Minute, RefreshTokenDuration: time.Hour})
+
+	u := &entity.User{ID: 1, UID: "u1", Name: "user"}
+	require.NoError(t, db.Db().Create(u).Error)
+
+	keyring, err := token.NewKeyring("1", "12345678901234567890123456789012", nil)
+	require.NoError(t, err)
+	tokenMaker, err := token.NewPasetoMaker(keyring)
+	require.NoError(t, err)
+
+	router := gin.New()
+	RenewAccessToken(router.Group("/api"), tokenMaker)
+	Logout(router.Group("/api"), tokenMaker)
+
+	authorized := router.Group("/api")
+	authorized.Use(func(ctx *gin.Context) {
+		ctx.Set(constant.AuthorizationPayloadKey, &token.Payload{UserID: 1})
+	})
+	Sessions(authorized)
+
+	ctx, _ := gin.CreateTestContext(nil)
+	ctx.Request, _ = http.NewRequest(http.MethodPost, "/api/login", nil)
+
+	login, err := createSession(db.Db(), ctx, tokenMaker, u, nil)
+	require.NoError(t, err)
+
+	return router, login
+}
+
+func renewBody(refreshToken string) string {
+	return `{"refresh_token":"` + refreshToken + `"}`
+}
+
+func TestRenewAccessTokenRotates(t *testing.T) {
+	router, login := newSessionRouter(t)
+
+	var renewed form.RenewAccessTokenResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(login.RefreshToken), &renewed))
+	require.Equal(t, login.SessionID, renewed.SessionID)
+	require.NotEqual(t, login.RefreshToken, renewed.RefreshToken)
+	require.NotEmpty(t, renewed.AccessToken)
+
+	var again form.RenewAccessTokenResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(renewed.RefreshToken), &again))
+	require.Equal(t, login.SessionID, again.SessionID)
+
+	// the session is listed once, with its latest refresh token
+	sessions, err := query.FindActiveSessionsByUserID(1)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, login.SessionID, sessions[0].FamilyID)
+}
+
+func TestRenewAccessTokenReuse(t *testing.T) {
+	router, login := newSessionRouter(t)
+
+	var renewed form.RenewAccessTokenResponse
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(login.RefreshToken), &renewed))
+
+	// reusing the rotated token revokes the session, including the token it
+	// was rotated to
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(login.RefreshToken), nil))
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(renewed.RefreshToken), nil))
+
+	sessions, err := query.FindActiveSessionsByUserID(1)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+}
+
+func TestRenewAccessTokenConcurrentReuse(t *testing.T) {
+	router, login := newSessionRouter(t)
+
+	session, err := query.FindActiveSessionsByUserID(1)
+	require.NoError(t, err)
+	require.Len(t, session, 1)
+
+	// the token was rotated by a concurrent request after it was loaded
+	tx := db.Db().Begin()
+	rotated, err := query.TxRotateSession(tx, session[0].ID)
+	require.NoError(t, err)
+	require.True(t, rotated)
+	require.NoError(t, revokeReusedSession(tx, &session[0]))
+	require.NoError(t, tx.Commit().Error)
+
+	// revoking a revoked family again is not an error
+	require.NoError(t, revokeReusedSession(db.Db(), &session[0]))
+
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(login.RefreshToken), nil))
+}
+
+func TestLogoutAndRevokeSession(t *testing.T) {
+	router, login := newSessionRouter(t)
+
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodPost, "/api/logout", renewBody(login.RefreshToken), nil))
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/logout", renewBody(login.RefreshToken), nil))
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(login.RefreshToken), nil))
+
+	// revoked sessions are not found
+	require.Equal(t, http.StatusNotFound, serveJSON(t, router, http.MethodDelete, "/api/sessions/"+login.SessionID.String(), "", nil))
+}
+
+func TestRevokeSession(t *testing.T) {
+	router, login := newSessionRouter(t)
+
+	require.Equal(t, http.StatusOK, serveJSON(t, router, http.MethodDelete, "/api/sessions/"+login.SessionID.String(), "", nil))
+	require.Equal(t, http.StatusUnauthorized, serveJSON(t, router, http.MethodPost, "/api/token/renew", renewBody(login.RefreshToken), nil))
+	require.Equal(t, http.StatusNotFound, serveJSON(t, router, http.MethodDelete, "/api/sessions/not-a-session", "", nil))
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/crypto"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	// twoFactorIssuer is the account issuer shown by authenticator apps.
+	twoFactorIssuer = "hello.app"
+	// recoveryCodeCount is the number of recovery codes issued on enrolment.
+	recoveryCodeCount = 10
+	// twoFactorMaxAttempts is the number of codes a step-up challenge can be
+	// answered with.
+	twoFactorMaxAttempts = 5
+)
+
+var (
+	errTwoFactorEnabled   = errors.New("two-factor authentication is already enabled")
+	errTwoFactorDisabled  = errors.New("two-factor authentication is not enabled")
+	errTwoFactorCode      = errors.New("invalid code")
+	errTwoFactorChallenge = errors.New("challenge not found or expired")
+)
+
+// createLoginSession issues a session for a user who signed in with the first
+// factor method, as part of the transaction tx. If the user enabled
+// two-factor authentication, it returns a step-up challenge instead.
+func createLoginSession(tx *gorm.DB, ctx *gin.Context, tokenMaker token.Maker, u *entity.User, method string) (*form.LoginUserResponse, error) {
+	tf, err := query.TxFindTwoFactor(tx, u.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !tf.IsEnabled()) {
+		return createSession(tx, ctx, tokenMaker, u, nil)
+	} else if err != nil {
+		return nil, err
+	}
+
+	challenge := &entity.TwoFactorChallenge{
+		Token:     rnd.GenerateRandomString(32),
+		UserID:    u.ID,
+		Method:    method,
+		ExpiresAt: time.Now().Add(config.Env().TwoFactorChallengeTTL),
+	}
+
+	if err := challenge.TxCreate(tx); err != nil {
+		return nil, err
+	}
+
+	return &form.LoginUserResponse{
+		TwoFactor: &form.TwoFactorChallengeResponse{
+			Challenge: challenge.Token,
+			Methods:   []string{entity.TwoFactorTOTP, entity.TwoFactorRecoveryCode},
+			ExpiresAt: challenge.ExpiresAt,
+		},
+	}, nil
+}
+
+// twoFactorSecret returns the decrypted secret of a second factor.
+func twoFactorSecret(tf *entity.TwoFactor) (string, error) {
+	return crypto.Decrypt(tf.Secret)
+}
+
+// txValidateTwoFactor checks an authenticator code of a user as part of the
+// transaction tx, recording it so that it cannot be used again.
+func txValidateTwoFactor(tx *gorm.DB, tf *entity.TwoFactor, code string) (bool, error) {
+	secret, err := twoFactorSecret(tf)
+	if err != nil {
+		return false, err
+	}
+
+	if !tf.Validate(secret, strings.TrimSpace(code), time.Now()) {
+		return false, nil
+	}
+
+	return true, tf.TxSave(tx)
+}
+
+// generateRecoveryCodes returns new recovery codes, formatted as two groups of
+// five characters.
+func generateRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code := strings.ToLower(rnd.GenerateRandomString(10))
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes
+}
+
+// TwoFactor enrols the user in two-factor authentication with an
+// authenticator app. Enrolment returns the provisioning URI and recovery
+// codes; the factor is enabled once a first code confirms it. Disabling it
+// requires a current code.
+//
+// GET /api/2fa
+// POST /api/2fa/enroll
+// POST /api/2fa/confirm
+// POST /api/2fa/disable
+func TwoFactor(router *gin.RouterGroup) {
+	router.GET("/2fa", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		rsp := form.TwoFactorStatusResponse{}
+
+		tf, err := query.FindTwoFactor(authPayload.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("failed to find two-factor: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		if tf != nil && tf.IsEnabled() {
+			rsp.Enabled = true
+			rsp.EnabledAt = tf.EnabledAt
+
+			if rsp.RecoveryCodesLeft, err = query.CountRecoveryCodes(authPayload.UserID); err != nil {
+				log.Errorf("failed to count recovery codes: %v", err)
+				AbortUnexpected(ctx)
+				return
+			}
+		}
+
+		ctx.JSON(http.StatusOK, rsp)
+	})
+
+	// enrolling again before confirming replaces the pending factor
+	router.POST("/2fa/enroll", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		u, err := query.FindUserByUID(authPayload.UserID)
+		if err != nil {
+			Abort(ctx, http.StatusNotFound, "user not exists!")
+			return
+		}
+
+		key, err := totp.Generate(totp.GenerateOpts{
+			Issuer:      twoFactorIssuer,
+			AccountName: u.Name,
+			Period:      entity.TwoFactorPeriod,
+			Digits:      otp.DigitsSix,
+			Algorithm:   otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			log.Errorf("failed to generate two-factor key: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		secret, err := crypto.Encrypt(key.Secret())
+		if err != nil {
+			log.Errorf("failed to encrypt two-factor secret: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		tf, err := query.TxFindTwoFactor(tx, u.ID)
+		if err == nil && tf.IsEnabled() {
+			tx.Rollback()
+			ctx.JSON(http.StatusConflict, ErrorResponse(errTwoFactorEnabled, "/2fa:00000001"))
+			return
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			log.Errorf("failed to find two-factor: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		if err := query.TxDeleteTwoFactor(tx, u.ID); err != nil {
+			tx.Rollback()
+			log.Errorf("failed to delete pending two-factor: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := (&entity.TwoFactor{UserID: u.ID, Secret: secret}).TxCreate(tx); err != nil {
+			tx.Rollback()
+			log.Errorf("failed to create two-factor: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		codes := generateRecoveryCodes()
+		recoveryCodes := make([]entity.RecoveryCode, len(codes))
+		for i, code := range codes {
+			recoveryCodes[i] = entity.RecoveryCode{UserID: u.ID, CodeHash: entity.HashRecoveryCode(code)}
+		}
+
+		if err := tx.Create(&recoveryCodes).Error; err != nil {
+			tx.Rollback()
+			log.Errorf("failed to create recovery codes: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit two-factor enrolment: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, form.EnrollTwoFactorResponse{
+			Secret:        key.Secret(),
+			URI:           key.URL(),
+			RecoveryCodes: codes,
+		})
+	})
+
+	router.POST("/2fa/confirm", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		var f form.TwoFactorCodeRequest
+		if err := ctx.ShouldBindJSON(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/2fa:00000002"))
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		tf, err := query.TxFindTwoFactor(tx, authPayload.UserID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			AbortEntityNotFound(ctx)
+			return
+		} else if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to find two-factor: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		if tf.IsEnabled() {
+			tx.Rollback()
+			ctx.JSON(http.StatusConflict, ErrorResponse(errTwoFactorEnabled, "/2fa:00000001"))
+			return
+		}
+
+		now := time.Now()
+		tf.EnabledAt = &now
+
+		ok, err := txValidateTwoFactor(tx, tf, f.Code)
+		if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to validate two-factor code: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		if !ok {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(errTwoFactorCode, "/2fa:00000003"))
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit two-factor confirmation: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		publishAuthEvent(ctx, "2fa.enabled", event.Data{"user_id": authPayload.UserID})
+
+		ctx.JSON(http.StatusOK, form.TwoFactorStatusResponse{
+			Enabled:           true,
+			EnabledAt:         tf.EnabledAt,
+			RecoveryCodesLeft: recoveryCodeCount,
+		})
+	})
+
+	router.POST("/2fa/disable", RequireSession(), func(ctx *gin.Context) {
+		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
+
+		var f form.TwoFactorCodeRequest
+		if err := ctx.ShouldBindJSON(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/2fa:00000002"))
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		tf, err := query.TxFindTwoFactor(tx, authPayload.UserID)
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !tf.IsEnabled()) {
+			tx.Rollback()
+			ctx.JSON(http.StatusConflict, ErrorResponse(errTwoFactorDisabled, "/2fa:00000004"))
+			return
+		} else if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to find two-factor: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		// a recovery code is not enough, the authenticator must still be at hand
+		ok, err := txValidateTwoFactor(tx, tf, f.Code)
+		if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to validate two-factor code: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		if !ok {
+			tx.Rollback()
+			publishAuthEvent(ctx, "2fa.failed", event.Data{"user_id": authPayload.UserID, "action": "disable"})
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(errTwoFactorCode, "/2fa:00000003"))
+			return
+		}
+
+		if err := query.TxDeleteTwoFactor(tx, authPayload.UserID); err != nil {
+			tx.Rollback()
+			log.Errorf("failed to delete two-factor: %v", err)
+			AbortDeleteFailed(ctx)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit disabling two-factor: %v", err)
+			AbortDeleteFailed(ctx)
+			return
+		}
+
+		publishAuthEvent(ctx, "2fa.disabled", event.Data{"user_id": authPayload.UserID})
+
+		ctx.JSON(http.StatusOK, form.TwoFactorStatusResponse{})
+	})
+}
+
+// VerifyTwoFactor answers the step-up challenge returned by a login with an
+// authenticator code or an unused recovery code, and issues the session.
+//
+// POST /api/2fa/verify
+func VerifyTwoFactor(router *gin.RouterGroup, tokenMaker token.Maker) {
+	router.POST("/2fa/verify", func(ctx *gin.Context) {
+		var f form.VerifyTwoFactorRequest
+		if err := ctx.ShouldBindJSON(&f); err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse(err, "/2fa/verify:00000001"))
+			return
+		}
+
+		tx := db.Db().Begin()
+
+		challenge, err := query.TxFindTwoFactorChallenge(tx, f.Challenge)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errTwoFactorChallenge, "/2fa/verify:00000002"))
+			return
+		} else if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to find two-factor challenge: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		tf, err := query.TxFindTwoFactor(tx, challenge.UserID)
+		if err != nil {
+			tx.Rollback()
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errTwoFactorDisabled, "/2fa/verify:00000003"))
+			return
+		}
+
+		ok, err := txValidateTwoFactor(tx, tf, f.Code)
+		method := entity.TwoFactorTOTP
+		if err == nil && !ok {
+			method = entity.TwoFactorRecoveryCode
+			ok, err = query.TxUseRecoveryCode(tx, challenge.UserID, f.Code)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to validate two-factor code: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		challenge.Attempts++
+		if ok || challenge.Attempts >= twoFactorMaxAttempts {
+			now := time.Now()
+			challenge.ConsumedAt = &now
+		}
+
+		if err := challenge.TxSave(tx); err != nil {
+			tx.Rollback()
+			log.Errorf("failed to save two-factor challenge: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if !ok {
+			if err := tx.Commit().Error; err != nil {
+				log.Errorf("failed to commit two-factor attempt: %v", err)
+			}
+
+			publishAuthEvent(ctx, "2fa.failed", event.Data{
+				"user_id":       challenge.UserID,
+				"attempts_left": twoFactorMaxAttempts - challenge.Attempts,
+			})
+			ctx.JSON(http.StatusUnauthorized, ErrorResponse(errTwoFactorCode, "/2fa/verify:00000004"))
+			return
+		}
+
+		u, err := query.FindUserByUID(challenge.UserID)
+		if err != nil {
+			tx.Rollback()
+			Abort(ctx, http.StatusNotFound, "user not exists!")
+			return
+		}
+
+		rsp, err := createSession(tx, ctx, tokenMaker, u, nil)
+		if err != nil {
+			tx.Rollback()
+			log.Errorf("failed to create session: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			log.Errorf("failed to commit two-factor login: %v", err)
+			AbortSaveFailed(ctx)
+			return
+		}
+
+		publishAuthEvent(ctx, "2fa.verified", event.Data{"user_id": u.ID, "method": method, "login": challenge.Method})
+
+		ctx.JSON(http.StatusOK, rsp)
+	})
+}
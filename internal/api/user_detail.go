@@ -6,7 +6,9 @@ import (
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/form"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/quota"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -28,7 +30,7 @@ type SharedListUser struct {
 // GET /api/user/:uid
 func GetUserDetail(router *gin.RouterGroup) {
 	router.Use(cors.Default())
-	router.GET("/user/detail", func(ctx *gin.Context) {
+	router.GET("/user/detail", RequireScope(token.ScopeAccountRead), func(ctx *gin.Context) {
 		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
 
 		user_detail := query.FindUserDetailByUserID(authPayload.UserID)
@@ -59,10 +61,22 @@ func GetUserDetail(router *gin.RouterGroup) {
 			return
 		}
 
-		ctx.JSON(http.StatusOK, user_detail)
+		q, err := quota.Find(authPayload.UserID)
+		if err != nil {
+			log.Errorf("failed to find quota: %v", err)
+			AbortUnexpected(ctx)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, form.UserDetailResponse{
+			UserDetail:       user_detail,
+			Plan:             q.Plan,
+			StorageCapacity:  q.Capacity,
+			StorageRemaining: q.Remaining,
+		})
 	})
 
-	router.GET("/user/shared/general", func(ctx *gin.Context) {
+	router.GET("/user/shared/general", RequireScope(token.ScopeFilesRead), func(ctx *gin.Context) {
 		authPayload := ctx.MustGet(constant.AuthorizationPayloadKey).(*token.Payload)
 
 		// get user from the db to check if it exists
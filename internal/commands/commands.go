@@ -2,16 +2,42 @@ package commands
 
 import (
 	"context"
+	"fmt"
 	// "time"
 	// "github.com/Hello-Storage/hello-back/internal/entity"
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/server"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/workers"
+	"github.com/urfave/cli/v2"
 )
 
 var log = event.Log
 
+// Commands are the commands of the binary. It starts the server if it is run
+// without one.
+var Commands = []*cli.Command{
+	StartCommand,
+	RecomputeUsageCommand,
+	GenerateTokenKeyCommand,
+}
+
+// StartCommand starts the server.
+var StartCommand = &cli.Command{
+	Name:   "start",
+	Usage:  "Starts the web server and the background workers",
+	Action: startAction,
+}
+
+// startAction starts the server.
+func startAction(ctx *cli.Context) error {
+	Start()
+
+	fmt.Println("Server running!!")
+	return nil
+}
+
 func Start() {
 	// init logger
 	config.InitLogger()
@@ -33,6 +59,8 @@ func Start() {
 	// Pass this context down the chain.
 	cctx, cancel := context.WithCancel(context.Background())
 
+	workers.Start(cctx)
+
 	server.Start(cctx)
 
 	// Cancel the context when the server stops
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/urfave/cli/v2"
+)
+
+// RecomputeUsageCommand rebuilds the storage used by every user from the
+// usage ledger.
+var RecomputeUsageCommand = &cli.Command{
+	Name:  "recompute-usage",
+	Usage: "Rebuilds the storage used by every user from the usage ledger",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report drift, do not change storage used",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		RecomputeUsage(ctx.Bool("dry-run"))
+		return nil
+	},
+}
+
+// recomputeUsageBatch is the number of users loaded per query.
+const recomputeUsageBatch = 500
+
+// RecomputeUsage rebuilds the storage used by every user from the usage
+// ledger and reports the users whose counter had drifted from it. With dryRun,
+// drift is only reported. Users without ledger entries get their counter as
+// the opening entry, so that later runs cover them.
+func RecomputeUsage(dryRun bool) {
+	// init logger
+	config.InitLogger()
+
+	// load env
+	if err := config.LoadEnv(); err != nil {
+		log.Fatal("cannot load config:", err)
+	}
+
+	// connect db and define enum types
+	if err := config.ConnectDB(); err != nil {
+		log.Fatal("cannot connect to DB and create enums:", err)
+	}
+
+	config.InitDb()
+
+	var after uint
+	var checked, opened, drifted int
+
+	for {
+		ids, err := query.FindUserIDsWithDetailsAfter(after, recomputeUsageBatch)
+		if err != nil {
+			log.Fatal("cannot find users:", err)
+		}
+
+		for _, id := range ids {
+			wasOpened, drift, err := recomputeUserUsage(id, dryRun)
+			if err != nil {
+				log.Fatalf("cannot recompute usage of user %d: %v", id, err)
+			}
+
+			checked++
+			if wasOpened {
+				opened++
+			}
+			if drift != 0 {
+				drifted++
+			}
+		}
+
+		if len(ids) < recomputeUsageBatch {
+			break
+		}
+		after = ids[len(ids)-1]
+	}
+
+	log.Infof("usage: checked %d users, opened %d ledgers, %d drifted (dry run: %t)", checked, opened, drifted, dryRun)
+}
+
+// recomputeUserUsage rebuilds the storage used by a user from the ledger. It
+// returns whether the ledger was opened, and the difference of the counter to
+// the ledger.
+func recomputeUserUsage(userID uint, dryRun bool) (opened bool, drift int64, err error) {
+	tx := db.Db().Begin()
+	defer tx.Rollback()
+
+	detail, err := query.TxFindUserDetailForUpdate(tx, userID)
+	if err != nil {
+		return false, 0, err
+	}
+
+	used, hasEntries, err := query.TxSumUsageLedger(tx, userID)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if !hasEntries {
+		if dryRun {
+			return true, 0, nil
+		}
+
+		if err := query.TxOpenUsageLedger(tx, detail); err != nil {
+			return false, 0, err
+		}
+
+		return true, 0, tx.Commit().Error
+	}
+
+	drift = int64(detail.StorageUsed) - used
+	if drift == 0 {
+		return false, 0, nil
+	}
+
+	log.Warnf("usage: user %d uses %d bytes, ledger has %d (drift %d)", userID, detail.StorageUsed, used, drift)
+
+	if dryRun {
+		return false, drift, nil
+	}
+
+	if err := query.TxSetStorageUsed(tx, detail, uint(max(used, 0))); err != nil {
+		return false, 0, err
+	}
+
+	return false, drift, tx.Commit().Error
+}
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecomputeUserUsage(t *testing.T) {
+	testdb.Open(t)
+
+	require.NoError(t, db.Db().Create(&entity.UserDetail{UserID: 1, StorageUsed: 30}).Error)
+	require.NoError(t, db.Db().Create(&entity.UsageEntry{UserID: 1, Delta: 20, Reason: entity.UsageFileCreated}).Error)
+
+	opened, drift, err := recomputeUserUsage(1, true)
+	require.NoError(t, err)
+	require.False(t, opened)
+	require.Equal(t, int64(10), drift)
+	require.Equal(t, uint(30), query.FindUserDetailByUserID(1).StorageUsed)
+
+	opened, drift, err = recomputeUserUsage(1, false)
+	require.NoError(t, err)
+	require.False(t, opened)
+	require.Equal(t, int64(10), drift)
+	require.Equal(t, uint(20), query.FindUserDetailByUserID(1).StorageUsed)
+
+	_, drift, err = recomputeUserUsage(1, false)
+	require.NoError(t, err)
+	require.Zero(t, drift)
+}
+
+func TestRecomputeUserUsageOpensLedger(t *testing.T) {
+	testdb.Open(t)
+
+	require.NoError(t, db.Db().Create(&entity.UserDetail{UserID: 1, StorageUsed: 30}).Error)
+
+	opened, _, err := recomputeUserUsage(1, true)
+	require.NoError(t, err)
+	require.True(t, opened)
+
+	_, hasEntries, err := query.TxSumUsageLedger(db.Db(), 1)
+	require.NoError(t, err)
+	require.False(t, hasEntries)
+
+	opened, _, err = recomputeUserUsage(1, false)
+	require.NoError(t, err)
+	require.True(t, opened)
+
+	used, hasEntries, err := query.TxSumUsageLedger(db.Db(), 1)
+	require.NoError(t, err)
+	require.True(t, hasEntries)
+	require.Equal(t, int64(30), used)
+}
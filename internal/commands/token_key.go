@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/urfave/cli/v2"
+)
+
+// GenerateTokenKeyCommand prints the token settings rotating to a new key.
+var GenerateTokenKeyCommand = &cli.Command{
+	Name:  "generate-token-key",
+	Usage: "Prints the token settings rotating to a new key",
+	Action: func(ctx *cli.Context) error {
+		GenerateTokenKey()
+		return nil
+	},
+}
+
+// GenerateTokenKey prints the token settings rotating to a new key of the
+// configured token mode: the new key becomes the active one and the current
+// key is retired, so that the tokens issued with it remain valid. Retired keys
+// can be removed once the tokens and API keys issued with them are no longer
+// needed.
+func GenerateTokenKey() {
+	// init logger
+	config.InitLogger()
+
+	// load env
+	if err := config.LoadEnv(); err != nil {
+		log.Fatal("cannot load config:", err)
+	}
+
+	env := config.Env()
+	id := nextKeyID(env.TokenKeyID)
+
+	if env.TokenMode == config.TokenModePublic {
+		current, err := token.NewSigningKeys(env.TokenKeyID, env.TokenSigningKey, nil)
+		if err != nil {
+			log.Fatal("cannot load signing key:", err)
+		}
+
+		retired := retireKey(env.TokenRetiredPublicKeys, env.TokenKeyID, current.PublicKeys()[0].Key)
+
+		seed, _, err := token.GenerateSigningKey()
+		if err != nil {
+			log.Fatal("cannot generate signing key:", err)
+		}
+
+		// check that the settings load
+		if _, err := token.NewSigningKeys(id, seed, retired); err != nil {
+			log.Fatal("cannot generate signing key:", err)
+		}
+
+		fmt.Printf("TOKEN_KEY_ID=%s\n", id)
+		fmt.Printf("TOKEN_SIGNING_KEY=%s\n", seed)
+		fmt.Printf("TOKEN_RETIRED_PUBLIC_KEYS=%s\n", joinKeys(retired))
+		return
+	}
+
+	retired := retireKey(env.TokenRetiredKeys, env.TokenKeyID, env.TokenSymmetricKey)
+	key := token.GenerateKey()
+
+	// check that the settings load
+	if _, err := token.NewKeyring(id, key, retired); err != nil {
+		log.Fatal("cannot generate token key:", err)
+	}
+
+	fmt.Printf("TOKEN_KEY_ID=%s\n", id)
+	fmt.Printf("TOKEN_SYMMETRIC_KEY=%s\n", key)
+	fmt.Printf("TOKEN_RETIRED_KEYS=%s\n", joinKeys(retired))
+}
+
+// retireKey returns the retired keys with the key id added.
+func retireKey(retired map[string]string, id, key string) map[string]string {
+	keys := make(map[string]string, len(retired)+1)
+	for id, key := range retired {
+		keys[id] = key
+	}
+	keys[id] = key
+
+	return keys
+}
+
+// joinKeys formats keys as comma separated id:key pairs, sorted by id.
+func joinKeys(keys map[string]string) string {
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	pairs := make([]string, len(ids))
+	for i, id := range ids {
+		pairs[i] = id + ":" + keys[id]
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// nextKeyID returns the id following a numeric key id, or a timestamp for
+// other ids.
+func nextKeyID(id string) string {
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+		return strconv.FormatUint(n+1, 10)
+	}
+
+	return time.Now().UTC().Format("20060102150405")
+}
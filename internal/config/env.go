@@ -2,11 +2,17 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/oauth"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/joho/godotenv"
 )
 
@@ -15,10 +21,16 @@ type EnvVar struct {
 	AppPort string
 	AppEnv  string
 	// token env
-	TokenSymmetricKey    string
-	AccessTokenDuration  time.Duration
-	RefreshTokenDuration time.Duration
-	MailGunApiKey        string
+	TokenMode              string
+	TokenVersion           string // version of public tokens
+	TokenSymmetricKey      string `token:"local"`
+	TokenSigningKey        string `token:"public"`
+	TokenKeyID             string
+	TokenRetiredKeys       map[string]string // key id to key, still accepted for verification
+	TokenRetiredPublicKeys map[string]string
+	AccessTokenDuration    time.Duration
+	RefreshTokenDuration   time.Duration
+	MailGunApiKey          string
 	// Postgres env
 	DBHost     string
 	DBName     string
@@ -26,13 +38,58 @@ type EnvVar struct {
 	DBPassword string
 	DBPort     string
 	// storage keys
-	StorageAccessKey string
-	StorageSecretKey string
-	StorageBucket    string
-	StorageEndpoint  string
-	StorageRegion    string
-	EncryptionKey    string
-	EpochZero        int64
+	StorageDriver    string
+	StorageAccessKey string `driver:"s3"`
+	StorageSecretKey string `driver:"s3"`
+	StorageBucket    string `driver:"s3"`
+	StorageEndpoint  string `driver:"s3"`
+	StorageRegion    string `driver:"s3"`
+	StorageLocalPath string `driver:"local"`
+	StorageLocalURL  string `driver:"local"`
+	// key presigned requests of the local driver are signed with
+	StorageLocalSecret string `driver:"local"`
+	EncryptionKey      string
+	EpochZero          int64
+	// presigned url limits
+	PresignMaxContentLength int64
+	PresignDefaultExpiry    time.Duration
+	PresignMinExpiry        time.Duration
+	PresignMaxExpiry        time.Duration
+	// multipart uploads
+	MultipartUploadTTL       time.Duration
+	MultipartJanitorInterval time.Duration
+	// pool reconciliation
+	ReconcileInterval    time.Duration
+	ReconcileConcurrency int
+	// storage quota of users without a subscription plan
+	DefaultStorageCapacity int64
+	// sign-in with ethereum, disabled unless a domain or uri is set
+	SiweDomain   string `optional:"true"`
+	SiweURI      string `optional:"true"`
+	SiweChainIDs []int64
+	SiweNonceTTL time.Duration
+	// ethereum json-rpc endpoint verifying signatures of contract wallets
+	EthRPCURL string `optional:"true"`
+	// passkeys, the relying party defaults to the sign-in with ethereum domain
+	// and passkeys are unavailable without one
+	WebAuthnRPID        string `optional:"true"`
+	WebAuthnRPName      string
+	WebAuthnRPOrigins   []string
+	WebAuthnCeremonyTTL time.Duration
+	// email one-time codes and the lockout of failed attempts
+	OtpTTL             time.Duration
+	OtpMaxAttempts     int
+	OtpLockoutFailures int
+	OtpLockoutBase     time.Duration
+	OtpLockoutMax      time.Duration
+	// step-up challenges of users with two-factor authentication
+	TwoFactorChallengeTTL time.Duration
+	// github oauth app, github login is disabled without it
+	GithubClientID     string `optional:"true"`
+	GithubClientSecret string `optional:"true"`
+	GithubRedirectURL  string `optional:"true"`
+	// openid connect providers, see parseOIDCProviders
+	OIDCProviders []oauth.OIDCConfig `optional:"true"`
 }
 
 var env EnvVar
@@ -56,14 +113,172 @@ func LoadEnv() (err error) {
 		return err
 	}
 
+	presignDefault, err := durationOrDefault("PRESIGN_DEFAULT_EXPIRY", 15*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	presignMin, err := durationOrDefault("PRESIGN_MIN_EXPIRY", time.Minute)
+	if err != nil {
+		return err
+	}
+
+	presignMax, err := durationOrDefault("PRESIGN_MAX_EXPIRY", time.Hour)
+	if err != nil {
+		return err
+	}
+
+	if presignMin > presignDefault || presignDefault > presignMax {
+		return fmt.Errorf("config: presign expiry must satisfy min <= default <= max")
+	}
+
+	presignMaxLength, err := int64OrDefault("PRESIGN_MAX_CONTENT_LENGTH", 5<<30)
+	if err != nil {
+		return err
+	}
+
+	multipartTTL, err := durationOrDefault("MULTIPART_UPLOAD_TTL", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	// the janitor deletes staged content after the TTL, which must not
+	// happen while a presigned URL to upload it is still valid
+	if presignMax > multipartTTL {
+		return fmt.Errorf("config: PRESIGN_MAX_EXPIRY must not exceed MULTIPART_UPLOAD_TTL")
+	}
+
+	multipartJanitor, err := durationOrDefault("MULTIPART_JANITOR_INTERVAL", time.Hour)
+	if err != nil {
+		return err
+	}
+
+	reconcileInterval, err := durationOrDefault("RECONCILE_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	reconcileConcurrency, err := int64OrDefault("RECONCILE_CONCURRENCY", 8)
+	if err != nil {
+		return err
+	}
+	if reconcileConcurrency < 1 {
+		return fmt.Errorf("config: RECONCILE_CONCURRENCY must be at least 1")
+	}
+
+	defaultCapacity, err := int64OrDefault("DEFAULT_STORAGE_CAPACITY", 10<<30)
+	if err != nil {
+		return err
+	}
+
+	tokenMode := stringOrDefault("TOKEN_MODE", TokenModeLocal)
+	if tokenMode != TokenModeLocal && tokenMode != TokenModePublic {
+		return fmt.Errorf("config: unknown TOKEN_MODE %q", tokenMode)
+	}
+
+	tokenVersion := stringOrDefault("TOKEN_VERSION", token.PublicV4)
+	if tokenVersion != token.PublicV2 && tokenVersion != token.PublicV4 {
+		return fmt.Errorf("config: unknown TOKEN_VERSION %q", tokenVersion)
+	}
+
+	retiredKeys, err := parseKeys("TOKEN_RETIRED_KEYS")
+	if err != nil {
+		return err
+	}
+
+	retiredPublicKeys, err := parseKeys("TOKEN_RETIRED_PUBLIC_KEYS")
+	if err != nil {
+		return err
+	}
+
+	siweChainIDs, err := int64sOrDefault("SIWE_CHAIN_IDS", []int64{1})
+	if err != nil {
+		return err
+	}
+
+	siweNonceTTL, err := durationOrDefault("SIWE_NONCE_TTL", 10*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	webAuthnCeremonyTTL, err := durationOrDefault("WEBAUTHN_CEREMONY_TTL", 5*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	siweDomain, siweURI, err := siweOrigin()
+	if err != nil {
+		return err
+	}
+
+	webAuthnRPID := os.Getenv("WEBAUTHN_RP_ID")
+	if webAuthnRPID == "" {
+		webAuthnRPID = siweDomain
+		if host, _, err := net.SplitHostPort(webAuthnRPID); err == nil {
+			webAuthnRPID = host
+		}
+	}
+
+	var webAuthnRPOrigins []string
+	if siweURI != "" {
+		webAuthnRPOrigins = []string{siweURI}
+	}
+	webAuthnRPOrigins = stringsOrDefault("WEBAUTHN_RP_ORIGINS", webAuthnRPOrigins)
+
+	otpTTL, err := durationOrDefault("OTP_TTL", 30*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	otpMaxAttempts, err := int64OrDefault("OTP_MAX_ATTEMPTS", 5)
+	if err != nil {
+		return err
+	}
+
+	otpLockoutFailures, err := int64OrDefault("OTP_LOCKOUT_FAILURES", 10)
+	if err != nil {
+		return err
+	}
+
+	otpLockoutBase, err := durationOrDefault("OTP_LOCKOUT_BASE", time.Minute)
+	if err != nil {
+		return err
+	}
+
+	otpLockoutMax, err := durationOrDefault("OTP_LOCKOUT_MAX", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	twoFactorChallengeTTL, err := durationOrDefault("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	oidcProviders, err := parseOIDCProviders("OIDC_PROVIDERS")
+	if err != nil {
+		return err
+	}
+
+	storageDriver := stringOrDefault("STORAGE_DRIVER", StorageDriverS3)
+	if storageDriver != StorageDriverS3 && storageDriver != StorageDriverLocal {
+		return fmt.Errorf("config: unknown STORAGE_DRIVER %q", storageDriver)
+	}
+
 	env = EnvVar{
 		// App env
 		AppPort: os.Getenv("APP_PORT"),
 		AppEnv:  os.Getenv("APP_ENV"),
 		// token env
-		TokenSymmetricKey:    os.Getenv("TOKEN_SYMMETRIC_KEY"),
-		AccessTokenDuration:  atd,
-		RefreshTokenDuration: rtd,
+		TokenMode:              tokenMode,
+		TokenVersion:           tokenVersion,
+		TokenSymmetricKey:      os.Getenv("TOKEN_SYMMETRIC_KEY"),
+		TokenSigningKey:        os.Getenv("TOKEN_SIGNING_KEY"),
+		TokenKeyID:             stringOrDefault("TOKEN_KEY_ID", "1"),
+		TokenRetiredKeys:       retiredKeys,
+		TokenRetiredPublicKeys: retiredPublicKeys,
+		AccessTokenDuration:    atd,
+		RefreshTokenDuration:   rtd,
 		// Postgres
 		DBHost:     os.Getenv("POSTGRES_HOST"),
 		DBName:     os.Getenv("POSTGRES_DB"),
@@ -71,13 +286,17 @@ func LoadEnv() (err error) {
 		DBPassword: os.Getenv("POSTGRES_PASSWORD"),
 		DBPort:     os.Getenv("POSTGRES_PORT"),
 		//Storage keys
-		StorageAccessKey: os.Getenv("STORAGE_ACCESS_KEY"),
-		StorageSecretKey: os.Getenv("STORAGE_SECRET_KEY"),
-		StorageBucket:    os.Getenv("STORAGE_BUCKET"),
-		StorageEndpoint:  os.Getenv("STORAGE_ENDPOINT"),
-		StorageRegion:    os.Getenv("STORAGE_REGION"),
-		EncryptionKey:    os.Getenv("ENCRYPTION_KEY"),
-		MailGunApiKey:    os.Getenv("MAILGUN_API"),
+		StorageDriver:      storageDriver,
+		StorageAccessKey:   os.Getenv("STORAGE_ACCESS_KEY"),
+		StorageSecretKey:   os.Getenv("STORAGE_SECRET_KEY"),
+		StorageBucket:      os.Getenv("STORAGE_BUCKET"),
+		StorageEndpoint:    os.Getenv("STORAGE_ENDPOINT"),
+		StorageRegion:      os.Getenv("STORAGE_REGION"),
+		StorageLocalPath:   stringOrDefault("STORAGE_LOCAL_PATH", "storage"),
+		StorageLocalURL:    stringOrDefault("STORAGE_LOCAL_URL", fmt.Sprintf("http://localhost:%s/api/storage", os.Getenv("APP_PORT"))),
+		StorageLocalSecret: os.Getenv("STORAGE_LOCAL_SECRET"),
+		EncryptionKey:      os.Getenv("ENCRYPTION_KEY"),
+		MailGunApiKey:      os.Getenv("MAILGUN_API"),
 
 		EpochZero: func() int64 {
 			//parse from string to int64
@@ -87,19 +306,275 @@ func LoadEnv() (err error) {
 			}
 			return i
 		}(),
+		// presigned url limits
+		PresignMaxContentLength: presignMaxLength,
+		PresignDefaultExpiry:    presignDefault,
+		PresignMinExpiry:        presignMin,
+		PresignMaxExpiry:        presignMax,
+		// multipart uploads
+		MultipartUploadTTL:       multipartTTL,
+		MultipartJanitorInterval: multipartJanitor,
+		// pool reconciliation
+		ReconcileInterval:    reconcileInterval,
+		ReconcileConcurrency: int(reconcileConcurrency),
+		// storage quota
+		DefaultStorageCapacity: defaultCapacity,
+		// sign-in with ethereum
+		SiweDomain:   siweDomain,
+		SiweURI:      siweURI,
+		SiweChainIDs: siweChainIDs,
+		SiweNonceTTL: siweNonceTTL,
+		EthRPCURL:    os.Getenv("ETH_RPC_URL"),
+		// passkeys
+		WebAuthnRPID:        webAuthnRPID,
+		WebAuthnRPName:      stringOrDefault("WEBAUTHN_RP_NAME", "hello"),
+		WebAuthnRPOrigins:   webAuthnRPOrigins,
+		WebAuthnCeremonyTTL: webAuthnCeremonyTTL,
+		// email one-time codes
+		OtpTTL:             otpTTL,
+		OtpMaxAttempts:     int(otpMaxAttempts),
+		OtpLockoutFailures: int(otpLockoutFailures),
+		OtpLockoutBase:     otpLockoutBase,
+		OtpLockoutMax:      otpLockoutMax,
+		// two-factor authentication
+		TwoFactorChallengeTTL: twoFactorChallengeTTL,
+		// github oauth app
+		GithubClientID:     os.Getenv("GITHUB_CLIENT_ID"),
+		GithubClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+		GithubRedirectURL:  os.Getenv("GITHUB_REDIRECT_URL"),
+		// openid connect providers
+		OIDCProviders: oidcProviders,
 	}
 
 	values := reflect.ValueOf(env)
 	types := values.Type()
 	for i := 0; i < values.NumField(); i++ {
+		// settings of other storage drivers are not needed
+		if driver, ok := types.Field(i).Tag.Lookup("driver"); ok && driver != env.StorageDriver {
+			continue
+		}
+
+		// keys of the other token mode are optional
+		if mode, ok := types.Field(i).Tag.Lookup("token"); ok && mode != env.TokenMode {
+			continue
+		}
+
+		if _, ok := types.Field(i).Tag.Lookup("optional"); ok {
+			continue
+		}
+
 		if values.Field(i).String() == "" {
 			return fmt.Errorf("config: %s is missing", types.Field(i).Name)
 		}
 	}
 
+	if (env.GithubClientID == "") != (env.GithubClientSecret == "") {
+		return fmt.Errorf("config: GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET must be set together")
+	}
+
+	if env.GithubRedirectURL != "" && env.GithubClientID == "" {
+		return fmt.Errorf("config: GITHUB_REDIRECT_URL is set without GITHUB_CLIENT_ID")
+	}
+
 	return
 }
 
+// stringOrDefault returns the environment variable key, or fallback if it is not set.
+func stringOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+// durationOrDefault parses the duration in the environment variable key,
+// returning fallback if it is not set. Durations must be positive, since they
+// are lifetimes and intervals.
+func durationOrDefault(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("config: invalid %s: %w", key, err)
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("config: %s must be a positive duration, got %s", key, v)
+	}
+
+	return d, nil
+}
+
+// int64OrDefault parses the integer in the environment variable key,
+// returning fallback if it is not set.
+func int64OrDefault(key string, fallback int64) (int64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("config: invalid %s: %w", key, err)
+	}
+
+	return i, nil
+}
+
+// int64sOrDefault parses the comma separated integers in the environment
+// variable key, returning fallback if it is not set.
+func int64sOrDefault(key string, fallback []int64) ([]int64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+
+	var ints []int64
+	for _, s := range strings.Split(v, ",") {
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("config: invalid %s: %w", key, err)
+		}
+		ints = append(ints, i)
+	}
+
+	return ints, nil
+}
+
+// stringsOrDefault returns the comma separated values in the environment
+// variable key, or fallback if it is not set.
+func stringsOrDefault(key string, fallback []string) []string {
+	var values []string
+	for _, s := range strings.Split(os.Getenv(key), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+
+	if len(values) == 0 {
+		return fallback
+	}
+
+	return values
+}
+
+// siweOrigin returns the domain and uri Sign-In with Ethereum messages have to
+// match. SIWE_URI defaults to https://SIWE_DOMAIN, and SIWE_DOMAIN to the host
+// of SIWE_URI; if neither is set, both are empty and sign-in with ethereum is
+// disabled.
+func siweOrigin() (domain, uri string, err error) {
+	domain, uri = os.Getenv("SIWE_DOMAIN"), os.Getenv("SIWE_URI")
+
+	if uri == "" && domain != "" {
+		uri = "https://" + domain
+	}
+
+	if domain == "" && uri != "" {
+		u, err := url.Parse(uri)
+		if err != nil || u.Host == "" {
+			return "", "", fmt.Errorf("config: invalid SIWE_URI %q", uri)
+		}
+		domain = u.Host
+	}
+
+	return domain, uri, nil
+}
+
+// parseKeys parses the comma separated id:key pairs in the environment
+// variable key.
+func parseKeys(key string) (map[string]string, error) {
+	keys := make(map[string]string)
+
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, k, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || k == "" {
+			return nil, fmt.Errorf("config: invalid %s: expected id:key pairs", key)
+		}
+
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("config: invalid %s: duplicate key id %q", key, id)
+		}
+
+		keys[id] = k
+	}
+
+	return keys, nil
+}
+
+// oidcProviderName matches the names of OpenID Connect providers, which are
+// part of their routes.
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// parseOIDCProviders parses the OpenID Connect providers named in the comma
+// separated environment variable key. Each provider is configured with the
+// variables OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET (optional for
+// public clients), _REDIRECT_URL, _SCOPES (optional, comma separated) and
+// _TRUST_EMAIL (optional), where NAME is its name in upper case with dashes
+// replaced by underscores.
+func parseOIDCProviders(key string) ([]oauth.OIDCConfig, error) {
+	var providers []oauth.OIDCConfig
+	names := make(map[string]bool)
+
+	for _, name := range stringsOrDefault(key, nil) {
+		if !oidcProviderName.MatchString(name) {
+			return nil, fmt.Errorf("config: invalid %s: provider name %q must be lower case letters, digits and dashes", key, name)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("config: invalid %s: duplicate provider %q", key, name)
+		}
+		names[name] = true
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		p := oauth.OIDCConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       stringsOrDefault(prefix+"SCOPES", nil),
+		}
+
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			return nil, fmt.Errorf("config: %sISSUER, %sCLIENT_ID and %sREDIRECT_URL must be set", prefix, prefix, prefix)
+		}
+
+		for _, k := range []string{"ISSUER", "REDIRECT_URL"} {
+			if u, err := url.Parse(os.Getenv(prefix + k)); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return nil, fmt.Errorf("config: invalid %s%s: expected an http(s) url", prefix, k)
+			}
+		}
+
+		if v := os.Getenv(prefix + "TRUST_EMAIL"); v != "" {
+			trust, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("config: invalid %sTRUST_EMAIL: %w", prefix, err)
+			}
+			p.TrustEmail = trust
+		}
+
+		providers = append(providers, p)
+	}
+
+	return providers, nil
+}
+
 func Env() EnvVar {
 	return env
 }
+
+// SetEnv replaces the settings loaded from the environment, e.g. with the
+// settings a test needs.
+func SetEnv(e EnvVar) {
+	env = e
+}
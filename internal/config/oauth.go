@@ -0,0 +1,45 @@
+package config
+
+import (
+	"sync"
+
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/oauth"
+)
+
+var (
+	githubOAuth     *oauth.GithubClient
+	githubOAuthOnce sync.Once
+)
+
+// GithubOAuth returns the client of the GitHub OAuth app, or nil if
+// GITHUB_CLIENT_ID is not set.
+func GithubOAuth() *oauth.GithubClient {
+	githubOAuthOnce.Do(func() {
+		if Env().GithubClientID == "" {
+			log.Warnf("config: GITHUB_CLIENT_ID is not set, github login is disabled")
+			return
+		}
+
+		githubOAuth = oauth.NewGithubClient(Env().GithubClientID, Env().GithubClientSecret, Env().GithubRedirectURL)
+	})
+
+	return githubOAuth
+}
+
+var (
+	oidcProviders     map[string]*oauth.OIDCProvider
+	oidcProvidersOnce sync.Once
+)
+
+// OIDCProviders returns the OpenID Connect providers in OIDC_PROVIDERS by
+// name. Their discovery documents are fetched on first use.
+func OIDCProviders() map[string]*oauth.OIDCProvider {
+	oidcProvidersOnce.Do(func() {
+		oidcProviders = make(map[string]*oauth.OIDCProvider)
+		for _, c := range Env().OIDCProviders {
+			oidcProviders[c.Name] = oauth.NewOIDCProvider(c)
+		}
+	})
+
+	return oidcProviders
+}
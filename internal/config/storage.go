@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+)
+
+const (
+	StorageDriverS3    = "s3"
+	StorageDriverLocal = "local"
+)
+
+var (
+	storageBackend storage.Backend
+	storageErr     error
+	storageOnce    sync.Once
+)
+
+// Storage returns the storage backend selected by STORAGE_DRIVER.
+func Storage() (storage.Backend, error) {
+	storageOnce.Do(func() {
+		storageBackend, storageErr = newStorage()
+	})
+
+	return storageBackend, storageErr
+}
+
+func newStorage() (storage.Backend, error) {
+	switch Env().StorageDriver {
+	case StorageDriverS3:
+		return storage.NewS3(context.Background(), storage.S3Options{
+			Endpoint:  Env().StorageEndpoint,
+			Region:    Env().StorageRegion,
+			AccessKey: Env().StorageAccessKey,
+			SecretKey: Env().StorageSecretKey,
+			Bucket:    Env().StorageBucket,
+		})
+	case StorageDriverLocal:
+		log.Warnf("config: storing files in %s, which is meant for development only", Env().StorageLocalPath)
+		return storage.NewLocal(Env().StorageLocalPath, Env().StorageLocalURL, localStorageSecret())
+	default:
+		return nil, fmt.Errorf("config: unknown storage driver %q", Env().StorageDriver)
+	}
+}
+
+// localStorageSecret derives the key presigned requests of the local driver
+// are signed with from STORAGE_LOCAL_SECRET, which is not used for anything
+// else, so that rotating token keys does not invalidate presigned requests.
+func localStorageSecret() []byte {
+	mac := hmac.New(sha256.New, []byte(Env().StorageLocalSecret))
+	mac.Write([]byte("storage/local"))
+	return mac.Sum(nil)
+}
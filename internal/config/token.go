@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+)
+
+const (
+	TokenModeLocal  = "local"
+	TokenModePublic = "public"
+)
+
+// TokenMaker returns the token maker selected by TOKEN_MODE: local tokens
+// encrypted with a symmetric key, or public tokens signed with an Ed25519 key
+// that other services can verify. In public mode, local tokens issued before
+// are still accepted if TOKEN_SYMMETRIC_KEY is set.
+func TokenMaker() (token.Maker, error) {
+	var keyring *token.Keyring
+	if Env().TokenSymmetricKey != "" {
+		var err error
+		keyring, err = token.NewKeyring(Env().TokenKeyID, Env().TokenSymmetricKey, Env().TokenRetiredKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch Env().TokenMode {
+	case TokenModeLocal:
+		return token.NewPasetoMaker(keyring)
+	case TokenModePublic:
+		keys, err := token.NewSigningKeys(Env().TokenKeyID, Env().TokenSigningKey, Env().TokenRetiredPublicKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		return token.NewPublicMaker(Env().TokenVersion, keys, keyring)
+	default:
+		return nil, fmt.Errorf("config: unknown token mode %q", Env().TokenMode)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/web3"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	signatureVerifier     web3.SignatureVerifier
+	signatureVerifierErr  error
+	signatureVerifierOnce sync.Once
+)
+
+// SignatureVerifier returns the verifier of wallet signatures. Signatures of
+// contract wallets are verified through ETH_RPC_URL if it is set, for messages
+// of the chain of that endpoint.
+func SignatureVerifier() (web3.SignatureVerifier, error) {
+	signatureVerifierOnce.Do(func() {
+		signatureVerifier, signatureVerifierErr = newSignatureVerifier()
+	})
+
+	return signatureVerifier, signatureVerifierErr
+}
+
+func newSignatureVerifier() (web3.SignatureVerifier, error) {
+	if Env().EthRPCURL == "" {
+		log.Warnf("config: ETH_RPC_URL is not set, contract wallets cannot sign in")
+		return web3.NewVerifier(nil, 0), nil
+	}
+
+	client, err := ethclient.DialContext(context.Background(), Env().EthRPCURL)
+	if err != nil {
+		return nil, err
+	}
+
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(Env().SiweChainIDs, chainID.Int64()) {
+		log.Warnf("config: ETH_RPC_URL is on chain %d, which is not in SIWE_CHAIN_IDS, contract wallets cannot sign in", chainID)
+	}
+
+	return web3.NewVerifier(client, chainID.Int64()), nil
+}
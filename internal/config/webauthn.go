@@ -0,0 +1,38 @@
+package config
+
+import (
+	"sync"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var (
+	webAuthn     *webauthn.WebAuthn
+	webAuthnErr  error
+	webAuthnOnce sync.Once
+)
+
+// WebAuthn returns the relying party of passkey ceremonies.
+func WebAuthn() (*webauthn.WebAuthn, error) {
+	webAuthnOnce.Do(func() {
+		webAuthn, webAuthnErr = webauthn.New(&webauthn.Config{
+			RPID:          Env().WebAuthnRPID,
+			RPDisplayName: Env().WebAuthnRPName,
+			RPOrigins:     Env().WebAuthnRPOrigins,
+			Timeouts: webauthn.TimeoutsConfig{
+				Login: webauthn.TimeoutConfig{
+					Enforce:    true,
+					Timeout:    Env().WebAuthnCeremonyTTL,
+					TimeoutUVD: Env().WebAuthnCeremonyTTL,
+				},
+				Registration: webauthn.TimeoutConfig{
+					Enforce:    true,
+					Timeout:    Env().WebAuthnCeremonyTTL,
+					TimeoutUVD: Env().WebAuthnCeremonyTTL,
+				},
+			},
+		})
+	})
+
+	return webAuthn, webAuthnErr
+}
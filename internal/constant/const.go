@@ -1,18 +1,13 @@
 package constant
 
-import (
-	"fmt"
-)
-
 const (
 	AuthorizationHeaderKey  = "authorization"
 	AuthorizationTypeBearer = "bearer"
 	AuthorizationPayloadKey = "authorization_payload"
 	APIKeyHeaderKey         = "api-key"
+	APIKeyContextKey        = "api_key"
+	DeviceHeaderKey         = "x-device-name"
 )
 
-const LoginMessage = "Greetings from hello\nSign this message to log into hello\nnonce: "
-
-func BuildLoginMessage(nonce string) []byte {
-	return []byte(fmt.Sprintf("%s%s", LoginMessage, nonce))
-}
+// LoginStatement is the statement of the Sign-In with Ethereum messages.
+const LoginStatement = "Sign in to hello"
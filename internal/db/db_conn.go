@@ -70,6 +70,11 @@ func SetDbProvider(conn *DbConn) {
 	dbConn = conn
 }
 
+// SetDb sets an open connection as the database, e.g. a test database.
+func SetDb(conn *gorm.DB) {
+	dbConn = &DbConn{db: conn}
+}
+
 // HasDbProvider returns true if a db provider exists.
 func HasDbProvider() bool {
 	return dbConn.db != nil
@@ -1,14 +1,19 @@
 package entity
 
 import (
+	"time"
+
 	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"gorm.io/gorm"
 )
 
+// ApiKeyFile records a file uploaded with an API key.
 type ApiKeyFile struct {
-	ID     uint `gorm:"primarykey"           json:"id"`
-	FileID uint `gorm:"index;column:file_id" json:"file_id"`
-	UserID uint `gorm:"index;column:user_id" json:"user_id"`
+	ID        uint      `gorm:"primarykey"              json:"id"`
+	FileID    uint      `gorm:"index;column:file_id"    json:"file_id"`
+	UserID    uint      `gorm:"index;column:user_id"    json:"user_id"`
+	ApiKeyID  *uint     `gorm:"index;column:api_key_id" json:"api_key_id"` // nil for files recorded before keys were tracked
+	CreatedAt time.Time `                               json:"created_at"`
 }
 
 // TableName returns the entity table name.
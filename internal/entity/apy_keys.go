@@ -1,17 +1,41 @@
 package entity
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
 	"time"
 
 	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"gorm.io/gorm"
 )
 
+// ApiKeyPrefixLength is the number of characters of a key kept for display,
+// after the version and purpose header all keys share.
+const ApiKeyPrefixLength = 16
+
+// legacyApiKeysBatch is the number of legacy keys hashed per query.
+const legacyApiKeysBatch = 500
+
+// ApiKeys represents an API key result set.
+type ApiKeys []ApiKey
+
+// ApiKey is an API key of a user. Only the hash of the key is stored, the key
+// itself is shown once when it is created.
 type ApiKey struct {
-	ID          uint      `gorm:"primarykey"       json:"id"`
-	UserID      uint      `gorm:"index;column:user_id" json:"user_id"`
-	ApiKey      string    ` json:"api_key"`
-	KeyRequests int       `json:"key_requests"`
-	CreatedAt   time.Time `gorm:"index"                               json:"created_at"`
+	ID          uint          `gorm:"primarykey"                          json:"id"`
+	UserID      uint          `gorm:"index;column:user_id"                json:"user_id"`
+	Name        string        `gorm:"type:varchar(64)"                    json:"name"`
+	ApiKey      string        `                                           json:"-"` // legacy keys stored in plain text, cleared by HashLegacyApiKeys
+	KeyHash     *string       `gorm:"type:varchar(64);uniqueIndex"        json:"-"`
+	Prefix      string        `gorm:"type:varchar(16)"                    json:"prefix"`
+	Scopes      []token.Scope `gorm:"type:text;serializer:json"           json:"scopes"` // nil for legacy keys with all scopes
+	KeyRequests int           `                                           json:"key_requests"`
+	CreatedAt   time.Time     `gorm:"index"                               json:"created_at"`
+	LastUsedAt  *time.Time    `                                           json:"last_used_at"`
+	ExpiresAt   *time.Time    `                                           json:"expires_at"`
+	RevokedAt   *time.Time    `                                           json:"revoked_at"`
 }
 
 // TableName returns the entity table name.
@@ -19,15 +43,90 @@ func (ApiKey) TableName() string {
 	return "api_keys"
 }
 
+// HashApiKey returns the hash an API key is stored as.
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetKey stores the hash and display prefix of the key.
+func (m *ApiKey) SetKey(key string) {
+	hash := HashApiKey(key)
+	m.KeyHash = &hash
+	m.Prefix = apiKeyPrefix(key)
+	m.ApiKey = ""
+}
+
+// apiKeyPrefix returns the characters of a key kept for display.
+func apiKeyPrefix(key string) string {
+	// skip the header, such as v2.local.
+	if parts := strings.SplitN(key, ".", 3); len(parts) == 3 {
+		key = parts[2]
+	}
+
+	return key[:min(len(key), ApiKeyPrefixLength)]
+}
+
+// HashLegacyApiKeys replaces the keys stored in plain text by their hash. It
+// is run by InitDb.
+func HashLegacyApiKeys(conn *gorm.DB) error {
+	for {
+		var keys ApiKeys
+		if err := conn.Where("key_hash IS NULL AND api_key <> ''").Limit(legacyApiKeysBatch).Find(&keys).Error; err != nil {
+			return err
+		}
+
+		for i := range keys {
+			keys[i].SetKey(keys[i].ApiKey)
+			if err := conn.Model(&keys[i]).Select("key_hash", "prefix", "api_key").Updates(&keys[i]).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < legacyApiKeysBatch {
+			return nil
+		}
+	}
+}
+
 func (m *ApiKey) Create() error {
 	return db.Db().Create(m).Error
 }
 
+func (m *ApiKey) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
+
 func (m *ApiKey) Save() error {
 	return db.Db().Save(m).Error
 }
 
+// IncrementKeyRequests counts a request authorized by the key.
 func (m *ApiKey) IncrementKeyRequests() error {
+	now := time.Now()
 	m.KeyRequests++
-	return m.Save()
+	m.LastUsedAt = &now
+
+	return db.Db().Model(m).UpdateColumns(map[string]interface{}{
+		"key_requests": gorm.Expr("key_requests + 1"),
+		"last_used_at": now,
+	}).Error
+}
+
+// TxRevoke revokes the key, which cannot be used anymore.
+func (m *ApiKey) TxRevoke(tx *gorm.DB) error {
+	now := time.Now()
+	m.RevokedAt = &now
+
+	return tx.Model(m).UpdateColumn("revoked_at", now).Error
+}
+
+// IsRevoked reports whether the key was revoked.
+func (m *ApiKey) IsRevoked() bool {
+	return m.RevokedAt != nil
+}
+
+// IsExpired reports whether the key has expired.
+func (m *ApiKey) IsExpired() bool {
+	return m.ExpiresAt != nil && time.Now().After(*m.ExpiresAt)
 }
@@ -0,0 +1,49 @@
+package entity_test
+
+import (
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKeySetKey(t *testing.T) {
+	m := &entity.ApiKey{ApiKey: "v2.local.abcdefghijklmnopqrstuvwxyz"}
+	m.SetKey("v2.local.abcdefghijklmnopqrstuvwxyz")
+
+	require.Equal(t, "abcdefghijklmnop", m.Prefix)
+	require.Equal(t, entity.HashApiKey("v2.local.abcdefghijklmnopqrstuvwxyz"), *m.KeyHash)
+	require.Empty(t, m.ApiKey)
+
+	m.SetKey("short")
+	require.Equal(t, "short", m.Prefix)
+}
+
+func TestHashLegacyApiKeys(t *testing.T) {
+	conn := testdb.Open(t)
+
+	legacy := &entity.ApiKey{UserID: 1, ApiKey: "v2.local.legacy-key-in-plain-text"}
+	require.NoError(t, legacy.Create())
+
+	hashed := &entity.ApiKey{UserID: 1}
+	hashed.SetKey("v2.local.hashed-key")
+	require.NoError(t, hashed.Create())
+
+	require.NoError(t, entity.HashLegacyApiKeys(conn))
+
+	m, err := query.FindApiKey("v2.local.legacy-key-in-plain-text")
+	require.NoError(t, err)
+	require.Equal(t, legacy.ID, m.ID)
+	require.Empty(t, m.ApiKey)
+	require.Equal(t, "legacy-key-in-pl", m.Prefix)
+
+	m, err = query.FindApiKey("v2.local.hashed-key")
+	require.NoError(t, err)
+	require.Equal(t, hashed.ID, m.ID)
+
+	var plain int64
+	require.NoError(t, conn.Model(&entity.ApiKey{}).Where("api_key <> ''").Count(&plain).Error)
+	require.Zero(t, plain)
+}
@@ -0,0 +1,30 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Blob is a stored object, shared by all files with the same CID. RefCount is
+// the number of files referencing it, and the object is deleted from storage
+// once it drops to zero. Verified blobs were streamed through the proxy, which
+// checked that their content hashes to the CID, and are never overwritten.
+type Blob struct {
+	ID        uint      `gorm:"primarykey"                       json:"id"`
+	CID       string    `gorm:"type:varchar(64);uniqueIndex"     json:"cid"` // object key
+	Size      int64     `                                        json:"size"`
+	RefCount  int64     `gorm:"not null;default:0"               json:"ref_count"`
+	Verified  bool      `gorm:"not null;default:false"           json:"verified"`
+	CreatedAt time.Time `                                        json:"created_at"`
+	UpdatedAt time.Time `                                        json:"updated_at"`
+}
+
+// TableName returns the entity table name.
+func (Blob) TableName() string {
+	return "blobs"
+}
+
+func (m *Blob) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
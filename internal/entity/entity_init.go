@@ -7,6 +7,12 @@ import (
 	"github.com/Hello-Storage/hello-storage-proxy/internal/migrate"
 )
 
+const addOIDCAccountType = `DO $$ BEGIN
+	IF EXISTS (SELECT 1 FROM pg_type WHERE typname = 'account_type') THEN
+		ALTER TYPE account_type ADD VALUE IF NOT EXISTS 'oidc';
+	END IF;
+END $$`
+
 func InitDb(opt migrate.Options) {
 	if !db.HasDbProvider() {
 		log.Error("migrate: no database provider")
@@ -15,8 +21,18 @@ func InitDb(opt migrate.Options) {
 
 	start := time.Now()
 
+	// the account types are an enum created with the wallets table, which
+	// is not migrated here
+	if err := db.Db().Exec(addOIDCAccountType).Error; err != nil {
+		log.Errorf("migrate: failed to add account type %s: %v", OIDC, err)
+	}
+
 	Entities.Migrate(db.Db(), opt)
 
+	if err := HashLegacyApiKeys(db.Db()); err != nil {
+		log.Errorf("migrate: failed to hash legacy api keys: %v", err)
+	}
+
 	log.Debugf("migrate: completed in %s", time.Since(start))
 }
 
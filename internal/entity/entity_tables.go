@@ -14,7 +14,28 @@ type Tables map[string]interface{}
 
 // Entities contains database entities and their table names.
 var Entities = Tables{
-	Miner{}.TableName(): &Miner{},
+	Miner{}.TableName():               &Miner{},
+	ApiKey{}.TableName():              &ApiKey{},
+	ApiKeyFile{}.TableName():          &ApiKeyFile{},
+	LoginNonce{}.TableName():          &LoginNonce{},
+	Blob{}.TableName():                &Blob{},
+	Plan{}.TableName():                &Plan{},
+	PresignedURLLog{}.TableName():     &PresignedURLLog{},
+	MultipartUpload{}.TableName():     &MultipartUpload{},
+	MultipartUploadPart{}.TableName(): &MultipartUploadPart{},
+	Reconciliation{}.TableName():      &Reconciliation{},
+	ReconciliationIssue{}.TableName(): &ReconciliationIssue{},
+	Passkey{}.TableName():             &Passkey{},
+	PasskeyCeremony{}.TableName():     &PasskeyCeremony{},
+	OtpChallenge{}.TableName():        &OtpChallenge{},
+	OtpLockout{}.TableName():          &OtpLockout{},
+	TwoFactor{}.TableName():           &TwoFactor{},
+	TwoFactorChallenge{}.TableName():  &TwoFactorChallenge{},
+	RecoveryCode{}.TableName():        &RecoveryCode{},
+	OIDCIdentity{}.TableName():        &OIDCIdentity{},
+	OIDCAuthRequest{}.TableName():     &OIDCAuthRequest{},
+	Session{}.TableName():             &Session{},
+	UsageEntry{}.TableName():          &UsageEntry{},
 }
 
 // Truncate removes all data from tables without dropping them.
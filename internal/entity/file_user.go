@@ -39,3 +39,19 @@ func (m *FileUser) TxCreate(tx *gorm.DB) error {
 func (m *FileUser) Update() error {
 	return db.Db().Model(m).Updates(m).Error
 }
+
+// TxSwapPermission changes the permission of the relation if it still is from,
+// and reports whether it did.
+func (m *FileUser) TxSwapPermission(tx *gorm.DB, from, to permission) (bool, error) {
+	res := tx.Model(m).Where("permission = ?", from).Update("permission", to)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	if res.RowsAffected != 1 {
+		return false, nil
+	}
+
+	m.Permission = to
+	return true, nil
+}
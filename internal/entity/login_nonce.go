@@ -0,0 +1,32 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"gorm.io/gorm"
+)
+
+// LoginNonce is a nonce issued for a Sign-In with Ethereum message. It can be
+// used once, before it expires.
+type LoginNonce struct {
+	ID        uint       `gorm:"primarykey"                     json:"-"`
+	Nonce     string     `gorm:"type:varchar(32);uniqueIndex"   json:"nonce"`
+	Address   string     `gorm:"type:varchar(50);index"         json:"address"` // lower case
+	ExpiresAt time.Time  `gorm:"index"                          json:"expires_at"`
+	UsedAt    *time.Time `                                      json:"used_at"`
+	CreatedAt time.Time  `                                      json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (LoginNonce) TableName() string {
+	return "login_nonces"
+}
+
+func (m *LoginNonce) Create() error {
+	return db.Db().Create(m).Error
+}
+
+func (m *LoginNonce) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
@@ -0,0 +1,128 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MultipartUploadUID = byte('m')
+)
+
+type MultipartUploadStatus string
+
+const (
+	MultipartInProgress MultipartUploadStatus = "in_progress"
+	MultipartCompleting MultipartUploadStatus = "completing" // claimed by a request completing it
+	MultipartCompleted  MultipartUploadStatus = "completed"
+	MultipartAborted    MultipartUploadStatus = "aborted"
+)
+
+// MultipartUploads represents a multipart upload result set.
+type MultipartUploads []MultipartUpload
+
+// MultipartUpload tracks a multipart upload orchestrated by the proxy, so that
+// clients can resume it and abandoned uploads can be cleaned up. The parts are
+// assembled under ObjectKey, a staging key, and the content is stored under
+// CID once it is verified.
+type MultipartUpload struct {
+	ID                   uint                  `gorm:"primarykey"                          json:"-"`
+	UID                  string                `gorm:"type:varchar(42);uniqueIndex;"       json:"uid"`
+	UploadID             string                `gorm:"type:varchar(1024);not null"         json:"-"`
+	UserID               uint                  `gorm:"index;column:user_id"                json:"user_id"`
+	ObjectKey            string                `gorm:"type:varchar(256);not null"          json:"object_key"`
+	CID                  string                `gorm:"type:varchar(64)"                    json:"cid"`
+	Name                 string                `gorm:"type:varchar(1024);"                 json:"name"`
+	Root                 string                `gorm:"type:varchar(1024);default:'/';"     json:"root"`
+	Path                 string                `gorm:"type:varchar(1024);"                 json:"path"`
+	Mime                 string                `gorm:"type:varchar(256)"                   json:"mime_type"`
+	Size                 int64                 `                                           json:"size"`
+	EncryptionStatus     EncryptionStatus      `gorm:"type:varchar(16)"                    json:"encryption_status"`
+	CIDOriginalEncrypted *string               `gorm:"type:varchar(256)"                   json:"cid_original_encrypted"`
+	Status               MultipartUploadStatus `gorm:"type:varchar(16);index;not null"     json:"status"`
+	FileID               *uint                 `                                           json:"file_id"`
+	Parts                []MultipartUploadPart `gorm:"constraint:OnDelete:CASCADE;"        json:"parts"`
+	CreatedAt            time.Time             `                                           json:"created_at"`
+	UpdatedAt            time.Time             `gorm:"index"                               json:"updated_at"`
+}
+
+// TableName returns the entity table name.
+func (MultipartUpload) TableName() string {
+	return "multipart_uploads"
+}
+
+// BeforeCreate creates a random UID if needed before inserting a new row to the database.
+func (m *MultipartUpload) BeforeCreate(db *gorm.DB) error {
+	if rnd.IsUnique(m.UID, MultipartUploadUID) {
+		return nil
+	}
+
+	m.UID = rnd.GenerateUID(MultipartUploadUID)
+	db.Statement.SetColumn("UID", m.UID)
+
+	return nil
+}
+
+func (m *MultipartUpload) Create() error {
+	return db.Db().Create(m).Error
+}
+
+func (m *MultipartUpload) Save() error {
+	return db.Db().Save(m).Error
+}
+
+// Touch marks the upload as active, which postpones its cleanup.
+func (m *MultipartUpload) Touch() error {
+	return db.Db().Model(m).Update("updated_at", time.Now()).Error
+}
+
+// SwapStatus changes the upload status to status if it still is from, and
+// reports whether it did. Only the request that swapped the status may go on,
+// so that an upload is not completed or aborted twice.
+func (m *MultipartUpload) SwapStatus(from, status MultipartUploadStatus) (bool, error) {
+	return m.TxSwapStatus(db.Db(), from, status)
+}
+
+// TxSwapStatus is SwapStatus as part of the transaction tx. It also saves the
+// file the upload created, if any.
+func (m *MultipartUpload) TxSwapStatus(tx *gorm.DB, from, status MultipartUploadStatus) (bool, error) {
+	res := tx.Model(m).Where("status = ?", from).Updates(map[string]interface{}{"status": status, "file_id": m.FileID})
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	if res.RowsAffected != 1 {
+		return false, nil
+	}
+
+	m.Status = status
+	return true, nil
+}
+
+// MultipartUploadPart is a part the bucket has received for a multipart upload.
+type MultipartUploadPart struct {
+	ID                uint      `gorm:"primarykey"                                 json:"-"`
+	MultipartUploadID uint      `gorm:"uniqueIndex:idx_multipart_upload_part"      json:"-"`
+	PartNumber        int32     `gorm:"uniqueIndex:idx_multipart_upload_part"      json:"part_number"`
+	ETag              string    `gorm:"type:varchar(128)"                          json:"etag"`
+	Size              int64     `                                                  json:"size"`
+	CreatedAt         time.Time `                                                  json:"created_at"`
+	UpdatedAt         time.Time `                                                  json:"updated_at"`
+}
+
+// TableName returns the entity table name.
+func (MultipartUploadPart) TableName() string {
+	return "multipart_upload_parts"
+}
+
+// Upsert creates the part or updates it if it was uploaded again.
+func (m *MultipartUploadPart) Upsert() error {
+	return db.Db().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "multipart_upload_id"}, {Name: "part_number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"e_tag", "size", "updated_at"}),
+	}).Create(m).Error
+}
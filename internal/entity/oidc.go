@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"gorm.io/gorm"
+)
+
+// OIDCIdentity links a user to the subject of an OpenID Connect provider,
+// which identifies the user at that provider for good, unlike their email.
+type OIDCIdentity struct {
+	ID          uint       `gorm:"primarykey"                                   json:"id"`
+	UserID      uint       `gorm:"index;column:user_id"                         json:"user_id"`
+	Provider    string     `gorm:"type:varchar(64);uniqueIndex:idx_oidc_identity" json:"provider"`
+	Subject     string     `gorm:"type:varchar(255);uniqueIndex:idx_oidc_identity" json:"-"`
+	Email       string     `gorm:"type:varchar(255)"                            json:"email"`
+	CreatedAt   time.Time  `                                                    json:"created_at"`
+	LastLoginAt *time.Time `                                                    json:"last_login_at"`
+}
+
+// TableName returns the entity table name.
+func (OIDCIdentity) TableName() string {
+	return "oidc_identities"
+}
+
+func (m *OIDCIdentity) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
+
+// TxLogin records a login with the identity, and the email the provider
+// returned with it.
+func (m *OIDCIdentity) TxLogin(tx *gorm.DB, email string) error {
+	now := time.Now()
+
+	m.Email = email
+	m.LastLoginAt = &now
+
+	return tx.Model(m).Updates(map[string]interface{}{
+		"email":         m.Email,
+		"last_login_at": m.LastLoginAt,
+	}).Error
+}
+
+// OIDCAuthRequest is the state of a login at an OpenID Connect provider
+// between the user being redirected to it and returning with a code. It can
+// be finished once, before it expires, by the client that started it.
+type OIDCAuthRequest struct {
+	ID           uint      `gorm:"primarykey"                   json:"-"`
+	State        string    `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	Provider     string    `gorm:"type:varchar(64)"             json:"-"`
+	Nonce        string    `gorm:"type:varchar(64)"             json:"-"`
+	CodeVerifier string    `gorm:"type:varchar(128)"            json:"-"`
+	BindingHash  string    `gorm:"type:varchar(64)"             json:"-"` // of the secret kept by the client
+	ExpiresAt    time.Time `gorm:"index"                        json:"-"`
+	CreatedAt    time.Time `                                    json:"-"`
+}
+
+// TableName returns the entity table name.
+func (OIDCAuthRequest) TableName() string {
+	return "oidc_auth_requests"
+}
+
+func (m *OIDCAuthRequest) Create() error {
+	return db.Db().Create(m).Error
+}
+
+// HashOIDCBinding returns the hash the secret binding a login to the client
+// that started it is stored as.
+func HashOIDCBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,100 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"gorm.io/gorm"
+)
+
+// OtpChallenge is a one-time code emailed to sign in. Only the hash of the
+// code is stored. A challenge is consumed when its code is accepted, when
+// it runs out of attempts, or when a new one is started for the email.
+type OtpChallenge struct {
+	ID          uint       `gorm:"primarykey"                 json:"-"`
+	Email       string     `gorm:"type:varchar(255);index"    json:"email"` // lower case
+	CodeHash    string     `gorm:"type:varchar(64)"           json:"-"`
+	IP          string     `gorm:"type:varchar(64)"           json:"ip"`
+	Attempts    int        `gorm:"not null;default:0"         json:"attempts"`
+	MaxAttempts int        `gorm:"not null"                   json:"max_attempts"`
+	ConsumedAt  *time.Time `                                  json:"consumed_at"`
+	ExpiresAt   time.Time  `gorm:"index"                      json:"expires_at"`
+	CreatedAt   time.Time  `                                  json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (OtpChallenge) TableName() string {
+	return "otp_challenges"
+}
+
+// NewOtpChallenge returns a challenge for the code sent to email.
+func NewOtpChallenge(email, code, ip string, maxAttempts int, ttl time.Duration) *OtpChallenge {
+	m := &OtpChallenge{
+		Email:       strings.ToLower(email),
+		IP:          ip,
+		MaxAttempts: maxAttempts,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	m.CodeHash = m.hashCode(code)
+
+	return m
+}
+
+// hashCode returns the hash a code is stored as.
+func (m *OtpChallenge) hashCode(code string) string {
+	sum := sha256.Sum256([]byte(m.Email + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsConsumed reports whether the challenge can no longer be answered.
+func (m *OtpChallenge) IsConsumed() bool {
+	return m.ConsumedAt != nil || m.Attempts >= m.MaxAttempts || !time.Now().Before(m.ExpiresAt)
+}
+
+// Attempt counts an attempt to answer the challenge with code and reports
+// whether the code is right. The challenge is consumed by the right code and
+// by the last attempt it allows.
+func (m *OtpChallenge) Attempt(code string) bool {
+	if m.IsConsumed() {
+		return false
+	}
+
+	m.Attempts++
+	ok := subtle.ConstantTimeCompare([]byte(m.hashCode(code)), []byte(m.CodeHash)) == 1
+
+	if ok || m.Attempts >= m.MaxAttempts {
+		now := time.Now()
+		m.ConsumedAt = &now
+	}
+
+	return ok
+}
+
+// AttemptsLeft returns the number of attempts the challenge still allows.
+func (m *OtpChallenge) AttemptsLeft() int {
+	if m.ConsumedAt != nil {
+		return 0
+	}
+
+	return max(m.MaxAttempts-m.Attempts, 0)
+}
+
+func (m *OtpChallenge) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
+
+// TxSave saves the attempts of the challenge.
+func (m *OtpChallenge) TxSave(tx *gorm.DB) error {
+	return tx.Model(m).Updates(map[string]interface{}{
+		"attempts":    m.Attempts,
+		"consumed_at": m.ConsumedAt,
+	}).Error
+}
+
+func (m *OtpChallenge) Create() error {
+	return db.Db().Create(m).Error
+}
@@ -0,0 +1,93 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of keys OTP sign-ins are locked out by.
+const (
+	OtpLockoutEmail = "email"
+	OtpLockoutIP    = "ip"
+)
+
+// OtpLockoutPolicy configures when OTP sign-ins are locked out.
+type OtpLockoutPolicy struct {
+	Failures int           // failed attempts before a lockout
+	Base     time.Duration // the first lockout, each next one is twice as long
+	Max      time.Duration // the longest lockout; failures are forgotten after it
+}
+
+// OtpLockout counts the failed OTP attempts of an email or an IP address.
+// After Failures failed attempts the key is locked out, for a duration that
+// doubles with each lockout up to Max. Keys that did not fail for Max after
+// their last lockout are forgiven, and a successful sign-in forgives the email.
+type OtpLockout struct {
+	ID          uint       `gorm:"primarykey"                                       json:"-"`
+	Kind        string     `gorm:"type:varchar(8);uniqueIndex:idx_otp_lockout"      json:"kind"`
+	Key         string     `gorm:"type:varchar(255);uniqueIndex:idx_otp_lockout"    json:"key"`
+	Failures    int        `gorm:"not null;default:0"                               json:"failures"` // since the last lockout
+	Level       int        `gorm:"not null;default:0"                               json:"level"`    // number of lockouts
+	FailedAt    *time.Time `                                                        json:"failed_at"`
+	LockedUntil *time.Time `                                                        json:"locked_until"`
+}
+
+// TableName returns the entity table name.
+func (OtpLockout) TableName() string {
+	return "otp_lockouts"
+}
+
+// IsLocked reports whether the key is locked out at the time now.
+func (m *OtpLockout) IsLocked(now time.Time) bool {
+	return m.LockedUntil != nil && now.Before(*m.LockedUntil)
+}
+
+// Fail records a failed attempt at the time now, and reports whether it
+// locked the key out.
+func (m *OtpLockout) Fail(now time.Time, p OtpLockoutPolicy) bool {
+	// failures are forgotten Max after the last failure or lockout
+	if m.FailedAt != nil {
+		last := *m.FailedAt
+		if m.LockedUntil != nil && m.LockedUntil.After(last) {
+			last = *m.LockedUntil
+		}
+
+		if now.Sub(last) > p.Max {
+			m.Succeed()
+		}
+	}
+
+	m.Failures++
+	m.FailedAt = &now
+
+	if m.Failures < p.Failures {
+		return false
+	}
+
+	m.Failures = 0
+	m.Level++
+
+	d := p.Base
+	for i := 1; i < m.Level && d < p.Max; i++ {
+		d *= 2
+	}
+	d = min(d, p.Max)
+
+	until := now.Add(d)
+	m.LockedUntil = &until
+
+	return true
+}
+
+// Succeed forgives the failures of the key.
+func (m *OtpLockout) Succeed() {
+	m.Failures = 0
+	m.Level = 0
+	m.FailedAt = nil
+	m.LockedUntil = nil
+}
+
+func (m *OtpLockout) TxSave(tx *gorm.DB) error {
+	return tx.Save(m).Error
+}
@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOtpLockout(t *testing.T) {
+	policy := OtpLockoutPolicy{Failures: 3, Base: time.Minute, Max: 5 * time.Minute}
+	now := time.Now()
+
+	m := &OtpLockout{}
+	require.False(t, m.Fail(now, policy))
+	require.False(t, m.Fail(now, policy))
+	require.True(t, m.Fail(now, policy))
+	require.True(t, m.IsLocked(now))
+	require.Equal(t, now.Add(time.Minute), *m.LockedUntil)
+
+	// each lockout doubles, up to the max
+	testCases := []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for _, d := range testCases {
+		now = m.LockedUntil.Add(time.Second)
+		require.False(t, m.IsLocked(now))
+		require.False(t, m.Fail(now, policy))
+		require.False(t, m.Fail(now, policy))
+		require.True(t, m.Fail(now, policy))
+		require.Equal(t, now.Add(d), *m.LockedUntil)
+	}
+
+	// failures are forgotten after the longest lockout
+	now = m.LockedUntil.Add(policy.Max + time.Second)
+	require.False(t, m.Fail(now, policy))
+	require.Equal(t, 0, m.Level)
+	require.Equal(t, 1, m.Failures)
+
+	m.Succeed()
+	require.False(t, m.IsLocked(now))
+	require.Equal(t, 0, m.Failures)
+}
+
+func TestOtpChallenge(t *testing.T) {
+	t.Run("single use", func(t *testing.T) {
+		m := NewOtpChallenge("Alice@Example.com", "123456", "127.0.0.1", 3, time.Minute)
+		require.Equal(t, "alice@example.com", m.Email)
+		require.NotContains(t, m.CodeHash, "123456")
+
+		require.True(t, m.Attempt("123456"))
+		require.True(t, m.IsConsumed())
+		require.False(t, m.Attempt("123456"))
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		m := NewOtpChallenge("alice@example.com", "123456", "127.0.0.1", 3, time.Minute)
+
+		require.False(t, m.Attempt("000000"))
+		require.False(t, m.Attempt("111111"))
+		require.Equal(t, 1, m.AttemptsLeft())
+		require.False(t, m.Attempt("222222"))
+		require.True(t, m.IsConsumed())
+		require.False(t, m.Attempt("123456"))
+		require.Equal(t, 3, m.Attempts)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		m := NewOtpChallenge("alice@example.com", "123456", "127.0.0.1", 3, -time.Second)
+		require.False(t, m.Attempt("123456"))
+	})
+}
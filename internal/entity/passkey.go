@@ -0,0 +1,129 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+// Passkeys represents a passkey result set.
+type Passkeys []Passkey
+
+// Passkey is a WebAuthn credential a user signs in with. A user can register
+// several of them, e.g. one per device.
+type Passkey struct {
+	ID              uint       `gorm:"primarykey"                   json:"id"`
+	UserID          uint       `gorm:"index;column:user_id"         json:"user_id"`
+	Name            string     `gorm:"type:varchar(64)"             json:"name"`
+	CredentialID    []byte     `gorm:"uniqueIndex;not null"         json:"-"`
+	PublicKey       []byte     `gorm:"not null"                     json:"-"`
+	AttestationType string     `gorm:"type:varchar(32)"             json:"-"`
+	Transports      []string   `gorm:"type:text;serializer:json"    json:"transports"`
+	AAGUID          []byte     `                                    json:"-"`
+	SignCount       uint32     `                                    json:"-"`
+	BackupEligible  bool       `                                    json:"backup_eligible"`
+	BackupState     bool       `                                    json:"backup_state"`
+	CloneWarning    bool       `                                    json:"clone_warning"` // the sign count went backwards
+	CreatedAt       time.Time  `                                    json:"created_at"`
+	LastUsedAt      *time.Time `                                    json:"last_used_at"`
+}
+
+// TableName returns the entity table name.
+func (Passkey) TableName() string {
+	return "passkeys"
+}
+
+// NewPasskey returns the passkey of a registered credential.
+func NewPasskey(userID uint, name string, c *webauthn.Credential) *Passkey {
+	m := &Passkey{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+	}
+
+	for _, t := range c.Transport {
+		m.Transports = append(m.Transports, string(t))
+	}
+
+	return m
+}
+
+// Credential returns the passkey as a WebAuthn credential.
+func (m *Passkey) Credential() webauthn.Credential {
+	c := webauthn.Credential{
+		ID:              m.CredentialID,
+		PublicKey:       m.PublicKey,
+		AttestationType: m.AttestationType,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: m.BackupEligible,
+			BackupState:    m.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       m.AAGUID,
+			SignCount:    m.SignCount,
+			CloneWarning: m.CloneWarning,
+		},
+	}
+
+	for _, t := range m.Transports {
+		c.Transport = append(c.Transport, protocol.AuthenticatorTransport(t))
+	}
+
+	return c
+}
+
+func (m *Passkey) Create() error {
+	return db.Db().Create(m).Error
+}
+
+func (m *Passkey) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
+
+// TxUse records a login with the passkey, updating the sign count the
+// authenticator reported.
+func (m *Passkey) TxUse(tx *gorm.DB, c *webauthn.Credential) error {
+	now := time.Now()
+
+	m.SignCount = c.Authenticator.SignCount
+	m.CloneWarning = m.CloneWarning || c.Authenticator.CloneWarning
+	m.BackupState = c.Flags.BackupState
+	m.LastUsedAt = &now
+
+	return tx.Model(m).Updates(map[string]interface{}{
+		"sign_count":    m.SignCount,
+		"clone_warning": m.CloneWarning,
+		"backup_state":  m.BackupState,
+		"last_used_at":  m.LastUsedAt,
+	}).Error
+}
+
+// PasskeyCeremony is the state of a passkey registration or login between
+// its options being issued and the authenticator's response. It can be
+// finished once, before it expires.
+type PasskeyCeremony struct {
+	ID        uint                 `gorm:"primarykey"                   json:"-"`
+	Token     string               `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	UserID    *uint                `gorm:"index"                        json:"-"` // nil for logins
+	Session   webauthn.SessionData `gorm:"type:text;serializer:json"    json:"-"`
+	ExpiresAt time.Time            `gorm:"index"                        json:"-"`
+	CreatedAt time.Time            `                                    json:"-"`
+}
+
+// TableName returns the entity table name.
+func (PasskeyCeremony) TableName() string {
+	return "passkey_ceremonies"
+}
+
+func (m *PasskeyCeremony) Create() error {
+	return db.Db().Create(m).Error
+}
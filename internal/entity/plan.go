@@ -0,0 +1,30 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+)
+
+// Plan is a subscription plan, which determines the storage capacity of its
+// subscribers before referral bonuses.
+type Plan struct {
+	ID        uint      `gorm:"primarykey"                        json:"id"`
+	Name      string    `gorm:"type:varchar(64);uniqueIndex"      json:"name"`
+	Capacity  int64     `gorm:"not null"                          json:"capacity"` // bytes format
+	CreatedAt time.Time `                                         json:"created_at"`
+	UpdatedAt time.Time `                                         json:"updated_at"`
+}
+
+// TableName returns the entity table name.
+func (Plan) TableName() string {
+	return "plans"
+}
+
+func (m *Plan) Create() error {
+	return db.Db().Create(m).Error
+}
+
+func (m *Plan) Save() error {
+	return db.Db().Save(m).Error
+}
@@ -0,0 +1,54 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"gorm.io/gorm"
+)
+
+// PresignedURLLog is an audit record of a presigned URL issued to a user.
+// Uploads are staged under ObjectKey, and completed once their content was
+// verified against CID.
+type PresignedURLLog struct {
+	ID            uint       `gorm:"primarykey"                 json:"id"`
+	UserID        uint       `gorm:"index;column:user_id"       json:"user_id"`
+	FileID        *uint      `gorm:"index;column:file_id"       json:"file_id"`
+	Method        string     `gorm:"type:varchar(8);not null"   json:"method"`
+	ObjectKey     string     `gorm:"type:varchar(256);index"    json:"object_key"`
+	CID           string     `gorm:"type:varchar(64)"           json:"cid"`
+	ContentType   string     `gorm:"type:varchar(256)"          json:"content_type"`
+	ContentLength int64      `                                  json:"content_length"`
+	URL           string     `gorm:"type:varchar(2048)"         json:"url"` // signature and credential stripped
+	ClientIP      string     `gorm:"type:varchar(64)"           json:"client_ip"`
+	ExpiresAt     time.Time  `                                  json:"expires_at"`
+	CompletedAt   *time.Time `                                  json:"completed_at"`
+	CreatedAt     time.Time  `gorm:"index"                      json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (PresignedURLLog) TableName() string {
+	return "presigned_url_logs"
+}
+
+func (m *PresignedURLLog) Create() error {
+	return db.Db().Create(m).Error
+}
+
+// TxComplete marks the upload as completed, and reports whether it was not
+// completed before, so that only one request completes it.
+func (m *PresignedURLLog) TxComplete(tx *gorm.DB) (bool, error) {
+	now := time.Now()
+
+	res := tx.Model(m).Where("completed_at IS NULL").Update("completed_at", now)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	if res.RowsAffected != 1 {
+		return false, nil
+	}
+
+	m.CompletedAt = &now
+	return true, nil
+}
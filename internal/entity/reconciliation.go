@@ -0,0 +1,68 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+)
+
+type ReconciliationStatus string
+
+const (
+	ReconciliationRunning   ReconciliationStatus = "running"
+	ReconciliationCompleted ReconciliationStatus = "completed"
+	ReconciliationFailed    ReconciliationStatus = "failed"
+)
+
+type ReconciliationIssueKind string
+
+const (
+	// MissingObject is a CID referenced by files but not stored in the pool.
+	MissingObject ReconciliationIssueKind = "missing"
+	// OrphanedObject is an object stored in the pool that no file references.
+	OrphanedObject ReconciliationIssueKind = "orphaned"
+)
+
+// Reconciliation is a run of the job comparing the files table with the
+// objects in the storage pool.
+type Reconciliation struct {
+	ID            uint                 `gorm:"primarykey"                      json:"id"`
+	Status        ReconciliationStatus `gorm:"type:varchar(16);not null"       json:"status"`
+	CIDsChecked   int64                `                                       json:"cids_checked"`
+	ObjectsListed int64                `                                       json:"objects_listed"`
+	Missing       int64                `                                       json:"missing"`
+	Orphaned      int64                `                                       json:"orphaned"`
+	Error         string               `gorm:"type:varchar(1024)"              json:"error"`
+	StartedAt     time.Time            `gorm:"index"                           json:"started_at"`
+	FinishedAt    *time.Time           `                                       json:"finished_at"`
+}
+
+// TableName returns the entity table name.
+func (Reconciliation) TableName() string {
+	return "reconciliations"
+}
+
+func (m *Reconciliation) Create() error {
+	return db.Db().Create(m).Error
+}
+
+func (m *Reconciliation) Save() error {
+	return db.Db().Save(m).Error
+}
+
+// ReconciliationIssue is a discrepancy found by a reconciliation run.
+type ReconciliationIssue struct {
+	ID               uint                    `gorm:"primarykey"                     json:"id"`
+	ReconciliationID uint                    `gorm:"index"                          json:"reconciliation_id"`
+	Kind             ReconciliationIssueKind `gorm:"type:varchar(16);index"         json:"kind"`
+	ObjectKey        string                  `gorm:"type:varchar(1024);index"       json:"object_key"`
+	Files            int64                   `                                      json:"files"` // files referencing a missing object
+	Size             int64                   `                                      json:"size"`  // size of an orphaned object
+	LastModified     *time.Time              `                                      json:"last_modified"`
+	CreatedAt        time.Time               `                                      json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (ReconciliationIssue) TableName() string {
+	return "reconciliation_issues"
+}
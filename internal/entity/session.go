@@ -0,0 +1,48 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Sessions represents a session result set.
+type Sessions []Session
+
+// Session is a refresh token issued to a user, keyed by the token ID. Renewing
+// a session rotates its refresh token: the used token is marked as rotated and
+// a new one is issued in the same family. Using a rotated token again revokes
+// the whole family, since the token must have been stolen.
+type Session struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primarykey"          json:"-"`
+	FamilyID  uuid.UUID  `gorm:"type:uuid;index;not null"      json:"id"`
+	UserID    uint       `gorm:"index;column:user_id"          json:"-"`
+	Device    string     `gorm:"type:varchar(256)"             json:"device"`
+	IP        string     `gorm:"type:varchar(64)"              json:"ip"`
+	UserAgent string     `gorm:"type:varchar(512)"             json:"user_agent"`
+	StartedAt time.Time  `                                     json:"started_at"`
+	ExpiresAt time.Time  `gorm:"index"                         json:"expires_at"`
+	RotatedAt *time.Time `                                     json:"-"`
+	RevokedAt *time.Time `                                     json:"-"`
+	CreatedAt time.Time  `                                     json:"renewed_at"`
+}
+
+// TableName returns the entity table name.
+func (Session) TableName() string {
+	return "sessions"
+}
+
+func (m *Session) Create() error {
+	return db.Db().Create(m).Error
+}
+
+func (m *Session) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
+
+// IsActive returns true if the refresh token of the session can be used.
+func (m *Session) IsActive() bool {
+	return m.RotatedAt == nil && m.RevokedAt == nil && time.Now().Before(m.ExpiresAt)
+}
@@ -0,0 +1,141 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// TwoFactorPeriod is the number of seconds an authenticator code is valid.
+const TwoFactorPeriod = 30
+
+// Methods a step-up challenge can be answered with.
+const (
+	TwoFactorTOTP         = "totp"
+	TwoFactorRecoveryCode = "recovery_code"
+)
+
+// TwoFactor is the authenticator app a user enrolled as second factor. Its
+// secret is encrypted. It is pending until a first code confirms it.
+type TwoFactor struct {
+	ID           uint       `gorm:"primarykey"            json:"-"`
+	UserID       uint       `gorm:"uniqueIndex"           json:"user_id"`
+	Secret       []byte     `gorm:"not null"              json:"-"`
+	LastUsedStep int64      `gorm:"not null;default:0"    json:"-"` // codes of earlier steps are rejected
+	EnabledAt    *time.Time `                             json:"enabled_at"`
+	CreatedAt    time.Time  `                             json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (TwoFactor) TableName() string {
+	return "two_factors"
+}
+
+// IsEnabled reports whether the factor was confirmed.
+func (m *TwoFactor) IsEnabled() bool {
+	return m.EnabledAt != nil
+}
+
+// Validate checks a code of the authenticator with the decrypted secret at
+// the time now, allowing one step of clock skew. A code is accepted once: the
+// step it was valid for is recorded, and codes of that step and earlier ones
+// are rejected afterwards.
+func (m *TwoFactor) Validate(secret, code string, now time.Time) bool {
+	opts := totp.ValidateOpts{
+		Period:    TwoFactorPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+
+	for _, skew := range []int64{-1, 0, 1} {
+		step := now.Unix()/TwoFactorPeriod + skew
+		if step <= m.LastUsedStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*TwoFactorPeriod, 0), opts)
+		if err != nil {
+			return false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			m.LastUsedStep = step
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *TwoFactor) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
+
+// TxSave saves the last used step and the confirmation of the factor.
+func (m *TwoFactor) TxSave(tx *gorm.DB) error {
+	return tx.Model(m).Updates(map[string]interface{}{
+		"last_used_step": m.LastUsedStep,
+		"enabled_at":     m.EnabledAt,
+	}).Error
+}
+
+// RecoveryCode is a code that answers a step-up challenge once, for users
+// who lost their authenticator. Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primarykey"                 json:"-"`
+	UserID    uint       `gorm:"index"                      json:"-"`
+	CodeHash  string     `gorm:"type:varchar(64);not null"  json:"-"`
+	UsedAt    *time.Time `                                  json:"used_at"`
+	CreatedAt time.Time  `                                  json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored as. Codes are
+// compared without case, dashes and spaces.
+func HashRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// TwoFactorChallenge is the step-up challenge of a user who signed in with a
+// first factor and enabled two-factor authentication. Answering it issues the
+// session; it can be answered once, and only a few times wrong.
+type TwoFactorChallenge struct {
+	ID         uint       `gorm:"primarykey"                   json:"-"`
+	Token      string     `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	UserID     uint       `gorm:"index"                        json:"-"`
+	Method     string     `gorm:"type:varchar(16)"             json:"method"` // the first factor
+	Attempts   int        `gorm:"not null;default:0"           json:"attempts"`
+	ConsumedAt *time.Time `                                    json:"consumed_at"`
+	ExpiresAt  time.Time  `gorm:"index"                        json:"expires_at"`
+	CreatedAt  time.Time  `                                    json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (TwoFactorChallenge) TableName() string {
+	return "two_factor_challenges"
+}
+
+func (m *TwoFactorChallenge) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
+
+// TxSave saves the attempts of the challenge.
+func (m *TwoFactorChallenge) TxSave(tx *gorm.DB) error {
+	return tx.Model(m).Updates(map[string]interface{}{
+		"attempts":    m.Attempts,
+		"consumed_at": m.ConsumedAt,
+	}).Error
+}
@@ -0,0 +1,41 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactorValidate(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "hello.app", AccountName: "alice"})
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := totp.GenerateCode(key.Secret(), now)
+	require.NoError(t, err)
+
+	m := &TwoFactor{}
+	require.False(t, m.Validate(key.Secret(), "000000"+code, now))
+	require.True(t, m.Validate(key.Secret(), code, now))
+
+	// a code is accepted once
+	require.False(t, m.Validate(key.Secret(), code, now))
+
+	// codes of the previous step are accepted for clock skew, but not after a
+	// later code was used
+	previous, err := totp.GenerateCode(key.Secret(), now.Add(-TwoFactorPeriod*time.Second))
+	require.NoError(t, err)
+	require.False(t, m.Validate(key.Secret(), previous, now))
+
+	next, err := totp.GenerateCode(key.Secret(), now.Add(TwoFactorPeriod*time.Second))
+	require.NoError(t, err)
+	require.True(t, m.Validate(key.Secret(), next, now))
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	require.Equal(t, HashRecoveryCode("abcde-12345"), HashRecoveryCode("ABCDE 12345"))
+	require.Equal(t, HashRecoveryCode("abcde-12345"), HashRecoveryCode("abcde12345"))
+	require.NotEqual(t, HashRecoveryCode("abcde-12345"), HashRecoveryCode("abcde-12346"))
+}
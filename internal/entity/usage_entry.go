@@ -0,0 +1,41 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UsageReason string
+
+const (
+	// UsageOpening records the storage a user used before the ledger was kept.
+	UsageOpening      UsageReason = "opening"
+	UsageFileCreated  UsageReason = "file_created"
+	UsageFileDeleted  UsageReason = "file_deleted"
+	UsageFileRestored UsageReason = "file_restored"
+	// UsageFileReplaced records the change of size of a file whose content
+	// was replaced through a presigned URL.
+	UsageFileReplaced UsageReason = "file_replaced"
+)
+
+// UsageEntry is a change of the storage used by a user. The storage_used
+// counter of the user details is the sum of the user's entries, and both are
+// written in the same transaction.
+type UsageEntry struct {
+	ID        uint        `gorm:"primarykey"                   json:"id"`
+	UserID    uint        `gorm:"index;not null"               json:"user_id"`
+	FileID    *uint       `gorm:"index"                        json:"file_id"`
+	Delta     int64       `gorm:"not null"                     json:"delta"` // bytes format
+	Reason    UsageReason `gorm:"type:varchar(16);not null"    json:"reason"`
+	CreatedAt time.Time   `                                    json:"created_at"`
+}
+
+// TableName returns the entity table name.
+func (UsageEntry) TableName() string {
+	return "usage_ledger"
+}
+
+func (m *UsageEntry) TxCreate(tx *gorm.DB) error {
+	return tx.Create(m).Error
+}
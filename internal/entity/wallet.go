@@ -13,13 +13,14 @@ const (
 	Provider AccountType = "provider"
 	Google   AccountType = "google"
 	GitHub   AccountType = "github"
+	OIDC     AccountType = "oidc"
 )
 
 type Wallet struct {
 	ID          uint   `gorm:"primarykey"                            json:"id"`
 	Address     string `gorm:"type:varchar(50);not null;uniqueIndex" json:"address"`
 	AccountType string `gorm:"type:account_type;not null;default:'provider'" json:"account_type"`
-	Type        string `gorm:"type:varchar(30);not null;default:eth" json:"type"`
+	Type        string `gorm:"type:varchar(30);not null;default:eth" json:"type"` // how signatures are verified, see web3.Method
 	PrivateKey  []byte `gorm:"type:bytea;" json:"private_key"`
 	Nonce       string `gorm:"type:varchar(16);not null"             json:"nonce"`
 	UserID      uint   `gorm:"uniqueIndex"`
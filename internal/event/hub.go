@@ -38,3 +38,8 @@ func Topic(topic string) (ch, ev string) {
 	ch, ev, _ = strings.Cut(topic, TopicSep)
 	return ch, ev
 }
+
+// Publish sends a message with data to the subscribers of a topic.
+func Publish(topic string, data Data) {
+	SharedHub().Publish(Message{Name: topic, Fields: data})
+}
@@ -1,11 +1,37 @@
 package form
 
-import "time"
+import (
+	"time"
 
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+)
+
+// CreateApiKeyRequest creates a named API key, valid for ExpiresIn seconds or
+// without expiration if it is zero. Keys can only read if no scopes are given.
 type CreateApiKeyRequest struct {
-	WalletAddress string `json:"wallet_address" binding:"required"`
+	Name      string   `json:"name"       binding:"max=64"`
+	ExpiresIn int64    `json:"expires_in" binding:"gte=0"`
+	Scopes    []string `json:"scopes"`
 }
+
+// CreateApiKeyResponse includes the key itself, which is only shown once.
 type CreateApiKeyResponse struct {
-	ApiKey          string    `json:"api_key"`
+	*entity.ApiKey
+	Key             string    `json:"api_key"`
 	ApiKeyExpiresAt time.Time `json:"api_key_expires_at"`
 }
+
+// ApiKeyFilesRequest pages the files uploaded with an API key.
+type ApiKeyFilesRequest struct {
+	Page  int `form:"page"  binding:"omitempty,gte=1"`
+	Limit int `form:"limit" binding:"omitempty,gte=1,lte=100"`
+}
+
+// ApiKeyFilesResponse is a page of the files uploaded with an API key, most
+// recent first.
+type ApiKeyFilesResponse struct {
+	Files []FileResponse `json:"files"`
+	Page  int            `json:"page"`
+	Limit int            `json:"limit"`
+	Total int64          `json:"total"`
+}
@@ -15,19 +15,34 @@ type RegisterUserRequest struct {
 	Name string `json:"name"`
 }
 
+// LoginUserRequest signs in with a Sign-In with Ethereum (EIP-4361) message
+// carrying a nonce from /api/nonce.
 type LoginUserRequest struct {
 	Name          string `json:"name"`
 	WalletAddress string `json:"wallet_address" binding:"required"`
+	Message       string `json:"message"        binding:"required"`
 	Signature     string `json:"signature"      binding:"required"`
 	Referral      string `json:"referral"`
 }
 
+// NonceResponse is a nonce for signing in, and a message carrying it that
+// the wallet can sign as is.
+type NonceResponse struct {
+	Nonce     string    `json:"nonce"`
+	Message   string    `json:"message"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LoginUserResponse carries the tokens of a new session, or only a step-up
+// challenge if the user enabled two-factor authentication.
 type LoginUserResponse struct {
-	SessionID             uuid.UUID `json:"session_id"`
-	AccessToken           string    `json:"access_token"`
-	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
-	RefreshToken          string    `json:"refresh_token"`
-	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
-	WalletAddress         string    `json:"wallet_address"`
-	WalletPrivateKey      string    `json:"wallet_private_key"`
+	SessionID             uuid.UUID                   `json:"session_id"`
+	AccessToken           string                      `json:"access_token"`
+	AccessTokenExpiresAt  time.Time                   `json:"access_token_expires_at"`
+	RefreshToken          string                      `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time                   `json:"refresh_token_expires_at"`
+	WalletAddress         string                      `json:"wallet_address"`
+	WalletPrivateKey      string                      `json:"wallet_private_key"`
+	TwoFactor             *TwoFactorChallengeResponse `json:"two_factor,omitempty"`
 }
@@ -42,3 +42,15 @@ type FileResponse struct {
 	CreatedAt            string                  `json:"created_at"`
 	UpdatedAt            string                  `json:"updated_at"`
 }
+
+// FileUploadRequest holds the metadata of a streamed upload. The file content
+// itself is sent as the raw request body.
+type FileUploadRequest struct {
+	Name                 string                  `form:"name"                   binding:"required"`
+	CID                  string                  `form:"cid"                    binding:"required"`
+	CIDOriginalEncrypted string                  `form:"cid_original_encrypted"`
+	Root                 string                  `form:"root"`
+	Path                 string                  `form:"path"`
+	MimeType             string                  `form:"mime_type"`
+	EncryptionStatus     entity.EncryptionStatus `form:"encryption_status"`
+}
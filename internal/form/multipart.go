@@ -0,0 +1,34 @@
+package form
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+)
+
+// CreateMultipartUploadRequest starts a multipart upload of a new file. The
+// declared size decides the part size and is checked when the upload completes.
+type CreateMultipartUploadRequest struct {
+	Name                 string                  `json:"name"                   binding:"required"`
+	CID                  string                  `json:"cid"                    binding:"required"`
+	CIDOriginalEncrypted string                  `json:"cid_original_encrypted"`
+	Root                 string                  `json:"root"`
+	Path                 string                  `json:"path"`
+	MimeType             string                  `json:"mime_type"`
+	Size                 int64                   `json:"size"                   binding:"required,gt=0"`
+	EncryptionStatus     entity.EncryptionStatus `json:"encryption_status"`
+}
+
+type MultipartUploadResponse struct {
+	*entity.MultipartUpload
+	PartSize   int64 `json:"part_size"`
+	PartsCount int32 `json:"parts_count"`
+}
+
+type MultipartPartURLResponse struct {
+	PartNumber   int32               `json:"part_number"`
+	PresignedURL string              `json:"presigned_url"`
+	Method       string              `json:"method"`
+	Headers      map[string][]string `json:"headers"`
+	ExpiresAt    time.Time           `json:"expires_at"`
+}
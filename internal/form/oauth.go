@@ -0,0 +1,10 @@
+package form
+
+// OAuthProvisionRequest is the custodial wallet and referrer code a user who
+// signs in with an OAuth provider for the first time is created with. It is
+// the query of the OAuth routes, and part of the body of the OIDC callback.
+type OAuthProvisionRequest struct {
+	WalletAddress string `json:"wallet_address" form:"wallet_address"`
+	PrivateKey    string `json:"private_key"    form:"private_key"`
+	ReferrerCode  string `json:"referrer_code"  form:"referrer_code"`
+}
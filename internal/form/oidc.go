@@ -0,0 +1,24 @@
+package form
+
+import "time"
+
+// OIDCAuthorizeResponse starts a login at an OpenID Connect provider: the
+// user is redirected to the URL, and returns to the redirect URL of the
+// provider with a code and the state. The binding is kept by the client, e.g.
+// in session storage, and sent with them, so that only the client that
+// started the login can finish it.
+type OIDCAuthorizeResponse struct {
+	URL       string    `json:"url"`
+	State     string    `json:"state"`
+	Binding   string    `json:"binding"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OIDCCallbackRequest is the body a client posts when a user returns from an
+// OpenID Connect provider. New users are created with the wallet in it too.
+type OIDCCallbackRequest struct {
+	Code    string `json:"code"    binding:"required"`
+	State   string `json:"state"   binding:"required"`
+	Binding string `json:"binding" binding:"required"`
+	OAuthProvisionRequest
+}
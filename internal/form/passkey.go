@@ -0,0 +1,19 @@
+package form
+
+import "time"
+
+// PasskeyOptionsResponse starts a passkey ceremony: the options are passed to
+// navigator.credentials, and the ceremony token is sent back with the
+// authenticator's response.
+type PasskeyOptionsResponse struct {
+	Ceremony  string      `json:"ceremony"`
+	Options   interface{} `json:"options"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// FinishPasskeyRequest is the query of a request finishing a passkey
+// ceremony, whose body is the credential returned by the authenticator.
+type FinishPasskeyRequest struct {
+	Ceremony string `form:"ceremony" binding:"required"`
+	Name     string `form:"name"     binding:"max=64"` // registration only
+}
@@ -0,0 +1,47 @@
+package form
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+)
+
+// PutPresignedURLRequest asks for a URL to upload a file directly to the bucket.
+// Either UID (an existing file owned by the user) or CID (a new file) is required.
+// The content is staged until the upload is completed.
+type PutPresignedURLRequest struct {
+	UID           string `json:"uid"`
+	CID           string `json:"cid"`
+	ContentType   string `json:"content_type"   binding:"required"`
+	ContentLength int64  `json:"content_length" binding:"required,gt=0"`
+	ExpiresIn     int64  `json:"expires_in"` // seconds
+}
+
+// CompletePresignedUploadRequest completes an upload to a presigned URL, once
+// the content was uploaded to the object key the URL was issued for. The file
+// fields describe the new file of an upload by CID, and are ignored otherwise.
+type CompletePresignedUploadRequest struct {
+	ObjectKey            string                  `json:"object_key"             binding:"required"`
+	Name                 string                  `json:"name"`
+	CIDOriginalEncrypted string                  `json:"cid_original_encrypted"`
+	Root                 string                  `json:"root"`
+	Path                 string                  `json:"path"`
+	MimeType             string                  `json:"mime_type"`
+	EncryptionStatus     entity.EncryptionStatus `json:"encryption_status"`
+}
+
+// GetPresignedURLRequest asks for a URL to download a file directly from the bucket.
+// Either UID or CID of a file the user has access to is required.
+type GetPresignedURLRequest struct {
+	UID       string `form:"uid"`
+	CID       string `form:"cid"`
+	ExpiresIn int64  `form:"expires_in"` // seconds
+}
+
+type PresignedURLResponse struct {
+	PresignedURL string              `json:"presigned_url"`
+	Method       string              `json:"method"`
+	Headers      map[string][]string `json:"headers"`
+	ObjectKey    string              `json:"object_key"`
+	ExpiresAt    time.Time           `json:"expires_at"`
+}
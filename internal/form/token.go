@@ -1,12 +1,26 @@
 package form
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type RenewAccessTokenRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
+// RenewAccessTokenResponse includes the refresh token replacing the one used,
+// which cannot be used again. Before refresh tokens were rotated, it only had
+// the access token and its expiry, which keep their fields.
 type RenewAccessTokenResponse struct {
-	AccessToken          string    `json:"access_token"`
-	AccessTokenExpiresAt time.Time `json:"access_token_expires_at"`
+	SessionID             uuid.UUID `json:"session_id"`
+	AccessToken           string    `json:"access_token"`
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
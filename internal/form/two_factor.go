@@ -0,0 +1,37 @@
+package form
+
+import "time"
+
+// TwoFactorChallengeResponse is returned instead of tokens when a user who
+// enabled two-factor authentication signs in. The challenge is answered with
+// a code of one of the methods at /api/2fa/verify.
+type TwoFactorChallengeResponse struct {
+	Challenge string    `json:"challenge"`
+	Methods   []string  `json:"methods"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EnrollTwoFactorResponse is shown once: the URI is rendered as a QR code
+// for the authenticator app, and the recovery codes are kept by the user.
+type EnrollTwoFactorResponse struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at"`
+	RecoveryCodesLeft int64      `json:"recovery_codes_left"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// VerifyTwoFactorRequest answers a step-up challenge with an authenticator
+// code or a recovery code.
+type VerifyTwoFactorRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code"      binding:"required"`
+}
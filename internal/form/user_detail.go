@@ -0,0 +1,12 @@
+package form
+
+import "github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+
+// UserDetailResponse adds the storage quota to the details of a user. All
+// sizes are in bytes.
+type UserDetailResponse struct {
+	*entity.UserDetail
+	Plan             string `json:"plan"`
+	StorageCapacity  int64  `json:"storage_capacity"`
+	StorageRemaining int64  `json:"storage_remaining"`
+}
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/api"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidApiKey = errors.New("api key is invalid, expired or revoked")
+
+// ApiKeyMiddleware creates a gin middleware authorizing requests with the
+// api-key header. Requests without it are left to AuthMiddleware, which must
+// come after it. The key has to decrypt and be stored in the api_keys table
+// without being revoked, so deleting or revoking a key disables it at once.
+func ApiKeyMiddleware(tokenMaker token.Maker) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(constant.APIKeyHeaderKey)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+
+		payload, err := tokenMaker.VerifyApiKey(key)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(err))
+			return
+		}
+
+		apiKey, err := query.FindApiKey(key)
+		if err != nil || apiKey.UserID != payload.UserID || apiKey.IsRevoked() || apiKey.IsExpired() {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(errInvalidApiKey))
+			return
+		}
+
+		if err := apiKey.IncrementKeyRequests(); err != nil {
+			log.Errorf("failed to count request of api key %d: %v", apiKey.ID, err)
+		}
+
+		ctx.Set(constant.AuthorizationPayloadKey, payload)
+		ctx.Set(constant.APIKeyContextKey, apiKey)
+		ctx.Next()
+	}
+}
@@ -0,0 +1,151 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/constant"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKeyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keyring, err := token.NewKeyring("1", "12345678901234567890123456789012", nil)
+	require.NoError(t, err)
+
+	tokenMaker, err := token.NewPasetoMaker(keyring)
+	require.NoError(t, err)
+
+	accessToken, _, err := tokenMaker.CreateAccessToken(1, "u1", "user", 0)
+	require.NoError(t, err)
+	refreshToken, _, err := tokenMaker.CreateRefreshToken(1, "u1", "user", 0)
+	require.NoError(t, err)
+	apiKey, _, err := tokenMaker.CreateApiKey(1, "u1", "user", 0, token.Scopes)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(ApiKeyMiddleware(tokenMaker), AuthMiddleware(tokenMaker))
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"no credentials", nil, http.StatusUnauthorized},
+		{"bearer token", map[string]string{constant.AuthorizationHeaderKey: "Bearer " + accessToken}, http.StatusOK},
+		{"refresh token as bearer token", map[string]string{constant.AuthorizationHeaderKey: "Bearer " + refreshToken}, http.StatusUnauthorized},
+		{"api key as bearer token", map[string]string{constant.AuthorizationHeaderKey: "Bearer " + apiKey}, http.StatusUnauthorized},
+		{"access token as api key", map[string]string{constant.APIKeyHeaderKey: accessToken}, http.StatusUnauthorized},
+		{"malformed api key", map[string]string{constant.APIKeyHeaderKey: "not-a-key"}, http.StatusUnauthorized},
+		{
+			"malformed api key with bearer token",
+			map[string]string{constant.APIKeyHeaderKey: "not-a-key", constant.AuthorizationHeaderKey: "Bearer " + accessToken},
+			http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tc.header {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tc.status, w.Code)
+		})
+	}
+}
+
+func TestApiKeyMiddlewareStoredKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testdb.Open(t)
+
+	keyring, err := token.NewKeyring("1", "12345678901234567890123456789012", nil)
+	require.NoError(t, err)
+
+	tokenMaker, err := token.NewPasetoMaker(keyring)
+	require.NoError(t, err)
+
+	// storeKey stores a new key of user 1 as m
+	storeKey := func(m *entity.ApiKey) string {
+		key, _, err := tokenMaker.CreateApiKey(1, "u1", "user", 0, []token.Scope{token.ScopeFilesRead})
+		require.NoError(t, err)
+
+		m.UserID = 1
+		m.SetKey(key)
+		require.NoError(t, m.Create())
+		return key
+	}
+
+	past := time.Now().Add(-time.Minute)
+	valid := &entity.ApiKey{Name: "valid"}
+	validKey := storeKey(valid)
+	revokedKey := storeKey(&entity.ApiKey{Name: "revoked", RevokedAt: &past})
+	expiredKey := storeKey(&entity.ApiKey{Name: "expired", ExpiresAt: &past})
+	otherKey := storeKey(&entity.ApiKey{Name: "other"})
+	require.NoError(t, db.Db().Model(&entity.ApiKey{}).Where("name = ?", "other").Update("user_id", 2).Error)
+
+	// keys issued before keys were hashed are stored in plain text until the
+	// migration hashes them
+	legacyKey, _, err := tokenMaker.CreateApiKey(1, "u1", "user", 0, token.Scopes)
+	require.NoError(t, err)
+	require.NoError(t, (&entity.ApiKey{UserID: 1, Name: "legacy", ApiKey: legacyKey}).Create())
+	require.NoError(t, entity.HashLegacyApiKeys(db.Db()))
+
+	var apiKeyID uint
+	router := gin.New()
+	router.Use(ApiKeyMiddleware(tokenMaker), AuthMiddleware(tokenMaker))
+	router.GET("/", func(ctx *gin.Context) {
+		apiKeyID = ctx.MustGet(constant.APIKeyContextKey).(*entity.ApiKey).ID
+		ctx.Status(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"stored key", validKey, http.StatusOK},
+		{"legacy key", legacyKey, http.StatusOK},
+		{"revoked key", revokedKey, http.StatusUnauthorized},
+		{"expired key", expiredKey, http.StatusUnauthorized},
+		{"key stored for another user", otherKey, http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(constant.APIKeyHeaderKey, tc.key)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tc.status, w.Code)
+		})
+	}
+
+	// requests are counted for the key that authorized them
+	apiKeyID = 0
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(constant.APIKeyHeaderKey, validKey)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, valid.ID, apiKeyID)
+
+	m, err := query.FindApiKey(validKey)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.KeyRequests)
+	require.NotNil(t, m.LastUsedAt)
+}
@@ -16,9 +16,15 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware creates a gin middleware for authorization
+// AuthMiddleware creates a gin middleware for authorization. Requests already
+// authorized by ApiKeyMiddleware are passed through.
 func AuthMiddleware(tokenMaker token.Maker) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if _, ok := ctx.Get(constant.AuthorizationPayloadKey); ok {
+			ctx.Next()
+			return
+		}
+
 		authorizationHeader := ctx.GetHeader(constant.AuthorizationHeaderKey)
 
 		if len(authorizationHeader) == 0 {
@@ -42,7 +48,7 @@ func AuthMiddleware(tokenMaker token.Maker) gin.HandlerFunc {
 		}
 
 		accessToken := fields[1]
-		payload, err := tokenMaker.VerifyToken(accessToken)
+		payload, err := tokenMaker.VerifyAccessToken(accessToken)
 		if err != nil {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(err))
 			return
@@ -1 +1,5 @@
 package middlewares
+
+import "github.com/Hello-Storage/hello-storage-proxy/internal/event"
+
+var log = event.Log
@@ -3,13 +3,51 @@ package query
 import (
 	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
 )
 
-// FindApiKeyByUserID finds an API key by the user ID.
-func FindApiKeyByUserID(userID uint) (*entity.ApiKey, error) {
-	var apiKey entity.ApiKey
-	if err := db.Db().Where("user_id = ?", userID).First(&apiKey).Error; err != nil {
+// FindApiKeysByUserID returns the API keys of a user, newest first.
+func FindApiKeysByUserID(userID uint) (keys entity.ApiKeys, err error) {
+	err = db.Db().Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// FindApiKeyByID returns an API key of a user.
+func FindApiKeyByID(id, userID uint) (*entity.ApiKey, error) {
+	var m entity.ApiKey
+	if err := db.Db().Where("id = ? AND user_id = ?", id, userID).First(&m).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// FindApiKey finds an API key by its value.
+func FindApiKey(apiKey string) (*entity.ApiKey, error) {
+	var m entity.ApiKey
+
+	if err := db.Db().Where("key_hash = ?", entity.HashApiKey(apiKey)).First(&m).Error; err != nil {
 		return nil, err
 	}
-	return &apiKey, nil
+
+	return &m, nil
+}
+
+// FindApiKeyFiles returns a page of the files uploaded with an API key that
+// the user still owns, newest first, and the number of such files.
+func FindApiKeyFiles(apiKeyID uint, offset, limit int) (files entity.Files, total int64, err error) {
+	stmt := db.Db().Model(&entity.File{}).
+		Joins("JOIN api_key_files ON api_key_files.file_id = files.id").
+		Joins("JOIN files_users ON files_users.file_id = files.id AND files_users.user_id = api_key_files.user_id").
+		Where("api_key_files.api_key_id = ? AND files_users.permission = ?", apiKeyID, entity.OwnerPermission).
+		Session(&gorm.Session{})
+
+	if err := stmt.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if err := stmt.Order("api_key_files.id DESC").Offset(offset).Limit(limit).Find(&files).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return files, total, nil
 }
@@ -0,0 +1,122 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+)
+
+// TxLockBlob serializes changes to the blob with the given CID until the
+// transaction ends.
+func TxLockBlob(tx *gorm.DB, cid string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "blob:"+cid).Error
+}
+
+// FindBlobByCID returns the blob stored under a CID.
+func FindBlobByCID(cid string) (*entity.Blob, error) {
+	return TxFindBlobByCID(db.Db(), cid)
+}
+
+// TxFindBlobByCID returns the blob stored under a CID as part of the transaction tx.
+func TxFindBlobByCID(tx *gorm.DB, cid string) (*entity.Blob, error) {
+	m := &entity.Blob{}
+
+	if err := tx.Where("c_id = ?", cid).First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// txCountFileRefs counts the owners of files referencing a CID, which is the
+// reference count of objects uploaded before blobs were tracked.
+func txCountFileRefs(tx *gorm.DB, cid string) (count int64, err error) {
+	err = tx.Table("files_users").
+		Joins("JOIN files ON files.id = files_users.file_id").
+		Where("files.c_id = ? AND files.deleted_at IS NULL AND files_users.permission = ?", cid, entity.OwnerPermission).
+		Count(&count).Error
+	return count, err
+}
+
+// TxCountBlobRefs returns the number of owners of files referencing the
+// content stored under a CID. The caller must hold the blob lock.
+func TxCountBlobRefs(tx *gorm.DB, cid string) (int64, error) {
+	m, err := TxFindBlobByCID(tx, cid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return txCountFileRefs(tx, cid)
+	} else if err != nil {
+		return 0, err
+	}
+
+	return m.RefCount, nil
+}
+
+// TxAcquireBlob adds a reference to the blob stored under a CID, creating the
+// blob if needed. Each owner of a file referencing the blob holds a reference.
+// The caller must hold the blob lock, and must call it before creating the
+// file or the owner that references the blob.
+func TxAcquireBlob(tx *gorm.DB, cid string, size int64) (*entity.Blob, error) {
+	m, err := TxFindBlobByCID(tx, cid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		refs, err := txCountFileRefs(tx, cid)
+		if err != nil {
+			return nil, err
+		}
+
+		m = &entity.Blob{CID: cid, Size: size, RefCount: refs + 1}
+		return m, m.TxCreate(tx)
+	} else if err != nil {
+		return nil, err
+	}
+
+	m.RefCount++
+	return m, tx.Model(m).UpdateColumn("ref_count", gorm.Expr("ref_count + 1")).Error
+}
+
+// TxVerifyBlob marks the blob stored under a CID as verified, with the size of
+// the verified content. It returns gorm.ErrRecordNotFound if no blob is stored
+// under the CID. The caller must hold the blob lock.
+func TxVerifyBlob(tx *gorm.DB, cid string, size int64) error {
+	res := tx.Model(&entity.Blob{}).Where("c_id = ?", cid).UpdateColumns(map[string]interface{}{
+		"verified": true,
+		"size":     size,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("blob %s: %w", cid, gorm.ErrRecordNotFound)
+	}
+
+	return nil
+}
+
+// TxReleaseBlob removes a reference to the blob stored under a CID and returns
+// the number of references left. The blob is removed when none are left, and
+// the caller must then delete the object. The caller must hold the blob lock,
+// and must call it after deleting the file or the owner that referenced the
+// blob.
+func TxReleaseBlob(tx *gorm.DB, cid string) (int64, error) {
+	m, err := TxFindBlobByCID(tx, cid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return txCountFileRefs(tx, cid)
+	} else if err != nil {
+		return 0, err
+	}
+
+	if m.RefCount > 1 {
+		return m.RefCount - 1, tx.Model(m).UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error
+	}
+
+	return 0, tx.Delete(m).Error
+}
+
+// CountPhysicalStorageUsed returns the bytes stored, counting shared objects once.
+func CountPhysicalStorageUsed() (used int64, err error) {
+	err = db.Db().Model(&entity.Blob{}).Select("COALESCE(SUM(size), 0)").Scan(&used).Error
+	return used, err
+}
@@ -0,0 +1,28 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestTxVerifyBlob(t *testing.T) {
+	testdb.Open(t)
+
+	const cid = "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"
+
+	// content without a blob cannot be verified
+	require.ErrorIs(t, TxVerifyBlob(db.Db(), cid, 11), gorm.ErrRecordNotFound)
+
+	_, err := TxAcquireBlob(db.Db(), cid, 5)
+	require.NoError(t, err)
+	require.NoError(t, TxVerifyBlob(db.Db(), cid, 11))
+
+	m, err := FindBlobByCID(cid)
+	require.NoError(t, err)
+	require.True(t, m.Verified)
+	require.Equal(t, int64(11), m.Size)
+}
@@ -5,12 +5,8 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
-	"github.com/Hello-Storage/hello-storage-proxy/pkg/s3"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/davecgh/go-spew/spew"
 	"gorm.io/gorm"
 )
@@ -214,7 +210,7 @@ func FindUsersByFileCID(cid string) ([]uint, error) {
 		Table("files_users").
 		Select("files_users.user_id").
 		Joins("JOIN files ON files.id = files_users.file_id").
-		Where("files.c_id = ? AND files.deleted_at IS NULL", cid).
+		Where("files.c_id = ? AND files.deleted_at IS NULL AND files_users.permission != ?", cid, entity.DeletedPermission).
 		Find(&fileUsers).Error
 
 	if err != nil {
@@ -229,6 +225,8 @@ func FindUsersByFileCID(cid string) ([]uint, error) {
 	return usersWF, nil
 }
 
+// FindFilesByUserAndFileCID returns the files with a CID a user has access
+// to, excluding files the user deleted.
 func FindFilesByUserAndFileCID(userID uint, cid string) ([]entity.File, error) {
 	var files []entity.File
 
@@ -238,6 +236,7 @@ func FindFilesByUserAndFileCID(userID uint, cid string) ([]entity.File, error) {
 		Select("files.*").
 		Joins("JOIN files ON files.id = files_users.file_id").
 		Where("files_users.user_id = ? AND files.c_id = ? AND files.deleted_at IS NULL", userID, cid).
+		Where("files_users.permission != ?", entity.DeletedPermission).
 		Find(&files).Error
 
 	if err != nil {
@@ -254,9 +253,50 @@ func DeleteFileByUID(tx *gorm.DB, file_uid string) error {
 	}
 
 	// Get file_shared_state and delete it
-	DeleteFileShareState(db.Db(), file_uid)
+	DeleteFileShareState(tx, file_uid)
 
-	return db.Db().Where("uid = ?", file_uid).Delete(&entity.File{}).Error
+	result := tx.Where("uid = ?", file_uid).Delete(&entity.File{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// the file was deleted concurrently
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// FindDeletedFileByUID returns a deleted file that can still be restored.
+func FindDeletedFileByUID(uid string) (*entity.File, error) {
+	var file entity.File
+
+	if err := db.Db().Unscoped().
+		Where("uid = ? AND deleted_at IS NOT NULL", uid).
+		First(&file).Error; err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// TxRestoreFile undeletes a deleted file.
+func TxRestoreFile(tx *gorm.DB, file *entity.File) error {
+	result := tx.Unscoped().Model(file).
+		Where("deleted_at IS NOT NULL").
+		UpdateColumn("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// the file was restored concurrently
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	file.DeletedAt = gorm.DeletedAt{}
+	return nil
 }
 
 // query for count all txt files
@@ -556,74 +596,6 @@ func DeleteEmptyShareGroup(tx *gorm.DB, shareGroupHash string) error {
 	return nil
 }
 
-func GetApiFiles(user_id uint) (files entity.Files, err error) {
-	var apiFiles []entity.ApiKeyFile
-	if err := db.Db().Where("user_id = ?", user_id).Find(&apiFiles).Error; err != nil {
-		return nil, err
-	}
-
-	var fileIDs []uint
-	for _, apiFile := range apiFiles {
-		fileIDs = append(fileIDs, apiFile.FileID)
-	}
-
-	if err := db.Db().Where("id IN ?", fileIDs).Find(&files).Error; err != nil {
-		return nil, err
-	}
-
-	return files, nil
-}
-
-// FindFilesNotInPool returns files that are not in the pool.
-func FindFilesNotInPool() (files entity.Files, err error) {
-	var allFiles []entity.File
-	//get all files
-	if err := db.Db().Find(&allFiles).Error; err != nil {
-		return nil, err
-	}
-
-	var filesNotInPool entity.Files
-	// Create a map to track CIDs
-	cidChecked := make(map[string]bool)
-
-	s3Config := aws.Config{
-		Credentials: credentials.NewStaticCredentials(
-			config.Env().StorageAccessKey,
-			config.Env().StorageSecretKey,
-			"",
-		),
-		Endpoint:         aws.String(config.Env().StorageEndpoint),
-		Region:           aws.String(config.Env().StorageRegion),
-		S3ForcePathStyle: aws.Bool(true),
-	}
-
-	totalFiles := len(allFiles)
-	log.Printf("Total files: %d", totalFiles)
-
-	for i, file := range allFiles {
-		if _, checked := cidChecked[file.CID]; !checked {
-			_, err := s3.HeadObject(s3Config, config.Env().StorageBucket, file.CID)
-			cidChecked[file.CID] = (err == nil) // true if exists in S3, false otherwise
-
-			if i%100 == 0 || i == totalFiles-1 {
-				log.Printf("S3 Check Progress: %d of %d files checked", i+1, totalFiles)
-
-			}
-		}
-	}
-
-	log.Printf("S3 check completed. %d files checked.", totalFiles)
-
-	// Create a list of files that are not in the pool
-	for _, file := range allFiles {
-		if !cidChecked[file.CID] {
-			filesNotInPool = append(filesNotInPool, file)
-		}
-	}
-
-	return filesNotInPool, nil
-}
-
 // get if file is in a shared folder or not
 func IsInSharedFolder(fileRoot string, userID uint) bool {
 
@@ -664,3 +636,50 @@ func IsInSharedFolder(fileRoot string, userID uint) bool {
 	// else return false
 	return false
 }
+
+// FindFileUser returns the relation between a file and a user, ignoring
+// relations that were marked as deleted.
+func FindFileUser(fileID, userID uint) (*entity.FileUser, error) {
+	fu := &entity.FileUser{}
+	if err := db.Db().
+		Where("file_id = ? AND user_id = ? AND permission != ?", fileID, userID, entity.DeletedPermission).
+		First(fu).Error; err != nil {
+		return nil, err
+	}
+	return fu, nil
+}
+
+// TxFindFileUser returns the relation between a file and a user as part of
+// the transaction tx, including relations that were marked as deleted.
+func TxFindFileUser(tx *gorm.DB, fileID, userID uint) (*entity.FileUser, error) {
+	fu := &entity.FileUser{}
+	if err := tx.Where("file_id = ? AND user_id = ?", fileID, userID).First(fu).Error; err != nil {
+		return nil, err
+	}
+	return fu, nil
+}
+
+// TxCountFileOwners returns the number of users owning a file.
+func TxCountFileOwners(tx *gorm.DB, fileID uint) (count int64, err error) {
+	err = tx.Model(&entity.FileUser{}).
+		Where("file_id = ? AND permission = ?", fileID, entity.OwnerPermission).
+		Count(&count).Error
+	return count, err
+}
+
+// TxFindDuplicateFile returns the file of a user a new file of the user would
+// duplicate, which has the same content, name and parent folder. Files the
+// user deleted but are kept for other owners are included, files shared with
+// the user are not.
+func TxFindDuplicateFile(tx *gorm.DB, file *entity.File, userID uint) (*entity.File, error) {
+	f := &entity.File{}
+	if err := tx.
+		Joins("JOIN files_users ON files_users.file_id = files.id").
+		Where("files.c_id = ? AND files.name = ? AND files.root = ?", file.CID, file.Name, file.Root).
+		Where("files_users.user_id = ? AND files_users.permission IN (?, ?)", userID,
+			entity.OwnerPermission, entity.DeletedPermission).
+		Order("files.id").First(f).Error; err != nil {
+		return nil, err
+	}
+	return f, nil
+}
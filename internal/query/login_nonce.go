@@ -0,0 +1,27 @@
+package query
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+)
+
+// DeleteExpiredLoginNonces deletes the nonces of an address that expired.
+func DeleteExpiredLoginNonces(address string) error {
+	return db.Db().
+		Where("address = ? AND expires_at < ?", strings.ToLower(address), time.Now()).
+		Delete(&entity.LoginNonce{}).Error
+}
+
+// TxConsumeLoginNonce marks the nonce issued for an address as used. It
+// returns false if there is no such nonce, or if it was used or has expired.
+func TxConsumeLoginNonce(tx *gorm.DB, nonce, address string) (bool, error) {
+	res := tx.Model(&entity.LoginNonce{}).
+		Where("nonce = ? AND address = ? AND used_at IS NULL AND expires_at > ?", nonce, strings.ToLower(address), time.Now()).
+		Update("used_at", time.Now())
+
+	return res.RowsAffected == 1, res.Error
+}
@@ -0,0 +1,69 @@
+package query
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+)
+
+// activeMultipartStatuses are the statuses of uploads whose parts the backend
+// still holds.
+var activeMultipartStatuses = []entity.MultipartUploadStatus{entity.MultipartInProgress, entity.MultipartCompleting}
+
+func preloadParts(tx *gorm.DB) *gorm.DB {
+	return tx.Order("part_number ASC")
+}
+
+// FindMultipartUpload returns the multipart upload with the given UID started by a user.
+func FindMultipartUpload(uid string, userID uint) (*entity.MultipartUpload, error) {
+	m := &entity.MultipartUpload{}
+
+	if err := db.Db().Preload("Parts", preloadParts).
+		Where("uid = ? AND user_id = ?", uid, userID).
+		First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// FindMultipartUploadsInProgress returns the multipart uploads a user can still resume.
+func FindMultipartUploadsInProgress(userID uint) (uploads entity.MultipartUploads, err error) {
+	if err := db.Db().Preload("Parts", preloadParts).
+		Where("user_id = ? AND status = ?", userID, entity.MultipartInProgress).
+		Order("created_at DESC").
+		Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// FindStaleMultipartUploads returns uploads in progress or being completed
+// without activity since the given time.
+func FindStaleMultipartUploads(before time.Time, limit int) (uploads entity.MultipartUploads, err error) {
+	if err := db.Db().
+		Where("status IN ? AND updated_at < ?", activeMultipartStatuses, before).
+		Order("updated_at ASC").
+		Limit(limit).
+		Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// IsMultipartUploadTracked reports whether an upload id belongs to an upload
+// in progress or being completed.
+func IsMultipartUploadTracked(uploadID string) (bool, error) {
+	var count int64
+	if err := db.Db().Model(&entity.MultipartUpload{}).
+		Where("upload_id = ? AND status IN ?", uploadID, activeMultipartStatuses).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,54 @@
+package query
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+)
+
+// FindOIDCIdentity returns the identity of a subject of an OpenID Connect
+// provider.
+func FindOIDCIdentity(provider, subject string) (*entity.OIDCIdentity, error) {
+	m := &entity.OIDCIdentity{}
+
+	if err := db.Db().Where("provider = ? AND subject = ?", provider, subject).First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// DeleteExpiredOIDCAuthRequests deletes the logins that were not finished in
+// time.
+func DeleteExpiredOIDCAuthRequests() error {
+	return db.Db().Where("expires_at < ?", time.Now()).Delete(&entity.OIDCAuthRequest{}).Error
+}
+
+// TxTakeOIDCAuthRequest deletes and returns the login at a provider with a
+// state, started by the client with the binding. It returns
+// gorm.ErrRecordNotFound if there is no such login, if it was already
+// finished or has expired, or if another client returned with its state, in
+// which case the login is kept for the client that started it.
+func TxTakeOIDCAuthRequest(tx *gorm.DB, provider, state, binding string) (*entity.OIDCAuthRequest, error) {
+	m := &entity.OIDCAuthRequest{}
+
+	err := tx.Where("state = ? AND provider = ? AND binding_hash = ? AND expires_at > ?",
+		state, provider, entity.HashOIDCBinding(binding), time.Now()).First(m).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// only the request deleting the login may finish it
+	res := tx.Delete(m)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return m, nil
+}
@@ -0,0 +1,43 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestTxTakeOIDCAuthRequest(t *testing.T) {
+	testdb.Open(t)
+
+	for _, m := range []*entity.OIDCAuthRequest{
+		{State: "login", Provider: "gitlab", BindingHash: entity.HashOIDCBinding("binding"), ExpiresAt: time.Now().Add(time.Minute)},
+		{State: "expired", Provider: "gitlab", BindingHash: entity.HashOIDCBinding("binding"), ExpiresAt: time.Now().Add(-time.Second)},
+	} {
+		require.NoError(t, m.Create())
+	}
+
+	// the login is kept if another client or provider returns with its state
+	_, err := TxTakeOIDCAuthRequest(db.Db(), "gitlab", "login", "other")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = TxTakeOIDCAuthRequest(db.Db(), "keycloak", "login", "binding")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = TxTakeOIDCAuthRequest(db.Db(), "gitlab", "expired", "binding")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// logins are single-use
+	m, err := TxTakeOIDCAuthRequest(db.Db(), "gitlab", "login", "binding")
+	require.NoError(t, err)
+	require.Equal(t, "login", m.State)
+	_, err = TxTakeOIDCAuthRequest(db.Db(), "gitlab", "login", "binding")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	require.NoError(t, DeleteExpiredOIDCAuthRequests())
+	var count int64
+	require.NoError(t, db.Db().Model(&entity.OIDCAuthRequest{}).Count(&count).Error)
+	require.Zero(t, count)
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TxConsumeOtpChallenges consumes the open challenges of an email, so that
+// only the code sent last can be used.
+func TxConsumeOtpChallenges(tx *gorm.DB, email string) error {
+	return tx.Model(&entity.OtpChallenge{}).
+		Where("email = ? AND consumed_at IS NULL", strings.ToLower(email)).
+		Update("consumed_at", time.Now()).Error
+}
+
+// TxFindOtpChallenge returns the open challenge of an email, locking it until
+// the end of the transaction tx.
+func TxFindOtpChallenge(tx *gorm.DB, email string) (*entity.OtpChallenge, error) {
+	m := &entity.OtpChallenge{}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("email = ? AND consumed_at IS NULL AND expires_at > ?", strings.ToLower(email), time.Now()).
+		Order("created_at DESC").
+		First(m).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// FindOtpLockouts returns the lockout states of an email and an IP address
+// that had failed attempts.
+func FindOtpLockouts(email, ip string) (lockouts []entity.OtpLockout, err error) {
+	err = db.Db().
+		Where("(kind = ? AND key = ?) OR (kind = ? AND key = ?)",
+			entity.OtpLockoutEmail, strings.ToLower(email), entity.OtpLockoutIP, ip).
+		Find(&lockouts).Error
+
+	return lockouts, err
+}
+
+// TxFindOtpLockout returns the lockout state of a key, locking it until the
+// end of the transaction tx. Keys without failed attempts get a new state,
+// which is inserted first, so that concurrent first failures of a key wait
+// for the same row instead of both inserting it.
+func TxFindOtpLockout(tx *gorm.DB, kind, key string) (*entity.OtpLockout, error) {
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "kind"}, {Name: "key"}},
+		DoNothing: true,
+	}).Create(&entity.OtpLockout{Kind: kind, Key: key}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	m := &entity.OtpLockout{}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("kind = ? AND key = ?", kind, key).
+		First(m).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// TxForgiveOtpLockout forgives the failures of a key, if it has a lockout
+// state.
+func TxForgiveOtpLockout(tx *gorm.DB, kind, key string) error {
+	return tx.Model(&entity.OtpLockout{}).
+		Where("kind = ? AND key = ?", kind, key).
+		Updates(map[string]interface{}{
+			"failures":     0,
+			"level":        0,
+			"failed_at":    nil,
+			"locked_until": nil,
+		}).Error
+}
@@ -0,0 +1,65 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+func failOtp(t *testing.T, kind, key string, policy entity.OtpLockoutPolicy) *entity.OtpLockout {
+	t.Helper()
+
+	tx := db.Db().Begin()
+	m, err := TxFindOtpLockout(tx, kind, key)
+	require.NoError(t, err)
+
+	m.Fail(time.Now(), policy)
+	require.NoError(t, m.TxSave(tx))
+	require.NoError(t, tx.Commit().Error)
+
+	return m
+}
+
+func TestTxFindOtpLockout(t *testing.T) {
+	testdb.Open(t)
+
+	policy := entity.OtpLockoutPolicy{Failures: 2, Base: time.Minute, Max: time.Hour}
+
+	// the state of a new key is inserted, and found by later attempts
+	first := failOtp(t, entity.OtpLockoutEmail, "alice@example.com", policy)
+	require.NotZero(t, first.ID)
+	require.Equal(t, 1, first.Failures)
+
+	second := failOtp(t, entity.OtpLockoutEmail, "alice@example.com", policy)
+	require.Equal(t, first.ID, second.ID)
+	require.True(t, second.IsLocked(time.Now()))
+
+	// keys are separate per kind
+	ip := failOtp(t, entity.OtpLockoutIP, "alice@example.com", policy)
+	require.NotEqual(t, first.ID, ip.ID)
+	require.Equal(t, 1, ip.Failures)
+
+	lockouts, err := FindOtpLockouts("alice@example.com", "127.0.0.1")
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1)
+
+	// a success forgives the key, without creating states of unknown keys
+	tx := db.Db().Begin()
+	require.NoError(t, TxForgiveOtpLockout(tx, entity.OtpLockoutEmail, "alice@example.com"))
+	require.NoError(t, TxForgiveOtpLockout(tx, entity.OtpLockoutEmail, "bob@example.com"))
+	require.NoError(t, tx.Commit().Error)
+
+	lockouts, err = FindOtpLockouts("alice@example.com", "127.0.0.1")
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1)
+	require.False(t, lockouts[0].IsLocked(time.Now()))
+	require.Zero(t, lockouts[0].Level)
+
+	var count int64
+	require.NoError(t, db.Db().Model(&entity.OtpLockout{}).Count(&count).Error)
+	require.Equal(t, int64(2), count)
+}
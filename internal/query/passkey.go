@@ -0,0 +1,78 @@
+package query
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+)
+
+// FindPasskeysByUserID returns the passkeys of a user, newest first.
+func FindPasskeysByUserID(userID uint) (passkeys entity.Passkeys, err error) {
+	err = db.Db().Where("user_id = ?", userID).Order("created_at DESC").Find(&passkeys).Error
+	return passkeys, err
+}
+
+// FindPasskeyByCredentialID returns the passkey with a WebAuthn credential id.
+func FindPasskeyByCredentialID(credentialID []byte) (*entity.Passkey, error) {
+	m := &entity.Passkey{}
+
+	if err := db.Db().Where("credential_id = ?", credentialID).First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// DeletePasskey deletes a passkey of a user. It returns gorm.ErrRecordNotFound
+// if the user has no such passkey.
+func DeletePasskey(id, userID uint) error {
+	res := db.Db().Where("id = ? AND user_id = ?", id, userID).Delete(&entity.Passkey{})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpiredPasskeyCeremonies deletes the ceremonies that were not
+// finished in time.
+func DeleteExpiredPasskeyCeremonies() error {
+	return db.Db().Where("expires_at < ?", time.Now()).Delete(&entity.PasskeyCeremony{}).Error
+}
+
+// TxTakePasskeyCeremony deletes and returns the ceremony with a token, which
+// must have been started by the user, or be a login if userID is nil. It
+// returns gorm.ErrRecordNotFound if there is no such ceremony, or if it was
+// already finished or has expired.
+func TxTakePasskeyCeremony(tx *gorm.DB, token string, userID *uint) (*entity.PasskeyCeremony, error) {
+	m := &entity.PasskeyCeremony{}
+
+	stmt := tx.Where("token = ? AND expires_at > ?", token, time.Now())
+	if userID != nil {
+		stmt = stmt.Where("user_id = ?", *userID)
+	} else {
+		stmt = stmt.Where("user_id IS NULL")
+	}
+
+	if err := stmt.First(m).Error; err != nil {
+		return nil, err
+	}
+
+	// only the request deleting the ceremony may finish it
+	res := tx.Delete(m)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return m, nil
+}
@@ -0,0 +1,63 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func takePasskeyCeremony(t *testing.T, token string, userID *uint) error {
+	t.Helper()
+
+	tx := db.Db().Begin()
+	if _, err := TxTakePasskeyCeremony(tx, token, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func TestTxTakePasskeyCeremony(t *testing.T) {
+	testdb.Open(t)
+
+	userID, otherID := uint(1), uint(2)
+	for _, c := range []*entity.PasskeyCeremony{
+		{Token: "register", UserID: &userID, ExpiresAt: time.Now().Add(time.Minute)},
+		{Token: "login", ExpiresAt: time.Now().Add(time.Minute)},
+		{Token: "expired", ExpiresAt: time.Now().Add(-time.Second)},
+	} {
+		require.NoError(t, c.Create())
+	}
+
+	// a registration is only finished by the user who started it, and a
+	// login ceremony cannot finish a registration
+	require.ErrorIs(t, takePasskeyCeremony(t, "register", &otherID), gorm.ErrRecordNotFound)
+	require.ErrorIs(t, takePasskeyCeremony(t, "register", nil), gorm.ErrRecordNotFound)
+	require.ErrorIs(t, takePasskeyCeremony(t, "login", &userID), gorm.ErrRecordNotFound)
+	require.ErrorIs(t, takePasskeyCeremony(t, "expired", nil), gorm.ErrRecordNotFound)
+
+	// ceremonies are single-use
+	require.NoError(t, takePasskeyCeremony(t, "register", &userID))
+	require.ErrorIs(t, takePasskeyCeremony(t, "register", &userID), gorm.ErrRecordNotFound)
+	require.NoError(t, takePasskeyCeremony(t, "login", nil))
+	require.ErrorIs(t, takePasskeyCeremony(t, "login", nil), gorm.ErrRecordNotFound)
+
+	// a ceremony is kept if the request taking it fails
+	require.NoError(t, (&entity.PasskeyCeremony{Token: "retry", ExpiresAt: time.Now().Add(time.Minute)}).Create())
+	tx := db.Db().Begin()
+	_, err := TxTakePasskeyCeremony(tx, "retry", nil)
+	require.NoError(t, err)
+	tx.Rollback()
+	require.NoError(t, takePasskeyCeremony(t, "retry", nil))
+
+	require.NoError(t, DeleteExpiredPasskeyCeremonies())
+	var count int64
+	require.NoError(t, db.Db().Model(&entity.PasskeyCeremony{}).Count(&count).Error)
+	require.Zero(t, count)
+}
@@ -0,0 +1,22 @@
+package query
+
+import (
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+)
+
+// FindPresignedUpload returns the presigned upload a user was issued for the
+// object key content is staged under.
+func FindPresignedUpload(objectKey string, userID uint) (*entity.PresignedURLLog, error) {
+	m := &entity.PresignedURLLog{}
+
+	if err := db.Db().
+		Where("object_key = ? AND user_id = ? AND method = ?", objectKey, userID, http.MethodPut).
+		First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,95 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+)
+
+// TryLockReconciliation takes the lock that only one reconciliation run holds
+// at a time, across all instances. The lock is held by a connection of its
+// own, so it is released if the instance dies. It returns the function that
+// releases it, or nil if another run holds it.
+func TryLockReconciliation(ctx context.Context) (unlock func(), err error) {
+	sqlDB, err := db.Db().DB()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext('reconciliation'))").Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if !locked {
+		conn.Close()
+		return nil, nil
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext('reconciliation'))"); err != nil {
+			// discarding the connection releases the lock
+			conn.Raw(func(driverConn any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// FileCID is a CID with the number of files referencing it.
+type FileCID struct {
+	CID   string `gorm:"column:c_id"`
+	Files int64
+}
+
+// FindFileCIDsAfter returns the CIDs referenced by files in ascending order,
+// starting after the given CID, so that all of them can be paged through.
+func FindFileCIDsAfter(after string, limit int) (cids []FileCID, err error) {
+	err = db.Db().Model(&entity.File{}).
+		Select("c_id, COUNT(*) AS files").
+		Where("c_id > ?", after).
+		Group("c_id").
+		Order("c_id ASC").
+		Limit(limit).
+		Scan(&cids).Error
+
+	return cids, err
+}
+
+// FindReferencedCIDs returns the given CIDs that are referenced by files.
+func FindReferencedCIDs(cids []string) (referenced []string, err error) {
+	err = db.Db().Model(&entity.File{}).
+		Distinct("c_id").
+		Where("c_id IN ?", cids).
+		Pluck("c_id", &referenced).Error
+
+	return referenced, err
+}
+
+// UpdateFilesInPool sets whether the content of the files with the given CIDs
+// is stored in the pool.
+func UpdateFilesInPool(cids []string, inPool bool) error {
+	if len(cids) == 0 {
+		return nil
+	}
+
+	return db.Db().Model(&entity.File{}).
+		Where("c_id IN ? AND is_in_pool IS DISTINCT FROM ?", cids, inPool).
+		UpdateColumn("is_in_pool", inPool).Error
+}
+
+// CreateReconciliationIssues records issues found by a reconciliation run.
+func CreateReconciliationIssues(issues []entity.ReconciliationIssue) error {
+	if len(issues) == 0 {
+		return nil
+	}
+
+	return db.Db().Create(&issues).Error
+}
@@ -0,0 +1,66 @@
+package query
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FindSession returns the session of a refresh token.
+func FindSession(tokenID uuid.UUID) (*entity.Session, error) {
+	m := &entity.Session{}
+
+	if err := db.Db().Where("id = ?", tokenID).First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// FindActiveSessionsByUserID returns the sessions of a user that can still be
+// renewed, most recently renewed first. Each session family has at most one.
+func FindActiveSessionsByUserID(user_id uint) (sessions entity.Sessions, err error) {
+	err = db.Db().
+		Where("user_id = ? AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > ?", user_id, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error
+
+	return sessions, err
+}
+
+// TxRotateSession marks the refresh token of a session as used. It returns
+// false if the token was already used or revoked.
+func TxRotateSession(tx *gorm.DB, tokenID uuid.UUID) (bool, error) {
+	res := tx.Model(&entity.Session{}).
+		Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", tokenID).
+		Update("rotated_at", time.Now())
+
+	return res.RowsAffected == 1, res.Error
+}
+
+// RevokeSessionFamily revokes every refresh token of a user's session family.
+// It returns gorm.ErrRecordNotFound if the user has no such session that is
+// not revoked yet.
+func RevokeSessionFamily(familyID uuid.UUID, user_id uint) error {
+	return TxRevokeSessionFamily(db.Db(), familyID, user_id)
+}
+
+// TxRevokeSessionFamily revokes every refresh token of a user's session
+// family as part of the transaction tx, see RevokeSessionFamily.
+func TxRevokeSessionFamily(tx *gorm.DB, familyID uuid.UUID, user_id uint) error {
+	res := tx.Model(&entity.Session{}).
+		Where("family_id = ? AND user_id = ? AND revoked_at IS NULL", familyID, user_id).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
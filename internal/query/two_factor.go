@@ -0,0 +1,71 @@
+package query
+
+import (
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FindTwoFactor returns the second factor a user enrolled.
+func FindTwoFactor(userID uint) (*entity.TwoFactor, error) {
+	return TxFindTwoFactor(db.Db(), userID)
+}
+
+// TxFindTwoFactor returns the second factor a user enrolled, locking it until
+// the end of the transaction tx.
+func TxFindTwoFactor(tx *gorm.DB, userID uint) (*entity.TwoFactor, error) {
+	m := &entity.TwoFactor{}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// TxDeleteTwoFactor deletes the second factor of a user and their recovery
+// codes.
+func TxDeleteTwoFactor(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&entity.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("user_id = ?", userID).Delete(&entity.TwoFactor{}).Error
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of a user.
+func CountRecoveryCodes(userID uint) (count int64, err error) {
+	err = db.Db().Model(&entity.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+
+	return count, err
+}
+
+// TxUseRecoveryCode marks an unused recovery code of a user as used. It
+// returns false if the user has no such code.
+func TxUseRecoveryCode(tx *gorm.DB, userID uint, code string) (bool, error) {
+	res := tx.Model(&entity.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, entity.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+
+	return res.RowsAffected == 1, res.Error
+}
+
+// TxFindTwoFactorChallenge returns an open step-up challenge, locking it
+// until the end of the transaction tx.
+func TxFindTwoFactorChallenge(tx *gorm.DB, token string) (*entity.TwoFactorChallenge, error) {
+	m := &entity.TwoFactorChallenge{}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token = ? AND consumed_at IS NULL AND expires_at > ?", token, time.Now()).
+		First(m).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
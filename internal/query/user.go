@@ -146,7 +146,7 @@ func FindUserByGithub(github_id uint) *entity.User {
 	u := &entity.User{}
 
 	subquery := db.Db().Table("githubs").Select("user_id").Where("github_id = ?", github_id)
-	if err := db.Db().Model(u).Preload("Github").Where("id IN (?)", subquery).First(u).Error; err == nil {
+	if err := db.Db().Model(u).Preload("Wallet").Preload("Github").Where("id IN (?)", subquery).First(u).Error; err == nil {
 		return u
 	} else {
 		return nil
@@ -3,6 +3,8 @@ package query
 import (
 	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func FindUserDetailByUserID(user_id uint) *entity.UserDetail {
@@ -56,3 +58,114 @@ func FindUserDetailByUserUID(user_uid string) *entity.UserDetail {
 
 	return m
 }
+
+// TxAdjustStorageUsed adds delta bytes to the storage used by a user and
+// records the change in the usage ledger. The first change of a user without
+// ledger entries opens the ledger with the counter as it was. The storage used
+// cannot become negative, so a larger refund is recorded as the refund of what
+// was left, which keeps the ledger equal to the counter.
+func TxAdjustStorageUsed(tx *gorm.DB, user_id uint, file_id *uint, delta int64, reason entity.UsageReason) error {
+	detail, err := TxFindUserDetailForUpdate(tx, user_id)
+	if err != nil {
+		return err
+	}
+
+	var opened bool
+	if err := tx.Raw("SELECT EXISTS (SELECT 1 FROM usage_ledger WHERE user_id = ?)", user_id).Scan(&opened).Error; err != nil {
+		return err
+	}
+
+	if !opened {
+		if err := TxOpenUsageLedger(tx, detail); err != nil {
+			return err
+		}
+	}
+
+	if delta < -int64(detail.StorageUsed) {
+		log.Warnf("usage: refund of %d bytes to user %d exceeds the %d bytes used", -delta, user_id, detail.StorageUsed)
+		delta = -int64(detail.StorageUsed)
+	}
+
+	entry := entity.UsageEntry{UserID: user_id, FileID: file_id, Delta: delta, Reason: reason}
+	if err := entry.TxCreate(tx); err != nil {
+		return err
+	}
+
+	return tx.Model(detail).
+		UpdateColumn("storage_used", gorm.Expr("storage_used + ?", delta)).
+		Error
+}
+
+// TxOpenUsageLedger records the storage used by a user without ledger entries
+// as the opening entry of the ledger.
+func TxOpenUsageLedger(tx *gorm.DB, detail *entity.UserDetail) error {
+	if detail.StorageUsed == 0 {
+		return nil
+	}
+
+	opening := entity.UsageEntry{UserID: detail.UserID, Delta: int64(detail.StorageUsed), Reason: entity.UsageOpening}
+	return opening.TxCreate(tx)
+}
+
+// TxSetStorageUsed overwrites the storage used by a user.
+func TxSetStorageUsed(tx *gorm.DB, detail *entity.UserDetail, used uint) error {
+	return tx.Model(detail).UpdateColumn("storage_used", used).Error
+}
+
+// TxSumUsageLedger returns the storage used by a user according to the usage
+// ledger, and whether the user has any ledger entries.
+func TxSumUsageLedger(tx *gorm.DB, user_id uint) (used int64, opened bool, err error) {
+	var result struct {
+		Used    int64
+		Entries int64
+	}
+
+	if err := tx.Model(&entity.UsageEntry{}).
+		Select("COALESCE(SUM(delta), 0) AS used, COUNT(*) AS entries").
+		Where("user_id = ?", user_id).
+		Scan(&result).Error; err != nil {
+		return 0, false, err
+	}
+
+	return result.Used, result.Entries > 0, nil
+}
+
+// FindUserIDsWithDetailsAfter returns the ids of users with details in
+// ascending order, starting after the given id.
+func FindUserIDsWithDetailsAfter(after uint, limit int) (ids []uint, err error) {
+	err = db.Db().Model(&entity.UserDetail{}).
+		Where("user_id > ?", after).
+		Order("user_id ASC").
+		Limit(limit).
+		Pluck("user_id", &ids).Error
+
+	return ids, err
+}
+
+// TxFindUserDetailForUpdate returns the details of a user, locking them until
+// the transaction tx ends.
+func TxFindUserDetailForUpdate(tx *gorm.DB, user_id uint) (*entity.UserDetail, error) {
+	m := &entity.UserDetail{}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", user_id).
+		First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// TxFindPlanByUserID returns the plan a user is subscribed to.
+func TxFindPlanByUserID(tx *gorm.DB, user_id uint) (*entity.Plan, error) {
+	m := &entity.Plan{}
+
+	if err := tx.Joins("JOIN subscriptions ON subscriptions.plan_id = plans.id").
+		Where("subscriptions.user_id = ?", user_id).
+		Order("plans.capacity DESC").
+		First(m).Error; err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
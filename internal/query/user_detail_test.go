@@ -0,0 +1,64 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+func adjustStorageUsed(t *testing.T, userID uint, delta int64, reason entity.UsageReason) {
+	tx := db.Db().Begin()
+	require.NoError(t, TxAdjustStorageUsed(tx, userID, nil, delta, reason))
+	require.NoError(t, tx.Commit().Error)
+}
+
+func requireUsage(t *testing.T, userID uint, used uint) {
+	t.Helper()
+
+	require.Equal(t, used, FindUserDetailByUserID(userID).StorageUsed)
+
+	sum, opened, err := TxSumUsageLedger(db.Db(), userID)
+	require.NoError(t, err)
+	require.True(t, opened)
+	require.Equal(t, int64(used), sum)
+}
+
+func TestTxAdjustStorageUsed(t *testing.T) {
+	testdb.Open(t)
+
+	// usage recorded before the ledger
+	require.NoError(t, db.Db().Create(&entity.UserDetail{UserID: 1, StorageUsed: 100}).Error)
+
+	adjustStorageUsed(t, 1, 50, entity.UsageFileCreated)
+	requireUsage(t, 1, 150)
+
+	adjustStorageUsed(t, 1, -30, entity.UsageFileDeleted)
+	requireUsage(t, 1, 120)
+
+	// refunds cannot exceed the storage used
+	adjustStorageUsed(t, 1, -200, entity.UsageFileDeleted)
+	requireUsage(t, 1, 0)
+
+	var entries []entity.UsageEntry
+	require.NoError(t, db.Db().Where("user_id = ?", 1).Order("id").Find(&entries).Error)
+	require.Len(t, entries, 4)
+	require.Equal(t, entity.UsageOpening, entries[0].Reason)
+	require.Equal(t, int64(100), entries[0].Delta)
+	require.Equal(t, int64(-120), entries[3].Delta)
+}
+
+func TestTxAdjustStorageUsedWithoutUsage(t *testing.T) {
+	testdb.Open(t)
+
+	require.NoError(t, db.Db().Create(&entity.UserDetail{UserID: 1}).Error)
+
+	adjustStorageUsed(t, 1, 10, entity.UsageFileCreated)
+	requireUsage(t, 1, 10)
+
+	var entries int64
+	require.NoError(t, db.Db().Model(&entity.UsageEntry{}).Where("reason = ?", entity.UsageOpening).Count(&entries).Error)
+	require.Zero(t, entries)
+}
@@ -0,0 +1,121 @@
+/*
+Package quota enforces the storage capacity of users.
+
+The capacity of a user is the capacity of their subscription plan, or the
+configured default if they have none, plus the storage earned through
+referrals. Usage is the storage_used counter of the user details.
+*/
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"gorm.io/gorm"
+)
+
+// DefaultPlan is the plan name reported for users without a subscription.
+const DefaultPlan = "free"
+
+// Quota is the storage capacity and usage of a user, in bytes.
+type Quota struct {
+	Plan      string `json:"plan"`
+	Capacity  int64  `json:"capacity"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+}
+
+// ExceededError is returned when storing more bytes would exceed a user's capacity.
+type ExceededError struct {
+	Quota
+	Requested int64 `json:"requested"`
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d bytes requested, %d of %d bytes remaining", e.Requested, e.Remaining, e.Capacity)
+}
+
+// Status returns the http status to respond with: 413 if the request could
+// never fit into the capacity, 507 if it does not fit into what is left.
+func (e *ExceededError) Status() int {
+	if e.Requested > e.Capacity {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusInsufficientStorage
+}
+
+// Find returns the quota of a user.
+func Find(userID uint) (*Quota, error) {
+	detail := query.FindUserDetailByUserID(userID)
+	if detail == nil {
+		return nil, fmt.Errorf("quota: details of user %d not found", userID)
+	}
+
+	return txQuota(db.Db(), detail)
+}
+
+// Check returns an *ExceededError if storing size more bytes would exceed the
+// capacity of a user. It is meant for rejecting requests early; TxCheck
+// enforces the capacity when the bytes are stored.
+func Check(userID uint, size int64) error {
+	q, err := Find(userID)
+	if err != nil {
+		return err
+	}
+
+	return q.check(size)
+}
+
+// TxCheck returns an *ExceededError if storing size more bytes would exceed
+// the capacity of a user. The user details are locked until the transaction
+// tx ends, so concurrent uploads cannot exceed the capacity together; the
+// caller charges the bytes with query.TxAdjustStorageUsed.
+func TxCheck(tx *gorm.DB, userID uint, size int64) error {
+	detail, err := query.TxFindUserDetailForUpdate(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	q, err := txQuota(tx, detail)
+	if err != nil {
+		return err
+	}
+
+	return q.check(size)
+}
+
+func (q *Quota) check(size int64) error {
+	if size > 0 && size > q.Remaining {
+		return &ExceededError{Quota: *q, Requested: size}
+	}
+
+	return nil
+}
+
+// txQuota computes the quota of the user with the given details.
+func txQuota(tx *gorm.DB, detail *entity.UserDetail) (*Quota, error) {
+	q := &Quota{
+		Plan:     DefaultPlan,
+		Capacity: config.Env().DefaultStorageCapacity,
+		Used:     int64(detail.StorageUsed),
+	}
+
+	plan, err := query.TxFindPlanByUserID(tx, detail.UserID)
+	if err == nil {
+		q.Plan = plan.Name
+		q.Capacity = plan.Capacity
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	q.Capacity += int64(detail.ReferralStorage)
+	q.Remaining = max(q.Capacity-q.Used, 0)
+
+	return q, nil
+}
@@ -0,0 +1,39 @@
+package quota
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	q := &Quota{Capacity: 100, Used: 60, Remaining: 40}
+
+	testCases := []struct {
+		name   string
+		size   int64
+		status int
+	}{
+		{"fits", 40, 0},
+		{"shrinks", -10, 0},
+		{"exceeds remaining", 41, http.StatusInsufficientStorage},
+		{"exceeds capacity", 101, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := q.check(tc.size)
+			if tc.status == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			var exceeded *ExceededError
+			require.True(t, errors.As(err, &exceeded))
+			require.Equal(t, tc.status, exceeded.Status())
+			require.Equal(t, tc.size, exceeded.Requested)
+		})
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
 	"github.com/Hello-Storage/hello-storage-proxy/internal/middlewares"
 
-	//"github.com/Hello-Storage/hello-storage-proxy/pkg/s3"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
 	"github.com/Hello-Storage/hello-storage-proxy/pkg/token"
 	"github.com/gin-gonic/gin"
 )
@@ -13,39 +13,61 @@ import (
 func registerRoutes(router *gin.Engine) {
 	var APIv1 *gin.RouterGroup
 	var AuthAPIv1 *gin.RouterGroup
-	tokenMaker, err := token.NewPasetoMaker(config.Env().TokenSymmetricKey)
+	tokenMaker, err := config.TokenMaker()
 	if err != nil {
 		log.Errorf("cannot create token maker: %s", err)
 		panic(err)
 	}
 
+	verifier, err := config.SignatureVerifier()
+	if err != nil {
+		log.Errorf("cannot create signature verifier: %s", err)
+		panic(err)
+	}
+
 	// Create router groups.
 	APIv1 = router.Group("/api")
 	AuthAPIv1 = router.Group("/api")
-	AuthAPIv1.Use(middlewares.AuthMiddleware(tokenMaker))
+	AuthAPIv1.Use(middlewares.ApiKeyMiddleware(tokenMaker), middlewares.AuthMiddleware(tokenMaker))
 
 	// routes
 	api.Ping(APIv1)
 
-	/*
-		s3V2Client, err := s3.GetS3V2Client()
-		if err != nil {
-			log.Errorf("failed to create s3 client: %s", err)
-			panic(err)
-		}
-
-		//api.GeneratePutPresignedObject(s3V2Client, APIv1)
-		//api.GenerateGetPresignedObject(s3V2Client, APIv1)
-	*/
+	// other services verify public tokens with the published keys
+	if public, ok := tokenMaker.(*token.PublicMaker); ok {
+		api.TokenKeys(public, &router.RouterGroup)
+	}
+
+	backend, err := config.Storage()
+	if err != nil {
+		log.Errorf("failed to create storage backend: %s", err)
+		panic(err)
+	}
+
+	// presigned requests of the local storage driver are served by the proxy
+	if local, ok := backend.(*storage.Local); ok {
+		api.LocalStorage(local, APIv1)
+	}
+
 	//api keys routes
 	api.ApiKey(AuthAPIv1, tokenMaker)
 	// auth routes
-	api.LoginUser(APIv1, tokenMaker)
+	api.LoginUser(APIv1, tokenMaker, verifier)
 	api.RenewAccessToken(APIv1, tokenMaker)
+	api.Logout(APIv1, tokenMaker)
+	api.Sessions(AuthAPIv1)
 	api.OAuthGoogle(APIv1, tokenMaker)
+	if github := config.GithubOAuth(); github != nil {
+		api.OAuthGithub(APIv1, tokenMaker, github)
+	}
+	api.OIDC(APIv1, tokenMaker, config.OIDCProviders())
 	api.RequestNonce(APIv1)
 	api.StartOTP(APIv1)
 	api.VerifyOTP(APIv1, tokenMaker)
+	api.PasskeyLogin(APIv1, tokenMaker)
+	api.Passkey(AuthAPIv1)
+	api.VerifyTwoFactor(APIv1, tokenMaker)
+	api.TwoFactor(AuthAPIv1)
 
 	// user routes
 	api.LoadUser(AuthAPIv1)
@@ -53,13 +75,19 @@ func registerRoutes(router *gin.Engine) {
 	api.GetUserDetail(AuthAPIv1)
 
 	// file routes
+	FileRoutes := AuthAPIv1.Group("/file")
+	api.PutUploadFile(backend, FileRoutes)
+	api.DownloadFile(backend, FileRoutes)
+	api.DeleteFile(backend, FileRoutes)
+	api.RestoreFile(backend, FileRoutes)
+	api.GeneratePutPresignedObject(backend, FileRoutes)
+	api.CompletePutPresignedObject(backend, FileRoutes)
+	api.GenerateGetPresignedObject(backend, FileRoutes)
+	api.MultipartUpload(backend, FileRoutes)
+
 	/*
-		FileRoutes := AuthAPIv1.Group("/file")
 		api.GetFile(FileRoutes)
-		api.PutUploadFiles(FileRoutes)
 		api.CreateFile(FileRoutes)
-		api.DeleteFile(FileRoutes)
-		api.DownloadFile(FileRoutes)
 		api.DownloadMultipartFile(FileRoutes)
 		api.UpdateFileRoot(FileRoutes)
 		api.CheckFilesExistInPool(FileRoutes)
@@ -29,7 +29,8 @@ func Start(ctx context.Context) {
 
 	ProtectedRouter := gin.New()
 
-	router.MaxMultipartMemory = 500 << 20
+	// Uploads are streamed to the bucket, so multipart forms are small.
+	router.MaxMultipartMemory = 32 << 20
 
 	protectedCorsConfig := cors.New(cors.Config{
 		AllowOrigins: []string{
@@ -0,0 +1,149 @@
+/*
+Package testdb provides a database for tests of code that uses db.Db().
+
+The database is SQLite, with the PostgreSQL functions the queries use. Its
+transactions take the write lock when they begin, so they are serialized,
+which stands in for transaction advisory locks. Session advisory locks are
+held by the process.
+*/
+package testdb
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const driverName = "sqlite3_postgres"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("hashtext", hashtext, true); err != nil {
+				return err
+			}
+
+			if err := conn.RegisterFunc("greatest", greatest, true); err != nil {
+				return err
+			}
+
+			if err := conn.RegisterFunc("pg_advisory_xact_lock", func(key int64) bool { return true }, false); err != nil {
+				return err
+			}
+
+			if err := conn.RegisterFunc("pg_try_advisory_lock", tryAdvisoryLock, false); err != nil {
+				return err
+			}
+
+			return conn.RegisterFunc("pg_advisory_unlock", advisoryUnlock, false)
+		},
+	})
+}
+
+// hashtext hashes a string to an integer, like the PostgreSQL function.
+func hashtext(s string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int64(int32(h.Sum32()))
+}
+
+// advisoryLocks are the session advisory locks held, by key.
+var advisoryLocks sync.Map
+
+// tryAdvisoryLock takes an advisory lock, like the PostgreSQL function, but
+// the lock is held by the process rather than the connection.
+func tryAdvisoryLock(key int64) bool {
+	_, held := advisoryLocks.LoadOrStore(key, true)
+	return !held
+}
+
+// advisoryUnlock releases an advisory lock, like the PostgreSQL function.
+func advisoryUnlock(key int64) bool {
+	_, held := advisoryLocks.LoadAndDelete(key)
+	return held
+}
+
+// greatest returns the largest of its arguments, like the PostgreSQL function.
+func greatest(args ...int64) int64 {
+	m := args[0]
+	for _, a := range args[1:] {
+		m = max(m, a)
+	}
+	return m
+}
+
+// tables are the entities of tables that are created outside this service.
+var tables = []interface{}{
+	&entity.User{},
+	&entity.UserDetail{},
+	&entity.UserLogin{},
+	&entity.Wallet{},
+	&entity.Github{},
+	&entity.Email{},
+	&entity.Referral{},
+	&entity.ReferredUser{},
+	&entity.Subscription{},
+	&entity.Folder{},
+	&entity.FolderUser{},
+	&entity.File{},
+	&entity.FileUser{},
+	&entity.FileShareState{},
+	&entity.FileShareStatesUserShared{},
+	&entity.PublicFile{},
+	&entity.PublicFileUserShared{},
+	&entity.ShareGroup{},
+	&entity.PublicFileShareGroup{},
+	&entity.Error{},
+}
+
+// Open creates an empty database with the tables of all entities, and sets it
+// as the database until the test ends.
+func Open(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=10000&_txlock=immediate&_foreign_keys=off", filepath.Join(t.TempDir(), "test.db"))
+
+	conn, err := gorm.Open(sqlite.Dialector{DriverName: driverName, DSN: dsn}, &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+		NowFunc: func() time.Time {
+			return time.Now().UTC()
+		},
+	})
+	if err != nil {
+		t.Fatalf("testdb: %v", err)
+	}
+
+	for _, m := range tables {
+		if err := conn.AutoMigrate(m); err != nil {
+			t.Fatalf("testdb: failed to migrate %T: %v", m, err)
+		}
+	}
+
+	for name, m := range entity.Entities {
+		if err := conn.AutoMigrate(m); err != nil {
+			t.Fatalf("testdb: failed to migrate %s: %v", name, err)
+		}
+	}
+
+	db.SetDb(conn)
+
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	return conn
+}
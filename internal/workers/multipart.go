@@ -0,0 +1,126 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+)
+
+// multipartJanitorBatch is the number of stale uploads aborted per query.
+const multipartJanitorBatch = 100
+
+// MultipartJanitor aborts multipart uploads that have been abandoned, so the
+// storage backend does not keep their parts, and deletes staged content that
+// was never verified, e.g. of presigned uploads that were not completed.
+type MultipartJanitor struct {
+	backend storage.Backend
+	ttl     time.Duration
+}
+
+// NewMultipartJanitor returns a new janitor using the storage configuration.
+func NewMultipartJanitor() (*MultipartJanitor, error) {
+	backend, err := config.Storage()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultipartJanitor{
+		backend: backend,
+		ttl:     config.Env().MultipartUploadTTL,
+	}, nil
+}
+
+// Start runs one cleanup pass.
+func (w *MultipartJanitor) Start(ctx context.Context) error {
+	before := time.Now().Add(-w.ttl)
+
+	if err := w.abortTracked(ctx, before); err != nil {
+		return err
+	}
+
+	if err := w.abortUntracked(ctx, before); err != nil {
+		return err
+	}
+
+	return w.deleteStaged(ctx, before)
+}
+
+// abortTracked aborts uploads started through the proxy that had no activity within the TTL.
+func (w *MultipartJanitor) abortTracked(ctx context.Context, before time.Time) error {
+	for {
+		uploads, err := query.FindStaleMultipartUploads(before, multipartJanitorBatch)
+		if err != nil {
+			return err
+		}
+
+		for i := range uploads {
+			m := &uploads[i]
+
+			// a request may complete or abort the upload meanwhile; if the
+			// backend abort fails, the untracked pass aborts the upload later
+			if swapped, err := m.SwapStatus(m.Status, entity.MultipartAborted); err != nil {
+				return err
+			} else if !swapped {
+				continue
+			}
+
+			if err := w.backend.AbortMultipart(ctx, m.ObjectKey, m.UploadID); err != nil {
+				return err
+			}
+
+			log.Infof("workers: aborted stale multipart upload %s", m.UID)
+		}
+
+		if len(uploads) < multipartJanitorBatch {
+			return nil
+		}
+	}
+}
+
+// abortUntracked aborts uploads the backend still holds but the proxy does not
+// know about, e.g. because the upload could not be recorded.
+func (w *MultipartJanitor) abortUntracked(ctx context.Context, before time.Time) error {
+	pending, err := w.backend.ListMultipart(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		tracked, err := query.IsMultipartUploadTracked(p.UploadID)
+		if err != nil {
+			return err
+		}
+		if tracked {
+			continue
+		}
+
+		if err := w.backend.AbortMultipart(ctx, p.Key, p.UploadID); err != nil {
+			return err
+		}
+
+		log.Infof("workers: aborted untracked multipart upload of %s", p.Key)
+	}
+
+	return nil
+}
+
+// deleteStaged deletes the content staged before the given time. Content is
+// only staged until it is verified, so it has been abandoned.
+func (w *MultipartJanitor) deleteStaged(ctx context.Context, before time.Time) error {
+	return w.backend.List(ctx, storage.StagingPrefix, func(o storage.ObjectInfo) error {
+		if o.LastModified.After(before) {
+			return nil
+		}
+
+		if err := w.backend.Delete(ctx, o.Key); err != nil {
+			return err
+		}
+
+		log.Infof("workers: deleted stale staged object %s", o.Key)
+		return nil
+	})
+}
@@ -0,0 +1,243 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+// reconcilePageSize is the number of CIDs checked and objects listed per batch.
+const reconcilePageSize = 500
+
+// Reconciler compares the files table with the objects in the storage pool.
+// It updates whether files are in the pool, and records the CIDs missing from
+// the pool and the objects no file references.
+type Reconciler struct {
+	backend     storage.Backend
+	concurrency int
+	// grace is the age objects need before they are reported as orphaned,
+	// since a file is only created after its content was stored.
+	grace time.Duration
+}
+
+// NewReconciler returns a new reconciler using the storage configuration.
+func NewReconciler() (*Reconciler, error) {
+	backend, err := config.Storage()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reconciler{
+		backend:     backend,
+		concurrency: config.Env().ReconcileConcurrency,
+		grace:       config.Env().MultipartUploadTTL,
+	}, nil
+}
+
+// Start runs one reconciliation and records its results, unless another
+// instance is running one.
+func (w *Reconciler) Start(ctx context.Context) error {
+	unlock, err := query.TryLockReconciliation(ctx)
+	if err != nil {
+		return err
+	}
+	if unlock == nil {
+		log.Infof("workers: reconciliation skipped, another instance is running one")
+		return nil
+	}
+	defer unlock()
+
+	run := &entity.Reconciliation{
+		Status:    entity.ReconciliationRunning,
+		StartedAt: time.Now(),
+	}
+	if err := run.Create(); err != nil {
+		return err
+	}
+
+	err = w.checkFiles(ctx, run)
+	if err == nil {
+		err = w.findOrphans(ctx, run)
+	}
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = entity.ReconciliationCompleted
+	if err != nil {
+		run.Status = entity.ReconciliationFailed
+		run.Error = err.Error()
+	}
+
+	if err := run.Save(); err != nil {
+		return err
+	}
+
+	if run.Missing > 0 || run.Orphaned > 0 {
+		log.Warnf("workers: reconciliation %d found %d missing and %d orphaned objects", run.ID, run.Missing, run.Orphaned)
+	}
+
+	return err
+}
+
+// checkFiles checks that the content of every file is stored in the pool.
+func (w *Reconciler) checkFiles(ctx context.Context, run *entity.Reconciliation) error {
+	after := ""
+
+	for {
+		cids, err := query.FindFileCIDsAfter(after, reconcilePageSize)
+		if err != nil {
+			return err
+		}
+		if len(cids) == 0 {
+			return nil
+		}
+
+		missing, err := w.findMissing(ctx, cids)
+		if err != nil {
+			return err
+		}
+
+		var present, absent []string
+		var issues []entity.ReconciliationIssue
+		for _, c := range cids {
+			if !missing[c.CID] {
+				present = append(present, c.CID)
+				continue
+			}
+
+			absent = append(absent, c.CID)
+			issues = append(issues, entity.ReconciliationIssue{
+				ReconciliationID: run.ID,
+				Kind:             entity.MissingObject,
+				ObjectKey:        c.CID,
+				Files:            c.Files,
+			})
+		}
+
+		if err := query.UpdateFilesInPool(present, true); err != nil {
+			return err
+		}
+		if err := query.UpdateFilesInPool(absent, false); err != nil {
+			return err
+		}
+		if err := query.CreateReconciliationIssues(issues); err != nil {
+			return err
+		}
+
+		run.CIDsChecked += int64(len(cids))
+		run.Missing += int64(len(issues))
+		after = cids[len(cids)-1].CID
+	}
+}
+
+// findMissing returns the CIDs that are not stored in the pool, checking up to
+// the configured number of them at the same time.
+func (w *Reconciler) findMissing(ctx context.Context, cids []query.FileCID) (map[string]bool, error) {
+	var mu sync.Mutex
+	missing := make(map[string]bool)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(w.concurrency)
+
+	for _, c := range cids {
+		g.Go(func() error {
+			_, err := w.backend.Head(gctx, c.CID)
+			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+				mu.Lock()
+				missing[c.CID] = true
+				mu.Unlock()
+				return nil
+			}
+
+			return err
+		})
+	}
+
+	return missing, g.Wait()
+}
+
+// findOrphans lists the pool and records the objects no file references.
+func (w *Reconciler) findOrphans(ctx context.Context, run *entity.Reconciliation) error {
+	before := run.StartedAt.Add(-w.grace)
+
+	var page []storage.ObjectInfo
+	flush := func() error {
+		if len(page) == 0 {
+			return nil
+		}
+
+		keys := make([]string, len(page))
+		for i, o := range page {
+			keys[i] = o.Key
+		}
+
+		referenced, err := query.FindReferencedCIDs(keys)
+		if err != nil {
+			return err
+		}
+
+		isReferenced := make(map[string]bool, len(referenced))
+		for _, key := range referenced {
+			isReferenced[key] = true
+		}
+
+		var issues []entity.ReconciliationIssue
+		for _, o := range page {
+			if isReferenced[o.Key] {
+				continue
+			}
+
+			lastModified := o.LastModified
+			issues = append(issues, entity.ReconciliationIssue{
+				ReconciliationID: run.ID,
+				Kind:             entity.OrphanedObject,
+				ObjectKey:        o.Key,
+				Size:             o.Size,
+				LastModified:     &lastModified,
+			})
+		}
+
+		if err := query.CreateReconciliationIssues(issues); err != nil {
+			return err
+		}
+
+		run.Orphaned += int64(len(issues))
+		page = page[:0]
+		return nil
+	}
+
+	err := w.backend.List(ctx, "", func(o storage.ObjectInfo) error {
+		run.ObjectsListed++
+
+		// objects being uploaded may not be referenced yet
+		if o.LastModified.After(before) {
+			return nil
+		}
+
+		// staged content is never referenced, the multipart janitor deletes
+		// it once it is abandoned
+		if strings.HasPrefix(o.Key, storage.StagingPrefix) {
+			return nil
+		}
+
+		page = append(page, o)
+		if len(page) < reconcilePageSize {
+			return nil
+		}
+
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
@@ -0,0 +1,85 @@
+package workers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/db"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/entity"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/query"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/testdb"
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReconciler(t *testing.T) *Reconciler {
+	testdb.Open(t)
+
+	backend, err := storage.NewLocal(t.TempDir(), "http://localhost/api/storage", []byte("secret"))
+	require.NoError(t, err)
+
+	return &Reconciler{backend: backend, concurrency: 2}
+}
+
+func TestReconcilerStart(t *testing.T) {
+	ctx := context.Background()
+	w := newTestReconciler(t)
+
+	for _, key := range []string{"stored", "orphaned", storage.StagingPrefix + "upload"} {
+		require.NoError(t, w.backend.Put(ctx, key, strings.NewReader(key), "text/plain"))
+	}
+
+	for _, f := range []entity.File{
+		{CID: "stored"},
+		{CID: "missing"},
+		{CID: "missing"},
+	} {
+		require.NoError(t, db.Db().Create(&f).Error)
+	}
+
+	require.NoError(t, w.Start(ctx))
+
+	run := &entity.Reconciliation{}
+	require.NoError(t, db.Db().First(run).Error)
+	require.Equal(t, entity.ReconciliationCompleted, run.Status)
+	require.Equal(t, int64(2), run.CIDsChecked)
+	require.Equal(t, int64(3), run.ObjectsListed)
+	require.Equal(t, int64(1), run.Missing)
+	require.Equal(t, int64(1), run.Orphaned)
+
+	var issues []entity.ReconciliationIssue
+	require.NoError(t, db.Db().Where("reconciliation_id = ?", run.ID).Order("kind").Find(&issues).Error)
+	require.Len(t, issues, 2)
+	require.Equal(t, entity.MissingObject, issues[0].Kind)
+	require.Equal(t, "missing", issues[0].ObjectKey)
+	require.Equal(t, int64(2), issues[0].Files)
+	require.Equal(t, entity.OrphanedObject, issues[1].Kind)
+	require.Equal(t, "orphaned", issues[1].ObjectKey)
+
+	var inPool []bool
+	require.NoError(t, db.Db().Model(&entity.File{}).Order("id").Pluck("is_in_pool", &inPool).Error)
+	require.Equal(t, []bool{true, false, false}, inPool)
+}
+
+func TestReconcilerStartLocked(t *testing.T) {
+	ctx := context.Background()
+	w := newTestReconciler(t)
+
+	unlock, err := query.TryLockReconciliation(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, unlock)
+
+	// another instance is running a reconciliation
+	require.NoError(t, w.Start(ctx))
+
+	var runs int64
+	require.NoError(t, db.Db().Model(&entity.Reconciliation{}).Count(&runs).Error)
+	require.Zero(t, runs)
+
+	unlock()
+
+	require.NoError(t, w.Start(ctx))
+	require.NoError(t, db.Db().Model(&entity.Reconciliation{}).Count(&runs).Error)
+	require.Equal(t, int64(1), runs)
+}
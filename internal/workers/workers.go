@@ -0,0 +1,50 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/Hello-Storage/hello-storage-proxy/internal/config"
+	"github.com/Hello-Storage/hello-storage-proxy/internal/event"
+)
+
+var log = event.Log
+
+// Start runs the background workers until the context is cancelled.
+func Start(ctx context.Context) {
+	janitor, err := NewMultipartJanitor()
+	if err != nil {
+		log.Errorf("workers: multipart janitor disabled (%s)", err)
+	} else {
+		go runEvery(ctx, "multipart janitor", config.Env().MultipartJanitorInterval, janitor.Start)
+	}
+
+	reconciler, err := NewReconciler()
+	if err != nil {
+		log.Errorf("workers: reconciliation disabled (%s)", err)
+	} else {
+		go runEvery(ctx, "reconciliation", config.Env().ReconcileInterval, reconciler.Start)
+	}
+}
+
+// runEvery calls fn right away and then at every interval, until the context is cancelled.
+func runEvery(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if err := fn(ctx); err != nil {
+			log.Errorf("workers: %s failed (%s)", name, err)
+		} else {
+			log.Debugf("workers: %s completed [%s]", name, time.Since(start))
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("workers: %s stopped", name)
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Package contentid computes the IPFS content identifiers of file content.
+*/
+package contentid
+
+import (
+	"crypto/sha256"
+	"hash"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// Hasher computes the CIDv1 (raw codec, sha2-256) of the content written to it.
+type Hasher struct {
+	hash hash.Hash
+}
+
+// NewHasher returns a new hasher.
+func NewHasher() *Hasher {
+	return &Hasher{hash: sha256.New()}
+}
+
+// Write adds content to the hash. It never returns an error.
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.hash.Write(p)
+}
+
+// Verifiable reports whether c is a CID the hasher can compute, so that
+// content can be checked against it.
+func Verifiable(c cid.Cid) bool {
+	prefix := c.Prefix()
+	return prefix.Version == 1 && prefix.Codec == cid.Raw && prefix.MhType == multihash.SHA2_256
+}
+
+// CID returns the CID of the content written so far.
+func (h *Hasher) CID() (cid.Cid, error) {
+	mh, err := multihash.Encode(h.hash.Sum(nil), multihash.SHA2_256)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
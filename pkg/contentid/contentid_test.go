@@ -0,0 +1,47 @@
+package contentid
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasher(t *testing.T) {
+	testCases := []struct {
+		content string
+		cid     string
+	}{
+		{"", "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"},
+		{"hello world", "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"},
+	}
+
+	for _, tc := range testCases {
+		h := NewHasher()
+		_, err := io.Copy(h, strings.NewReader(tc.content))
+		require.NoError(t, err)
+
+		c, err := h.CID()
+		require.NoError(t, err)
+		require.Equal(t, tc.cid, c.String())
+	}
+}
+
+func TestVerifiable(t *testing.T) {
+	testCases := []struct {
+		cid        string
+		verifiable bool
+	}{
+		{"bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e", true},
+		{"QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", false},
+		{"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", false},
+	}
+
+	for _, tc := range testCases {
+		c, err := cid.Decode(tc.cid)
+		require.NoError(t, err)
+		require.Equal(t, tc.verifiable, Verifiable(c), tc.cid)
+	}
+}
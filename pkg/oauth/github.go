@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -17,66 +18,97 @@ type GithubUser struct {
 	Avatar string
 }
 
-func GetGithubOAuthToken(code string) (string, error) {
-	const rootURl = "https://github.com/login/oauth/access_token"
+// GithubClient exchanges the codes of GitHub's OAuth web flow for access
+// tokens of an OAuth app, and retrieves the users they belong to.
+type GithubClient struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // optional, must match the app's callback URL if set
+	TokenURL     string
+	APIURL       string
+	HTTPClient   *http.Client
+}
+
+// NewGithubClient returns a client of the OAuth app with the credentials.
+func NewGithubClient(clientID, clientSecret, redirectURL string) *GithubClient {
+	return &GithubClient{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		TokenURL:     github_token_url,
+		APIURL:       github_api_url,
+		HTTPClient: &http.Client{
+			Timeout: time.Second * 30,
+		},
+	}
+}
 
+// GetGithubOAuthToken exchanges an authorization code for an access token.
+func (c *GithubClient) GetGithubOAuthToken(code string) (string, error) {
 	values := url.Values{}
 	values.Add("code", code)
-	//values.Add("client_id", config.Env().GithubClientID)
-	//values.Add("client_secret", config.Env().GithubClientSecret)
-
-	query := values.Encode()
+	values.Add("client_id", c.ClientID)
+	values.Add("client_secret", c.ClientSecret)
+	if c.RedirectURL != "" {
+		values.Add("redirect_uri", c.RedirectURL)
+	}
 
-	queryString := fmt.Sprintf("%s?%s", rootURl, bytes.NewBufferString(query))
-	req, err := http.NewRequest("POST", queryString, nil)
+	req, err := http.NewRequest("POST", c.TokenURL, strings.NewReader(values.Encode()))
 	if err != nil {
 		return "", err
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	client := http.Client{
-		Timeout: time.Second * 30,
-	}
+	req.Header.Set("Accept", "application/json")
 
-	res, err := client.Do(req)
+	res, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return "", err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		return "", errors.New("could not retrieve token")
 	}
 
-	var resBody bytes.Buffer
-	_, err = io.Copy(&resBody, res.Body)
-	if err != nil {
-		return "", err
+	// errors such as expired codes are reported with status 200
+	var tokenRes struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
 	}
 
-	parsedQuery, err := url.ParseQuery(resBody.String())
-	if err != nil {
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
 		return "", err
 	}
 
-	tokenBody := parsedQuery["access_token"][0]
+	if tokenRes.Error != "" {
+		return "", fmt.Errorf("could not retrieve token: %s", tokenRes.Error)
+	}
+
+	if tokenRes.AccessToken == "" {
+		return "", errors.New("could not retrieve token")
+	}
 
-	return tokenBody, nil
+	return tokenRes.AccessToken, nil
 }
 
-func GetGithubUser(token string) (*GithubUser, error) {
-	req, err := http.NewRequest("GET", github_api_url, nil)
+// GetGithubUser returns the user an access token belongs to.
+func (c *GithubClient) GetGithubUser(token string) (*GithubUser, error) {
+	req, err := http.NewRequest("GET", c.APIURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	client := http.Client{
-		Timeout: time.Second * 30,
-	}
-	res, err := client.Do(req)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
+
 	if res.StatusCode != http.StatusOK {
 		return nil, errors.New("could not retrieve user")
 	}
@@ -93,12 +125,15 @@ func GetGithubUser(token string) (*GithubUser, error) {
 		return nil, err
 	}
 
-	id := uint(GithubUserRes["id"].(float64))
+	id, ok := GithubUserRes["id"].(float64)
+	if !ok || id <= 0 {
+		return nil, errors.New("could not retrieve user")
+	}
 
 	userBody := &GithubUser{
-		ID:     id,
-		Name:   GithubUserRes["login"].(string),
-		Avatar: GithubUserRes["avatar_url"].(string),
+		ID:     uint(id),
+		Name:   getStringValue(GithubUserRes, "login"),
+		Avatar: getStringValue(GithubUserRes, "avatar_url"),
 	}
 
 	return userBody, nil
@@ -0,0 +1,95 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newGithubStub serves GitHub's token and user endpoints for an OAuth app
+// with the client id "client" and secret "secret", which accepts the code
+// "code".
+func newGithubStub(t *testing.T) *GithubClient {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Accept"))
+		require.NoError(t, r.ParseForm())
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("client_id") != "client" || r.PostForm.Get("client_secret") != "secret" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "incorrect_client_credentials"})
+			return
+		}
+		if r.PostForm.Get("code") != "code" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token", "token_type": "bearer"})
+	})
+
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":         1234,
+			"login":      "octocat",
+			"avatar_url": "https://avatars.githubusercontent.com/u/1234",
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	c := NewGithubClient("client", "secret", "")
+	c.TokenURL = server.URL + "/login/oauth/access_token"
+	c.APIURL = server.URL + "/user"
+	c.HTTPClient = server.Client()
+
+	return c
+}
+
+func TestGithubClient(t *testing.T) {
+	c := newGithubStub(t)
+
+	token, err := c.GetGithubOAuthToken("code")
+	require.NoError(t, err)
+	require.Equal(t, "gho_token", token)
+
+	u, err := c.GetGithubUser(token)
+	require.NoError(t, err)
+	require.Equal(t, &GithubUser{ID: 1234, Name: "octocat", Avatar: "https://avatars.githubusercontent.com/u/1234"}, u)
+}
+
+func TestGithubClientErrors(t *testing.T) {
+	t.Run("bad code", func(t *testing.T) {
+		c := newGithubStub(t)
+
+		_, err := c.GetGithubOAuthToken("expired")
+		require.ErrorContains(t, err, "bad_verification_code")
+	})
+
+	t.Run("bad credentials", func(t *testing.T) {
+		c := newGithubStub(t)
+		c.ClientSecret = "wrong"
+
+		_, err := c.GetGithubOAuthToken("code")
+		require.ErrorContains(t, err, "incorrect_client_credentials")
+	})
+
+	t.Run("bad token", func(t *testing.T) {
+		c := newGithubStub(t)
+
+		_, err := c.GetGithubUser("revoked")
+		require.Error(t, err)
+	})
+}
@@ -1,6 +1,7 @@
 package oauth
 
 const (
-	google_api_url = "https://www.googleapis.com/oauth2/v3/userinfo"
-	github_api_url = "https://api.github.com/user"
+	google_api_url   = "https://www.googleapis.com/oauth2/v3/userinfo"
+	github_api_url   = "https://api.github.com/user"
+	github_token_url = "https://github.com/login/oauth/access_token"
 )
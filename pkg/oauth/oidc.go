@@ -0,0 +1,168 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrOIDCNonce is returned when an ID token was not issued for the login.
+var ErrOIDCNonce = errors.New("oidc: nonce mismatch")
+
+// OIDCConfig is an OpenID Connect provider, such as Google, GitLab, Keycloak
+// or a self-hosted IdP, and the client registered with it.
+type OIDCConfig struct {
+	Name         string
+	Issuer       string // its discovery document is served below it
+	ClientID     string
+	ClientSecret string // optional for public clients
+	RedirectURL  string
+	Scopes       []string // in addition to openid, email and profile
+	TrustEmail   bool     // verified emails identify existing users
+}
+
+// OIDCClaims are the claims of a verified ID token.
+type OIDCClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Username      string `json:"preferred_username"`
+	Nonce         string `json:"nonce"`
+}
+
+// OIDCProvider signs users in with the authorization code flow of an OpenID
+// Connect provider, with PKCE. The endpoints and signing keys are discovered
+// on first use, so that the provider does not need to be up at startup.
+type OIDCProvider struct {
+	config     OIDCConfig
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider returns the provider of a configuration.
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: time.Second * 30,
+		},
+	}
+}
+
+// Name returns the name the provider is configured with.
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// TrustEmail reports whether users who sign in with a verified email are
+// linked to the user with that email. Only providers that own the domains
+// of their emails should be trusted, unlike self-hosted IdPs which let users
+// set any email.
+func (p *OIDCProvider) TrustEmail() bool {
+	return p.config.TrustEmail
+}
+
+// GenerateVerifier returns a random PKCE code verifier.
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// discover fetches the discovery document of the issuer once it succeeds.
+// The signing keys are fetched from its jwks_uri when tokens are verified,
+// and again when a token is signed with an unknown key.
+func (p *OIDCProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+
+	// the key set keeps the context of the provider to refresh the keys
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), p.httpClient), p.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc: discovery of %s failed: %w", p.config.Name, err)
+	}
+
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID, "email", "profile"}, p.config.Scopes...),
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+
+	return p.oauth2, p.verifier, nil
+}
+
+// AuthCodeURL returns the URL of the provider the user signs in at. The
+// state, nonce and PKCE verifier have to be kept until the user returns.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	config, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange exchanges the code the user returned with for an ID token, and
+// returns its claims once its signature, issuer, audience, expiry and nonce
+// are verified.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce, verifier string) (*OIDCClaims, error) {
+	config, idTokenVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = oidc.ClientContext(ctx, p.httpClient)
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: no id_token in token response")
+	}
+
+	idToken, err := idTokenVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims OIDCClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	if claims.Nonce != nonce {
+		return nil, ErrOIDCNonce
+	}
+
+	return &claims, nil
+}
+
+// DisplayName returns the name a new user is created with.
+func (c *OIDCClaims) DisplayName() string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.Username != "":
+		return c.Username
+	default:
+		return c.Email
+	}
+}
@@ -0,0 +1,236 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// oidcStub is an OpenID Connect provider for the client "client", which
+// issues ID tokens with the claims for the code "code" and the PKCE
+// challenge of the last authorization request.
+type oidcStub struct {
+	t         *testing.T
+	server    *httptest.Server
+	key       *rsa.PrivateKey // publishes its public key
+	signer    *rsa.PrivateKey // signs the ID tokens
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newOIDCStub(t *testing.T) *oidcStub {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := &oidcStub{t: t, key: key, signer: key}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                s.server.URL,
+			"authorization_endpoint":                s.server.URL + "/authorize",
+			"token_endpoint":                        s.server.URL + "/token",
+			"jwks_uri":                              s.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "1",
+				"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != s.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     s.idToken(),
+		})
+	})
+
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+// idToken returns an ID token with the claims, signed with RS256.
+func (s *oidcStub) idToken() string {
+	claims := map[string]interface{}{
+		"iss": s.server.URL,
+		"aud": "client",
+		"sub": "1234",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range s.claims {
+		claims[k] = v
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "1"})
+	require.NoError(s.t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(s.t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.signer, crypto.SHA256, digest[:])
+	require.NoError(s.t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize starts a login, recording the PKCE challenge of its URL.
+func (s *oidcStub) authorize(p *OIDCProvider, state, nonce, verifier string) {
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	require.NoError(s.t, err)
+	require.True(s.t, strings.HasPrefix(authURL, s.server.URL+"/authorize?"))
+
+	u, err := url.Parse(authURL)
+	require.NoError(s.t, err)
+	require.Equal(s.t, state, u.Query().Get("state"))
+	require.Equal(s.t, nonce, u.Query().Get("nonce"))
+	require.Equal(s.t, "S256", u.Query().Get("code_challenge_method"))
+	require.Equal(s.t, "openid email profile", u.Query().Get("scope"))
+
+	s.challenge = u.Query().Get("code_challenge")
+}
+
+func TestOIDCProvider(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		signer   *rsa.PrivateKey
+		code     string
+		verifier string // the verifier of the exchange, if not the one of the login
+		wantErr  bool
+		err      error
+	}{
+		{
+			name:   "verified",
+			claims: map[string]interface{}{"nonce": "nonce", "email": "user@example.com", "email_verified": true, "name": "User"},
+			code:   "code",
+		},
+		{
+			name:    "nonce mismatch",
+			claims:  map[string]interface{}{"nonce": "other"},
+			code:    "code",
+			err:     ErrOIDCNonce,
+			wantErr: true,
+		},
+		{
+			name:    "bad signature",
+			claims:  map[string]interface{}{"nonce": "nonce"},
+			signer:  otherKey,
+			code:    "code",
+			wantErr: true,
+		},
+		{
+			name:     "wrong code verifier",
+			claims:   map[string]interface{}{"nonce": "nonce"},
+			code:     "code",
+			verifier: GenerateVerifier(),
+			wantErr:  true,
+		},
+		{
+			name:    "wrong code",
+			claims:  map[string]interface{}{"nonce": "nonce"},
+			code:    "other",
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			claims:  map[string]interface{}{"nonce": "nonce", "aud": "other"},
+			code:    "code",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newOIDCStub(t)
+			stub.claims = tt.claims
+			if tt.signer != nil {
+				stub.signer = tt.signer
+			}
+
+			p := NewOIDCProvider(OIDCConfig{
+				Name:        "stub",
+				Issuer:      stub.server.URL,
+				ClientID:    "client",
+				RedirectURL: "http://localhost:3000/auth/oidc/stub",
+			})
+
+			verifier := GenerateVerifier()
+			stub.authorize(p, "state", "nonce", verifier)
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+
+			claims, err := p.Exchange(context.Background(), tt.code, "nonce", verifier)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				if tt.err != nil {
+					require.ErrorIs(t, err, tt.err)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "1234", claims.Subject)
+			require.Equal(t, "user@example.com", claims.Email)
+			require.True(t, claims.EmailVerified)
+			require.Equal(t, "User", claims.DisplayName())
+		})
+	}
+}
+
+func TestNewOIDCProviderDiscoveryFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+
+	p := NewOIDCProvider(OIDCConfig{Name: "down", Issuer: server.URL, ClientID: "client"})
+
+	_, err := p.AuthCodeURL(context.Background(), "state", "nonce", GenerateVerifier())
+	require.Error(t, err)
+}
@@ -35,3 +35,19 @@ func GenerateRandomString(n int) string {
 
 	return string(ret)
 }
+
+// GenerateDigits returns a securely generated string of n decimal digits,
+// such as a one-time code.
+func GenerateDigits(n int) (string, error) {
+	ret := make([]byte, n)
+	for i := 0; i < n; i++ {
+		num, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+
+		ret[i] = byte('0' + num.Int64())
+	}
+
+	return string(ret), nil
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects in a directory, for development and tests. Presigned
+// requests point at the base URL, where the proxy serves them after checking their
+// signature with Verify.
+type Local struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+// localMeta is stored next to each object for the metadata a file system does not keep.
+type localMeta struct {
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag"`
+}
+
+// NewLocal returns a backend storing objects below root. Presigned requests
+// are signed with secret and point at baseURL.
+func NewLocal(root, baseURL string, secret []byte) (*Local, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("storage: local backend requires a signing secret")
+	}
+
+	for _, dir := range []string{"objects", "meta", "uploads", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+	}
+
+	return &Local{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+	}, nil
+}
+
+func (b *Local) objectPath(key string) string {
+	return filepath.Join(b.root, "objects", filepath.FromSlash(key))
+}
+
+func (b *Local) metaPath(key string) string {
+	return filepath.Join(b.root, "meta", filepath.FromSlash(key)+".json")
+}
+
+// writeTemp copies body to a temporary file and returns its path, size and ETag.
+func (b *Local) writeTemp(ctx context.Context, body io.Reader) (string, int64, string, error) {
+	f, err := os.CreateTemp(filepath.Join(b.root, "tmp"), "put-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), contextReader{ctx: ctx, r: body})
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, "", err
+	}
+
+	return f.Name(), n, `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// commit moves a temporary file into place as the object key.
+func (b *Local) commit(tmp, key string, meta localMeta) error {
+	if err := os.MkdirAll(filepath.Dir(b.objectPath(key)), 0o750); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.metaPath(key)), 0o750); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.metaPath(key), data, 0o640); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, b.objectPath(key))
+}
+
+func (b *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	tmp, _, etag, err := b.writeTemp(ctx, body)
+	if err != nil {
+		return err
+	}
+
+	if err := b.commit(tmp, key, localMeta{ContentType: contentType, ETag: etag}); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+func (b *Local) Get(ctx context.Context, key string, rng *Range) (io.ReadCloser, error) {
+	f, _, err := b.Open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if rng == nil {
+		return f, nil
+	}
+
+	if _, err := f.Seek(rng.Start, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, rng.End-rng.Start+1), f}, nil
+}
+
+// Open opens an object for reading and returns its metadata.
+func (b *Local) Open(key string) (*os.File, *ObjectInfo, error) {
+	info, err := b.Head(context.Background(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(b.objectPath(key))
+	if err != nil {
+		return nil, nil, localError(err)
+	}
+
+	return f, info, nil
+}
+
+func (b *Local) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(b.objectPath(key))
+	if err != nil {
+		return nil, localError(err)
+	}
+
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+	}
+
+	var meta localMeta
+	if data, err := os.ReadFile(b.metaPath(key)); err == nil && json.Unmarshal(data, &meta) == nil {
+		info.ContentType = meta.ContentType
+		info.ETag = meta.ETag
+	}
+
+	return info, nil
+}
+
+func (b *Local) Copy(ctx context.Context, src, dst string) error {
+	if err := validKey(dst); err != nil {
+		return err
+	}
+
+	f, info, err := b.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tmp, _, etag, err := b.writeTemp(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	if err := b.commit(tmp, dst, localMeta{ContentType: info.ContentType, ETag: etag}); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+func (b *Local) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	if err := os.Remove(b.objectPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(b.metaPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (b *Local) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	dir := filepath.Join(b.root, "objects")
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := b.Head(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		return fn(*info)
+	})
+}
+
+// localError maps file system errors to the errors of this package.
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	return err
+}
+
+// contextReader stops reading once the context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.r.Read(p)
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localUpload is stored in the directory of each multipart upload.
+type localUpload struct {
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type"`
+	Initiated   time.Time `json:"initiated"`
+}
+
+// uploadDir returns the directory of a multipart upload, rejecting upload ids
+// this backend could not have created.
+func (b *Local) uploadDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || len(uploadID) != 32 {
+		return "", fmt.Errorf("%w: invalid upload id", ErrNotFound)
+	}
+
+	return filepath.Join(b.root, "uploads", uploadID), nil
+}
+
+// readUpload returns a multipart upload of key.
+func (b *Local) readUpload(key, uploadID string) (string, *localUpload, error) {
+	dir, err := b.uploadDir(uploadID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return "", nil, localError(err)
+	}
+
+	var upload localUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return "", nil, err
+	}
+
+	if upload.Key != key {
+		return "", nil, fmt.Errorf("%w: upload %s is not for %s", ErrNotFound, uploadID, key)
+	}
+
+	return dir, &upload, nil
+}
+
+func (b *Local) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	dir, _ := b.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(localUpload{Key: key, ContentType: contentType, Initiated: now()})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), data, 0o640); err != nil {
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// PutPart stores one part of a multipart upload and returns its ETag.
+func (b *Local) PutPart(ctx context.Context, key, uploadID string, partNumber int32, body io.Reader) (string, error) {
+	dir, _, err := b.readUpload(key, uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	if partNumber < 1 {
+		return "", fmt.Errorf("storage: invalid part number %d", partNumber)
+	}
+
+	tmp, _, etag, err := b.writeTemp(ctx, body)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, strconv.FormatInt(int64(partNumber), 10))
+	if err := os.WriteFile(name+".etag", []byte(etag), 0o640); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := os.Rename(tmp, name+".part"); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return etag, nil
+}
+
+func (b *Local) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	dir, _, err := b.readUpload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, localError(err)
+	}
+
+	var parts []Part
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".part")
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.ParseInt(name, 10, 32)
+		if err != nil {
+			continue
+		}
+
+		stat, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		etag, err := os.ReadFile(filepath.Join(dir, name+".etag"))
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, Part{
+			PartNumber:   int32(n),
+			ETag:         string(etag),
+			Size:         stat.Size(),
+			LastModified: stat.ModTime(),
+		})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+func (b *Local) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	dir, upload, err := b.readUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		name := filepath.Join(dir, strconv.FormatInt(int64(p.PartNumber), 10))
+
+		etag, err := os.ReadFile(name + ".etag")
+		if err != nil || string(etag) != p.ETag {
+			return fmt.Errorf("storage: part %d does not match", p.PartNumber)
+		}
+
+		f, err := os.Open(name + ".part")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		readers = append(readers, f)
+	}
+
+	tmp, _, etag, err := b.writeTemp(ctx, io.MultiReader(readers...))
+	if err != nil {
+		return err
+	}
+
+	if err := b.commit(tmp, key, localMeta{ContentType: upload.ContentType, ETag: etag}); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (b *Local) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	dir, _, err := b.readUpload(key, uploadID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (b *Local) ListMultipart(ctx context.Context, before time.Time) ([]PendingUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(b.root, "uploads"))
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []PendingUpload
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(b.root, "uploads", e.Name(), "upload.json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var upload localUpload
+		if err := json.Unmarshal(data, &upload); err != nil {
+			return nil, err
+		}
+
+		if upload.Initiated.Before(before) {
+			uploads = append(uploads, PendingUpload{
+				Key:       upload.Key,
+				UploadID:  e.Name(),
+				Initiated: upload.Initiated,
+			})
+		}
+	}
+
+	return uploads, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLocal(t *testing.T) *Local {
+	b, err := NewLocal(t.TempDir(), "http://localhost/api/storage", []byte("secret"))
+	require.NoError(t, err)
+	return b
+}
+
+func readAll(t *testing.T, r io.ReadCloser) string {
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestLocalObjects(t *testing.T) {
+	ctx := context.Background()
+	b := newTestLocal(t)
+
+	require.NoError(t, b.Put(ctx, "bafkqaaa", strings.NewReader("hello world"), "text/plain"))
+
+	info, err := b.Head(ctx, "bafkqaaa")
+	require.NoError(t, err)
+	require.Equal(t, int64(11), info.Size)
+	require.Equal(t, "text/plain", info.ContentType)
+	require.Equal(t, `"5eb63bbbe01eeed093cb22bb8f5acdc3"`, info.ETag)
+
+	r, err := b.Get(ctx, "bafkqaaa", nil)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", readAll(t, r))
+
+	r, err = b.Get(ctx, "bafkqaaa", &Range{Start: 6, End: 10})
+	require.NoError(t, err)
+	require.Equal(t, "world", readAll(t, r))
+
+	require.NoError(t, b.Copy(ctx, "bafkqaaa", "copies/bafkqaaa"))
+	r, err = b.Get(ctx, "copies/bafkqaaa", nil)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", readAll(t, r))
+
+	var keys []string
+	require.NoError(t, b.List(ctx, "copies/", func(o ObjectInfo) error {
+		keys = append(keys, o.Key)
+		return nil
+	}))
+	require.Equal(t, []string{"copies/bafkqaaa"}, keys)
+
+	require.NoError(t, b.Delete(ctx, "bafkqaaa"))
+	require.NoError(t, b.Delete(ctx, "bafkqaaa"))
+
+	_, err = b.Head(ctx, "bafkqaaa")
+	require.True(t, errors.Is(err, ErrNotFound))
+
+	for _, key := range []string{"", "../escape", "/abs", "a//b"} {
+		require.ErrorIs(t, b.Put(ctx, key, strings.NewReader(""), ""), ErrInvalidKey)
+	}
+}
+
+func TestLocalMultipart(t *testing.T) {
+	ctx := context.Background()
+	b := newTestLocal(t)
+
+	uploadID, err := b.CreateMultipart(ctx, "bafkqaaa", "text/plain")
+	require.NoError(t, err)
+
+	_, err = b.PutPart(ctx, "bafkqaaa", uploadID, 2, strings.NewReader("world"))
+	require.NoError(t, err)
+	_, err = b.PutPart(ctx, "bafkqaaa", uploadID, 1, strings.NewReader("hello "))
+	require.NoError(t, err)
+
+	_, err = b.PutPart(ctx, "other", uploadID, 1, strings.NewReader(""))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	pending, err := b.ListMultipart(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, uploadID, pending[0].UploadID)
+
+	parts, err := b.ListParts(ctx, "bafkqaaa", uploadID)
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	require.Equal(t, int32(1), parts[0].PartNumber)
+	require.Equal(t, int64(6), parts[0].Size)
+
+	require.NoError(t, b.CompleteMultipart(ctx, "bafkqaaa", uploadID, parts))
+
+	r, err := b.Get(ctx, "bafkqaaa", nil)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", readAll(t, r))
+
+	_, err = b.ListParts(ctx, "bafkqaaa", uploadID)
+	require.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, b.AbortMultipart(ctx, "bafkqaaa", uploadID))
+}
+
+func TestLocalVerify(t *testing.T) {
+	ctx := context.Background()
+	b := newTestLocal(t)
+
+	put, err := b.PresignPut(ctx, "bafkqaaa", "text/plain", 11, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", put.Header.Get("Content-Type"))
+
+	get, err := b.PresignGet(ctx, "bafkqaaa", time.Minute)
+	require.NoError(t, err)
+
+	request := func(method, url, contentType string, body string) *http.Request {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		return r
+	}
+
+	testCases := []struct {
+		name string
+		r    *http.Request
+		key  string
+		err  error
+	}{
+		{"put", request(http.MethodPut, put.URL, "text/plain", "hello world"), "bafkqaaa", nil},
+		{"put wrong length", request(http.MethodPut, put.URL, "text/plain", "hello"), "bafkqaaa", ErrInvalidSignature},
+		{"put wrong type", request(http.MethodPut, put.URL, "text/html", "hello world"), "bafkqaaa", ErrInvalidSignature},
+		{"put wrong key", request(http.MethodPut, put.URL, "text/plain", "hello world"), "bafkqaab", ErrInvalidSignature},
+		{"get", request(http.MethodGet, get.URL, "", ""), "bafkqaaa", nil},
+		{"get with put method", request(http.MethodPut, get.URL, "", ""), "bafkqaaa", ErrInvalidSignature},
+		{"tampered", request(http.MethodGet, get.URL+"&partNumber=1", "", ""), "bafkqaaa", ErrInvalidSignature},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.ErrorIs(t, b.Verify(tc.r, tc.key), tc.err)
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		defer func() { now = time.Now }()
+		now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+		require.ErrorIs(t, b.Verify(request(http.MethodGet, get.URL, "", ""), "bafkqaaa"), ErrExpired)
+	})
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// s3UploadPartSize is the size of the chunks buffered while streaming an
+	// upload, which caps a single object at 10000 parts (~160 GB).
+	s3UploadPartSize = 16 << 20
+	// s3UploadConcurrency is the number of parts sent to the bucket in parallel.
+	s3UploadConcurrency = 3
+	// s3MaxCopySize is the largest object S3 copies in a single request.
+	s3MaxCopySize = 5 << 30
+	// s3CopyPartSize is the size of the parts larger objects are copied in.
+	s3CopyPartSize = 1 << 30
+)
+
+// S3Options configures an S3 compatible backend.
+type S3Options struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+}
+
+// S3 stores objects in a bucket of an S3 compatible service.
+type S3 struct {
+	client    *s3.Client
+	presigner *s3.PresignClient
+	bucket    string
+}
+
+// NewS3 returns a backend for the configured bucket.
+func NewS3(ctx context.Context, opts S3Options) (*S3, error) {
+	cfg, err := awsConfig.LoadDefaultConfig(
+		ctx,
+		awsConfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(opts.AccessKey, opts.SecretKey, "")),
+		awsConfig.WithRegion(opts.Region),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("storage: failed to load aws config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+	})
+
+	return &S3{
+		client:    client,
+		presigner: s3.NewPresignClient(client),
+		bucket:    opts.Bucket,
+	}, nil
+}
+
+// s3Error maps errors of the S3 API to the errors of this package.
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var noSuchKey *types.NoSuchKey
+	var noSuchUpload *types.NoSuchUpload
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &noSuchUpload) || errors.As(err, &notFound) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	return err
+}
+
+// Put streams body into the bucket in fixed-size parts, so memory usage does
+// not depend on the object size.
+func (b *S3) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	uploader := manager.NewUploader(b.client, func(u *manager.Uploader) {
+		u.PartSize = s3UploadPartSize
+		u.Concurrency = s3UploadConcurrency
+	})
+
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+
+	return err
+}
+
+func (b *S3) Get(ctx context.Context, key string, rng *Range) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	}
+
+	if rng != nil {
+		input.Range = aws.String(rng.Header())
+	}
+
+	out, err := b.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return out.Body, nil
+}
+
+func (b *S3) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         aws.ToString(out.ETag),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (b *S3) Copy(ctx context.Context, src, dst string) error {
+	head, err := b.Head(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	source := b.bucket + "/" + url.PathEscape(src)
+
+	if head.Size <= s3MaxCopySize {
+		_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(b.bucket),
+			Key:        aws.String(dst),
+			CopySource: aws.String(source),
+		})
+		return s3Error(err)
+	}
+
+	uploadID, err := b.CreateMultipart(ctx, dst, head.ContentType)
+	if err != nil {
+		return err
+	}
+
+	var parts []Part
+	for start, n := int64(0), int32(1); start < head.Size; start, n = start+s3CopyPartSize, n+1 {
+		end := min(start+s3CopyPartSize, head.Size) - 1
+
+		out, err := b.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(b.bucket),
+			Key:             aws.String(dst),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      aws.Int32(n),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(Range{Start: start, End: end}.Header()),
+		})
+		if err != nil {
+			b.AbortMultipart(ctx, dst, uploadID)
+			return s3Error(err)
+		}
+
+		parts = append(parts, Part{PartNumber: n, ETag: aws.ToString(out.CopyPartResult.ETag)})
+	}
+
+	if err := b.CompleteMultipart(ctx, dst, uploadID, parts); err != nil {
+		b.AbortMultipart(ctx, dst, uploadID)
+		return err
+	}
+
+	return nil
+}
+
+func (b *S3) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+
+	return s3Error(err)
+}
+
+func (b *S3) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return s3Error(err)
+		}
+
+		for _, o := range page.Contents {
+			if err := fn(ObjectInfo{
+				Key:          aws.ToString(o.Key),
+				Size:         aws.ToInt64(o.Size),
+				ETag:         aws.ToString(o.ETag),
+				LastModified: aws.ToTime(o.LastModified),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *S3) PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error) {
+	req, err := b.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedRequest{Method: req.Method, URL: req.URL, Header: req.SignedHeader}, nil
+}
+
+func (b *S3) PresignPut(ctx context.Context, key, contentType string, contentLength int64, expiry time.Duration) (*PresignedRequest, error) {
+	req, err := b.presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(contentLength),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return nil, err
+	}
+
+	// the bucket only enforces what is signed
+	for _, h := range []string{"Content-Type", "Content-Length"} {
+		if _, ok := req.SignedHeader[h]; !ok {
+			return nil, fmt.Errorf("storage: %s is not signed", strings.ToLower(h))
+		}
+	}
+
+	return &PresignedRequest{Method: req.Method, URL: req.URL, Header: req.SignedHeader}, nil
+}
+
+func (b *S3) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	out, err := b.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(out.UploadId), nil
+}
+
+func (b *S3) PresignPart(ctx context.Context, key, uploadID string, partNumber int32, expiry time.Duration) (*PresignedRequest, error) {
+	req, err := b.presigner.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(b.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedRequest{Method: req.Method, URL: req.URL, Header: req.SignedHeader}, nil
+}
+
+func (b *S3) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	var parts []Part
+
+	paginator := s3.NewListPartsPaginator(b.client, &s3.ListPartsInput{
+		Bucket:   aws.String(b.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, s3Error(err)
+		}
+
+		for _, p := range page.Parts {
+			parts = append(parts, Part{
+				PartNumber:   aws.ToInt32(p.PartNumber),
+				ETag:         aws.ToString(p.ETag),
+				Size:         aws.ToInt64(p.Size),
+				LastModified: aws.ToTime(p.LastModified),
+			})
+		}
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	return parts, nil
+}
+
+func (b *S3) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		})
+	}
+
+	_, err := b.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+
+	return s3Error(err)
+}
+
+func (b *S3) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	_, err := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	if err = s3Error(err); errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (b *S3) ListMultipart(ctx context.Context, before time.Time) ([]PendingUpload, error) {
+	var uploads []PendingUpload
+
+	paginator := s3.NewListMultipartUploadsPaginator(b.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(b.bucket),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, u := range page.Uploads {
+			initiated := aws.ToTime(u.Initiated)
+			if initiated.Before(before) {
+				uploads = append(uploads, PendingUpload{
+					Key:       aws.ToString(u.Key),
+					UploadID:  aws.ToString(u.UploadId),
+					Initiated: initiated,
+				})
+			}
+		}
+	}
+
+	return uploads, nil
+}
@@ -0,0 +1,123 @@
+/*
+Package storage provides the object storage backends the proxy stores file
+content in.
+
+Objects are addressed by key, which for files is their CID. Backends also
+support presigned requests, so that clients can transfer content directly,
+and multipart uploads for files too large to send in a single request.
+*/
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an object or multipart upload does not exist.
+	ErrNotFound = errors.New("storage: not found")
+	// ErrInvalidKey is returned for object keys that cannot be stored.
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// StagingPrefix is the prefix of the keys content is stored under until it
+// is verified against its CID. Staged objects are never referenced by files.
+const StagingPrefix = "staging/"
+
+var (
+	_ Backend = (*S3)(nil)
+	_ Backend = (*Local)(nil)
+)
+
+// Backend stores objects and issues presigned requests for them.
+type Backend interface {
+	// Put stores the content of body under key, replacing any existing object.
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get opens an object for reading, or only the given range of it if rng
+	// is not nil. The caller is responsible for closing the returned reader.
+	Get(ctx context.Context, key string, rng *Range) (io.ReadCloser, error)
+	// Head returns the metadata of an object.
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+	// Copy copies the object src to dst, replacing any existing object.
+	Copy(ctx context.Context, src, dst string) error
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every object whose key starts with prefix.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+
+	// PresignGet returns a request that downloads an object.
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error)
+	// PresignPut returns a request that uploads an object. The content type
+	// and length are part of the signature, so any other upload is rejected.
+	PresignPut(ctx context.Context, key, contentType string, contentLength int64, expiry time.Duration) (*PresignedRequest, error)
+
+	// CreateMultipart initiates a multipart upload and returns its upload id.
+	CreateMultipart(ctx context.Context, key, contentType string) (string, error)
+	// PresignPart returns a request that uploads one part of a multipart upload.
+	PresignPart(ctx context.Context, key, uploadID string, partNumber int32, expiry time.Duration) (*PresignedRequest, error)
+	// ListParts returns the parts received so far, ordered by part number.
+	ListParts(ctx context.Context, key, uploadID string) ([]Part, error)
+	// CompleteMultipart assembles the given parts into the final object.
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error
+	// AbortMultipart discards a multipart upload and its parts. Aborting an
+	// upload that no longer exists is not an error.
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+	// ListMultipart returns the multipart uploads initiated before the given time.
+	ListMultipart(ctx context.Context, before time.Time) ([]PendingUpload, error)
+}
+
+// ObjectInfo is the metadata of a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+}
+
+// Range is an inclusive range of bytes within an object.
+type Range struct {
+	Start, End int64
+}
+
+// Header returns the range in the format of the Range request header.
+func (r Range) Header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
+// Part is a part a backend has received for a multipart upload.
+type Part struct {
+	PartNumber   int32
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+// PendingUpload is a multipart upload that was initiated but not completed or aborted.
+type PendingUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// PresignedRequest is a request a client can make without other credentials.
+// The headers must be sent as given.
+type PresignedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+}
+
+// validKey checks that a key can be used as a relative path on every backend.
+func validKey(key string) error {
+	if key == "" || !fs.ValidPath(key) {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	return nil
+}
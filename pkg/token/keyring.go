@@ -0,0 +1,60 @@
+package token
+
+import (
+	"fmt"
+
+	"github.com/Hello-Storage/hello-storage-proxy/pkg/rnd"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Keyring holds the symmetric keys of a PasetoMaker by key ID: the active key
+// new tokens are encrypted with, and retired keys still accepted when
+// verifying tokens. Rotating the active key into the retired ones keeps the
+// tokens issued with it valid.
+type Keyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewKeyring returns a keyring encrypting with activeKey, identified by
+// activeID, and also decrypting with the retired keys.
+func NewKeyring(activeID, activeKey string, retired map[string]string) (*Keyring, error) {
+	if activeID == "" {
+		return nil, fmt.Errorf("active key needs an id")
+	}
+
+	k := &Keyring{
+		activeID: activeID,
+		keys:     make(map[string][]byte, len(retired)+1),
+	}
+
+	for id, key := range retired {
+		if err := k.add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := k.add(activeID, activeKey); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) add(id, key string) error {
+	if len(key) != chacha20poly1305.KeySize {
+		return fmt.Errorf("invalid size of key %q: must be exactly %d characters", id, chacha20poly1305.KeySize)
+	}
+
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key id %q", id)
+	}
+
+	k.keys[id] = []byte(key)
+	return nil
+}
+
+// GenerateKey returns a new random symmetric key.
+func GenerateKey() string {
+	return rnd.GenerateRandomString(chacha20poly1305.KeySize)
+}
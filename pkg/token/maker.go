@@ -6,15 +6,22 @@ import (
 
 // Maker is an interface for managing tokens
 type Maker interface {
-	// CreateToken creates a new token for a specific username and duration
-	CreateToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error)
+	// CreateAccessToken creates a new access token for a specific username and duration
+	CreateAccessToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error)
 
-	// VerifyToken checks if the token is valid or not
-	VerifyToken(token string) (*Payload, error)
+	// CreateRefreshToken creates a new refresh token for a specific username and duration
+	CreateRefreshToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error)
 
-	// CreateApiKey creates a new apikey for a specific username
-	CreateApiKey(user_id uint, user_uid, user_name string) (string, *Payload, error)
+	// CreateApiKey creates a new apikey for a specific username, granting the
+	// given scopes, valid for duration or without expiration if it is zero
+	CreateApiKey(user_id uint, user_uid, user_name string, duration time.Duration, scopes []Scope) (string, *Payload, error)
 
-	// VerifyToken checks if the token is valid or not
+	// VerifyAccessToken checks if the access token is valid or not
+	VerifyAccessToken(token string) (*Payload, error)
+
+	// VerifyRefreshToken checks if the refresh token is valid or not
+	VerifyRefreshToken(token string) (*Payload, error)
+
+	// VerifyApiKey checks if the apikey is valid or not
 	VerifyApiKey(apikey string) (*Payload, error)
 }
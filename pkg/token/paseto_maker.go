@@ -5,48 +5,95 @@ import (
 	"time"
 
 	"github.com/o1egl/paseto"
-	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// footer is the unencrypted footer of a token, naming the key it was
+// encrypted with.
+type footer struct {
+	KeyID string `json:"kid"`
+}
+
 type PasetoMaker struct {
-	paseto       *paseto.V2
-	symmetricKey []byte
+	paseto  *paseto.V2
+	keyring *Keyring
 }
 
 // NewPasetoMaker creates a new PasetoMaker
-func NewPasetoMaker(symmetricKey string) (Maker, error) {
-	if len(symmetricKey) != chacha20poly1305.KeySize {
-		return nil, fmt.Errorf("invalid key size: must be exactly %d characters", chacha20poly1305.KeySize)
+func NewPasetoMaker(keyring *Keyring) (Maker, error) {
+	if keyring == nil {
+		return nil, fmt.Errorf("paseto maker needs a keyring")
 	}
 
 	maker := &PasetoMaker{
-		paseto:       paseto.NewV2(),
-		symmetricKey: []byte(symmetricKey),
+		paseto:  paseto.NewV2(),
+		keyring: keyring,
 	}
 
 	return maker, nil
 }
 
-// CreateToken creates a new token for a specific user and duration
-func (maker *PasetoMaker) CreateToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error) {
-	payload, err := NewPayload(user_id, user_uid, user_name, duration)
+// CreateAccessToken creates a new access token for a specific user and duration
+func (maker *PasetoMaker) CreateAccessToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error) {
+	return maker.create(PurposeAccess, user_id, user_uid, user_name, duration, nil)
+}
+
+// CreateRefreshToken creates a new refresh token for a specific user and duration
+func (maker *PasetoMaker) CreateRefreshToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error) {
+	return maker.create(PurposeRefresh, user_id, user_uid, user_name, duration, nil)
+}
+
+// CreateApiKey creates a new API key for a specific user
+func (maker *PasetoMaker) CreateApiKey(user_id uint, user_uid, user_name string, duration time.Duration, scopes []Scope) (string, *Payload, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("api key needs at least one scope")
+	}
+
+	//duration of 0 for no expiration
+	return maker.create(PurposeApiKey, user_id, user_uid, user_name, duration, scopes)
+}
+
+// VerifyAccessToken checks if the access token is valid or not
+func (maker *PasetoMaker) VerifyAccessToken(token string) (*Payload, error) {
+	return maker.verify(token, PurposeAccess)
+}
+
+// VerifyRefreshToken checks if the refresh token is valid or not
+func (maker *PasetoMaker) VerifyRefreshToken(token string) (*Payload, error) {
+	return maker.verify(token, PurposeRefresh)
+}
+
+// VerifyApiKey checks if the API key is valid or not. Keys created before
+// tokens had a purpose are accepted as well; they are only usable while
+// stored in the api_keys table.
+func (maker *PasetoMaker) VerifyApiKey(apiKey string) (*Payload, error) {
+	return maker.verify(apiKey, PurposeApiKey, "")
+}
+
+func (maker *PasetoMaker) create(purpose Purpose, user_id uint, user_uid, user_name string, duration time.Duration, scopes []Scope) (string, *Payload, error) {
+	payload, err := NewPayload(purpose, user_id, user_uid, user_name, duration)
 	if err != nil {
 		return "", payload, err
 	}
+	payload.Scopes = scopes
 
-	token, err := maker.paseto.Encrypt(maker.symmetricKey, payload, nil)
+	keyID := maker.keyring.activeID
+	token, err := maker.paseto.Encrypt(maker.keyring.keys[keyID], payload, footer{KeyID: keyID})
 	return token, payload, err
 }
 
-// VerifyToken checks if the token is valid or not
-func (maker *PasetoMaker) VerifyToken(token string) (*Payload, error) {
+// verify decrypts a token and checks that it is valid for one of the purposes.
+func (maker *PasetoMaker) verify(token string, purposes ...Purpose) (*Payload, error) {
 	payload := &Payload{}
 
-	err := maker.paseto.Decrypt(token, maker.symmetricKey, payload, nil)
+	err := maker.decrypt(token, payload)
 	if err != nil {
 		return nil, ErrInvalidToken
 	}
 
+	if err := payload.checkPurpose(purposes...); err != nil {
+		return nil, err
+	}
+
 	err = payload.Valid()
 	if err != nil {
 		return nil, err
@@ -55,31 +102,28 @@ func (maker *PasetoMaker) VerifyToken(token string) (*Payload, error) {
 	return payload, nil
 }
 
-// CreateApiKey creates a new API key for a specific user
-func (maker *PasetoMaker) CreateApiKey(user_id uint, user_uid, user_name string) (string, *Payload, error) {
-	//duration of 0 for no expiration
-	payload, err := NewPayload(user_id, user_uid, user_name, 0)
-	if err != nil {
-		return "", payload, err
+// decrypt decrypts a token with the key named in its footer. Tokens issued
+// before keys had IDs have no footer and are tried with every key.
+func (maker *PasetoMaker) decrypt(token string, payload *Payload) error {
+	var f footer
+	if err := paseto.ParseFooter(token, &f); err != nil {
+		return err
 	}
 
-	apiKey, err := maker.paseto.Encrypt(maker.symmetricKey, payload, nil)
-	return apiKey, payload, err
-}
-
-// VerifyApiKey checks if the API key is valid or not
-func (maker *PasetoMaker) VerifyApiKey(apiKey string) (*Payload, error) {
-	payload := &Payload{}
+	if f.KeyID != "" {
+		key, ok := maker.keyring.keys[f.KeyID]
+		if !ok {
+			return ErrInvalidToken
+		}
 
-	err := maker.paseto.Decrypt(apiKey, maker.symmetricKey, payload, nil)
-	if err != nil {
-		return nil, ErrInvalidToken
+		return maker.paseto.Decrypt(token, key, payload, nil)
 	}
 
-	err = payload.Valid()
-	if err != nil {
-		return nil, err
+	for _, key := range maker.keyring.keys {
+		if err := maker.paseto.Decrypt(token, key, payload, nil); err == nil {
+			return nil
+		}
 	}
 
-	return payload, nil
+	return ErrInvalidToken
 }
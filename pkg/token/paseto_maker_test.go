@@ -0,0 +1,135 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "12345678901234567890123456789012"
+
+func newTestMaker(t *testing.T, activeID, activeKey string, retired map[string]string) *PasetoMaker {
+	keyring, err := NewKeyring(activeID, activeKey, retired)
+	require.NoError(t, err)
+
+	maker, err := NewPasetoMaker(keyring)
+	require.NoError(t, err)
+
+	return maker.(*PasetoMaker)
+}
+
+func TestPasetoMakerPurpose(t *testing.T) {
+	maker := newTestMaker(t, "1", testKey, nil)
+
+	accessToken, _, err := maker.CreateAccessToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+	refreshToken, _, err := maker.CreateRefreshToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+	apiKey, _, err := maker.CreateApiKey(1, "u1", "user", 0, Scopes)
+	require.NoError(t, err)
+
+	// keys issued before tokens had a purpose
+	legacy, err := NewPayload("", 1, "u1", "user", 0)
+	require.NoError(t, err)
+	legacyKey, err := maker.paseto.Encrypt([]byte(testKey), legacy, nil)
+	require.NoError(t, err)
+
+	// sessions issued before tokens had a purpose are logged out; as API
+	// keys they are rejected by the api_keys lookup, not by the maker
+	legacySession, err := NewPayload("", 1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+	legacyToken, err := maker.paseto.Encrypt([]byte(testKey), legacySession, nil)
+	require.NoError(t, err)
+
+	verifiers := map[Purpose]func(string) (*Payload, error){
+		PurposeAccess:  maker.VerifyAccessToken,
+		PurposeRefresh: maker.VerifyRefreshToken,
+		PurposeApiKey:  maker.VerifyApiKey,
+	}
+
+	testCases := []struct {
+		name    string
+		token   string
+		purpose Purpose
+	}{
+		{"access token", accessToken, PurposeAccess},
+		{"refresh token", refreshToken, PurposeRefresh},
+		{"api key", apiKey, PurposeApiKey},
+		{"legacy api key", legacyKey, PurposeApiKey},
+		{"legacy session token", legacyToken, PurposeApiKey},
+	}
+
+	for _, tc := range testCases {
+		for purpose, verify := range verifiers {
+			t.Run(tc.name+" as "+string(purpose), func(t *testing.T) {
+				payload, err := verify(tc.token)
+				if purpose != tc.purpose {
+					require.ErrorIs(t, err, ErrWrongPurpose)
+					return
+				}
+
+				require.NoError(t, err)
+				require.Equal(t, uint(1), payload.UserID)
+			})
+		}
+	}
+}
+
+func TestPasetoMakerExpired(t *testing.T) {
+	maker := newTestMaker(t, "1", testKey, nil)
+
+	token, _, err := maker.CreateAccessToken(1, "u1", "user", -time.Minute)
+	require.NoError(t, err)
+
+	_, err = maker.VerifyAccessToken(token)
+	require.ErrorIs(t, err, ErrExpiredToken)
+
+	_, err = maker.VerifyAccessToken(token + "x")
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestPasetoMakerKeyRotation(t *testing.T) {
+	const nextKey = "abcdefghijklmnopqrstuvwxyz012345"
+
+	previous := newTestMaker(t, "1", testKey, nil)
+	rotated := newTestMaker(t, "2", nextKey, map[string]string{"1": testKey})
+	retiredRemoved := newTestMaker(t, "2", nextKey, nil)
+
+	issued, _, err := previous.CreateAccessToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+
+	_, err = rotated.VerifyAccessToken(issued)
+	require.NoError(t, err)
+
+	_, err = retiredRemoved.VerifyAccessToken(issued)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	issued, _, err = rotated.CreateAccessToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+
+	_, err = previous.VerifyAccessToken(issued)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// tokens issued before keys had IDs
+	legacy, err := NewPayload(PurposeAccess, 1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+	issued, err = previous.paseto.Encrypt([]byte(testKey), legacy, nil)
+	require.NoError(t, err)
+
+	_, err = rotated.VerifyAccessToken(issued)
+	require.NoError(t, err)
+}
+
+func TestNewKeyring(t *testing.T) {
+	_, err := NewKeyring("", testKey, nil)
+	require.Error(t, err)
+
+	_, err = NewKeyring("1", "short", nil)
+	require.Error(t, err)
+
+	_, err = NewKeyring("1", testKey, map[string]string{"1": testKey})
+	require.Error(t, err)
+
+	require.Len(t, GenerateKey(), len(testKey))
+}
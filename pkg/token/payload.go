@@ -2,15 +2,27 @@ package token
 
 import (
 	"errors"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// Different types of error returned by the VerifyToken function
+// Different types of error returned by the Maker verify functions
 var (
 	ErrInvalidToken = errors.New("token is invalid")
 	ErrExpiredToken = errors.New("token has expired")
+	ErrWrongPurpose = errors.New("token is not valid for this purpose")
+)
+
+// Purpose is what a token can be used for. Each Maker verify method only
+// accepts tokens of its purpose.
+type Purpose string
+
+const (
+	PurposeAccess  Purpose = "access"
+	PurposeRefresh Purpose = "refresh"
+	PurposeApiKey  Purpose = "api_key"
 )
 
 // Payload contains the payload data of the token
@@ -21,10 +33,12 @@ type Payload struct {
 	UserName  string    `json:"name"`
 	IssuedAt  time.Time `json:"issued_at"`
 	ExpiredAt time.Time `json:"expired_at"`
+	Purpose   Purpose   `json:"purpose"`
+	Scopes    []Scope   `json:"scopes,omitempty"` // set for API keys only
 }
 
-// NewPayload creates a new token payload with a specific username and duration
-func NewPayload(user_id uint, user_uid, user_name string, duration time.Duration) (*Payload, error) {
+// NewPayload creates a new token payload for a purpose with a specific username and duration
+func NewPayload(purpose Purpose, user_id uint, user_uid, user_name string, duration time.Duration) (*Payload, error) {
 	tokenID, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
@@ -46,6 +60,7 @@ func NewPayload(user_id uint, user_uid, user_name string, duration time.Duration
 		UserName:  user_name,
 		IssuedAt:  time.Now(),
 		ExpiredAt: expirationDate,
+		Purpose:   purpose,
 	}
 	return payload, nil
 }
@@ -61,3 +76,18 @@ func (payload *Payload) Valid() error {
 	}
 	return nil
 }
+
+// checkPurpose returns ErrWrongPurpose unless the payload has one of the purposes.
+// Tokens issued before tokens had a purpose have none, and there is no
+// transition window for them: refresh tokens and API keys without a purpose
+// cannot be told apart from access tokens, so accepting them would let those
+// be used as access tokens. Users are logged out once when upgrading; their
+// refresh tokens are not sessions either, so they could not be renewed anyway.
+// Legacy API keys stay valid, see VerifyApiKey.
+func (payload *Payload) checkPurpose(purposes ...Purpose) error {
+	if !slices.Contains(purposes, payload.Purpose) {
+		return ErrWrongPurpose
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/o1egl/paseto"
+)
+
+// SigningKeys holds the Ed25519 keys of a PublicMaker by key ID: the active
+// private key new tokens are signed with, and the public keys tokens are
+// verified with, including those of retired keys.
+type SigningKeys struct {
+	activeID string
+	private  ed25519.PrivateKey
+	public   map[string]ed25519.PublicKey
+}
+
+// NewSigningKeys returns the signing keys for the hex encoded Ed25519 seed
+// activeSeed, identified by activeID, and the hex encoded public keys of
+// retired keys.
+func NewSigningKeys(activeID, activeSeed string, retired map[string]string) (*SigningKeys, error) {
+	if activeID == "" {
+		return nil, fmt.Errorf("active key needs an id")
+	}
+
+	seed, err := hex.DecodeString(activeSeed)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key: must be %d hex encoded bytes", ed25519.SeedSize)
+	}
+
+	k := &SigningKeys{
+		activeID: activeID,
+		private:  ed25519.NewKeyFromSeed(seed),
+		public:   make(map[string]ed25519.PublicKey, len(retired)+1),
+	}
+
+	for id, key := range retired {
+		public, err := hex.DecodeString(key)
+		if err != nil || len(public) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q: must be %d hex encoded bytes", id, ed25519.PublicKeySize)
+		}
+
+		k.public[id] = public
+	}
+
+	if _, ok := k.public[activeID]; ok {
+		return nil, fmt.Errorf("duplicate key id %q", activeID)
+	}
+	k.public[activeID] = k.private.Public().(ed25519.PublicKey)
+
+	return k, nil
+}
+
+// GenerateSigningKey returns a new hex encoded Ed25519 seed and its public key.
+func GenerateSigningKey() (seed, public string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(priv.Seed()), hex.EncodeToString(pub), nil
+}
+
+// Versions of public tokens. v4 is the current version of PASETO; v2 is
+// still supported for verifiers that only implement it.
+const (
+	PublicV2 = "v2"
+	PublicV4 = "v4"
+)
+
+// PublicKey is a key other services verify tokens with. Key is hex encoded;
+// PASERK is the same key in the PASERK format of the version.
+type PublicKey struct {
+	ID      string `json:"kid"`
+	Version string `json:"version"`
+	Purpose string `json:"purpose"`
+	Key     string `json:"key"`
+	PASERK  string `json:"paserk"`
+	Active  bool   `json:"active"`
+}
+
+// PublicKeys returns the keys tokens are verified with, sorted by id. Each key
+// is listed for v4 and v2, as tokens of both versions are accepted.
+func (k *SigningKeys) PublicKeys() []PublicKey {
+	keys := make([]PublicKey, 0, 2*len(k.public))
+	for id, public := range k.public {
+		for _, version := range []string{PublicV4, PublicV2} {
+			keys = append(keys, PublicKey{
+				ID:      id,
+				Version: version,
+				Purpose: "public",
+				Key:     hex.EncodeToString(public),
+				PASERK:  "k" + strings.TrimPrefix(version, "v") + ".public." + base64.RawURLEncoding.EncodeToString(public),
+				Active:  id == k.activeID,
+			})
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ID != keys[j].ID {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Version > keys[j].Version
+	})
+	return keys
+}
+
+// PublicMaker signs tokens with Ed25519 (v4.public or v2.public), so that
+// other services can verify them with the published public keys without being
+// able to mint them. Tokens of both versions are verified, so that switching
+// the version does not invalidate issued tokens. Local tokens issued before
+// switching to it, such as API keys, are still accepted if a keyring is given.
+type PublicMaker struct {
+	version   string
+	protocols map[string]publicProtocol
+	keys      *SigningKeys
+	local     *PasetoMaker
+}
+
+// NewPublicMaker creates a new PublicMaker signing tokens of the version. The
+// keyring is optional.
+func NewPublicMaker(version string, keys *SigningKeys, keyring *Keyring) (Maker, error) {
+	if version != PublicV2 && version != PublicV4 {
+		return nil, fmt.Errorf("unsupported public token version %q", version)
+	}
+
+	if keys == nil {
+		return nil, fmt.Errorf("public maker needs signing keys")
+	}
+
+	maker := &PublicMaker{
+		version: version,
+		protocols: map[string]publicProtocol{
+			PublicV2: paseto.NewV2(),
+			PublicV4: v4Public{},
+		},
+		keys: keys,
+	}
+
+	if keyring != nil {
+		local, err := NewPasetoMaker(keyring)
+		if err != nil {
+			return nil, err
+		}
+		maker.local = local.(*PasetoMaker)
+	}
+
+	return maker, nil
+}
+
+// PublicKeys returns the keys tokens are verified with.
+func (maker *PublicMaker) PublicKeys() []PublicKey {
+	return maker.keys.PublicKeys()
+}
+
+// CreateAccessToken creates a new access token for a specific user and duration
+func (maker *PublicMaker) CreateAccessToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error) {
+	return maker.create(PurposeAccess, user_id, user_uid, user_name, duration, nil)
+}
+
+// CreateRefreshToken creates a new refresh token for a specific user and duration
+func (maker *PublicMaker) CreateRefreshToken(user_id uint, user_uid, user_name string, duration time.Duration) (string, *Payload, error) {
+	return maker.create(PurposeRefresh, user_id, user_uid, user_name, duration, nil)
+}
+
+// CreateApiKey creates a new API key for a specific user
+func (maker *PublicMaker) CreateApiKey(user_id uint, user_uid, user_name string, duration time.Duration, scopes []Scope) (string, *Payload, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("api key needs at least one scope")
+	}
+
+	return maker.create(PurposeApiKey, user_id, user_uid, user_name, duration, scopes)
+}
+
+// VerifyAccessToken checks if the access token is valid or not
+func (maker *PublicMaker) VerifyAccessToken(token string) (*Payload, error) {
+	return maker.verify(token, PurposeAccess)
+}
+
+// VerifyRefreshToken checks if the refresh token is valid or not
+func (maker *PublicMaker) VerifyRefreshToken(token string) (*Payload, error) {
+	return maker.verify(token, PurposeRefresh)
+}
+
+// VerifyApiKey checks if the API key is valid or not, accepting local keys
+// created before tokens had a purpose like PasetoMaker does.
+func (maker *PublicMaker) VerifyApiKey(apiKey string) (*Payload, error) {
+	if maker.local != nil && isLocalToken(apiKey) {
+		return maker.local.VerifyApiKey(apiKey)
+	}
+
+	return maker.verify(apiKey, PurposeApiKey)
+}
+
+func (maker *PublicMaker) create(purpose Purpose, user_id uint, user_uid, user_name string, duration time.Duration, scopes []Scope) (string, *Payload, error) {
+	payload, err := NewPayload(purpose, user_id, user_uid, user_name, duration)
+	if err != nil {
+		return "", payload, err
+	}
+	payload.Scopes = scopes
+
+	token, err := maker.protocols[maker.version].Sign(maker.keys.private, payload, footer{KeyID: maker.keys.activeID})
+	return token, payload, err
+}
+
+// verify checks the signature of a token with the key named in its footer,
+// using the protocol of the version in its header, and that it is valid for
+// the purpose.
+func (maker *PublicMaker) verify(token string, purpose Purpose) (*Payload, error) {
+	if maker.local != nil && isLocalToken(token) {
+		return maker.local.verify(token, purpose)
+	}
+
+	protocol, ok := maker.protocols[strings.SplitN(token, ".", 2)[0]]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	var f footer
+	if err := paseto.ParseFooter(token, &f); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	public, ok := maker.keys.public[f.KeyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	payload := &Payload{}
+	if err := protocol.Verify(token, public, payload, nil); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := payload.checkPurpose(purpose); err != nil {
+		return nil, err
+	}
+
+	if err := payload.Valid(); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+func isLocalToken(token string) bool {
+	return strings.HasPrefix(token, "v2.local.")
+}
@@ -0,0 +1,155 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/o1egl/paseto"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPublicMaker(t *testing.T, activeID string, retired map[string]string, keyring *Keyring) (*PublicMaker, string) {
+	seed, public, err := GenerateSigningKey()
+	require.NoError(t, err)
+
+	return newTestPublicMakerWithSeed(t, PublicV4, activeID, seed, retired, keyring), public
+}
+
+func newTestPublicMakerWithSeed(t *testing.T, version, activeID, seed string, retired map[string]string, keyring *Keyring) *PublicMaker {
+	keys, err := NewSigningKeys(activeID, seed, retired)
+	require.NoError(t, err)
+
+	maker, err := NewPublicMaker(version, keys, keyring)
+	require.NoError(t, err)
+
+	return maker.(*PublicMaker)
+}
+
+func TestPublicMaker(t *testing.T) {
+	maker, public := newTestPublicMaker(t, "1", nil, nil)
+
+	accessToken, _, err := maker.CreateAccessToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+	require.Contains(t, accessToken, "v4.public.")
+
+	payload, err := maker.VerifyAccessToken(accessToken)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), payload.UserID)
+
+	_, err = maker.VerifyRefreshToken(accessToken)
+	require.ErrorIs(t, err, ErrWrongPurpose)
+	_, err = maker.VerifyApiKey(accessToken)
+	require.ErrorIs(t, err, ErrWrongPurpose)
+
+	// other services only need the published key
+	keys := maker.PublicKeys()
+	require.Len(t, keys, 2)
+	require.Equal(t, PublicV4, keys[0].Version)
+	require.Equal(t, public, keys[0].Key)
+	require.True(t, keys[0].Active)
+	require.Equal(t, PublicV2, keys[1].Version)
+	require.Equal(t, public, keys[1].Key)
+
+	key, err := hex.DecodeString(keys[0].Key)
+	require.NoError(t, err)
+	require.NoError(t, v4Public{}.Verify(accessToken, ed25519.PublicKey(key), &Payload{}, nil))
+
+	// after rotating, tokens signed with the retired key stay valid
+	rotated, _ := newTestPublicMaker(t, "2", map[string]string{"1": public}, nil)
+	_, err = rotated.VerifyAccessToken(accessToken)
+	require.NoError(t, err)
+	require.Len(t, rotated.PublicKeys(), 4)
+
+	other, _ := newTestPublicMaker(t, "1", nil, nil)
+	_, err = other.VerifyAccessToken(accessToken)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestPublicMakerLocalTokens(t *testing.T) {
+	local := newTestMaker(t, "1", testKey, nil)
+
+	apiKey, _, err := local.CreateApiKey(1, "u1", "user", 0, Scopes)
+	require.NoError(t, err)
+
+	withoutKeyring, _ := newTestPublicMaker(t, "1", nil, nil)
+	_, err = withoutKeyring.VerifyApiKey(apiKey)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	withKeyring, _ := newTestPublicMaker(t, "1", nil, local.keyring)
+	_, err = withKeyring.VerifyApiKey(apiKey)
+	require.NoError(t, err)
+	_, err = withKeyring.VerifyAccessToken(apiKey)
+	require.ErrorIs(t, err, ErrWrongPurpose)
+}
+
+func TestPublicMakerVersions(t *testing.T) {
+	seed, public, err := GenerateSigningKey()
+	require.NoError(t, err)
+
+	v2 := newTestPublicMakerWithSeed(t, PublicV2, "1", seed, nil, nil)
+	v4 := newTestPublicMakerWithSeed(t, PublicV4, "1", seed, nil, nil)
+
+	v2Token, _, err := v2.CreateAccessToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+	require.Contains(t, v2Token, "v2.public.")
+
+	key, err := hex.DecodeString(public)
+	require.NoError(t, err)
+	require.NoError(t, paseto.NewV2().Verify(v2Token, ed25519.PublicKey(key), &Payload{}, nil))
+
+	// switching the version keeps issued tokens valid, in both directions
+	v4Token, _, err := v4.CreateAccessToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+
+	for _, token := range []string{v2Token, v4Token} {
+		for _, maker := range []*PublicMaker{v2, v4} {
+			payload, err := maker.VerifyAccessToken(token)
+			require.NoError(t, err)
+			require.Equal(t, uint(1), payload.UserID)
+		}
+	}
+
+	// a v2 signature does not verify as v4
+	forged := "v4.public." + strings.TrimPrefix(v2Token, "v2.public.")
+	_, err = v4.VerifyAccessToken(forged)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = v4.VerifyAccessToken("v3.public." + strings.TrimPrefix(v4Token, "v4.public."))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = NewPublicMaker("v3", v4.keys, nil)
+	require.Error(t, err)
+}
+
+func TestV4PublicVector(t *testing.T) {
+	// test vector 4-S-1 of the PASETO specification
+	secret, err := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	require.NoError(t, err)
+	message := `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+	expected := "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+
+	token, err := v4Public{}.Sign(ed25519.PrivateKey(secret), message, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, token)
+
+	var payload string
+	require.NoError(t, v4Public{}.Verify(token, ed25519.PrivateKey(secret).Public(), &payload, nil))
+	require.Equal(t, message, payload)
+}
+
+func TestNewSigningKeys(t *testing.T) {
+	seed, public, err := GenerateSigningKey()
+	require.NoError(t, err)
+
+	_, err = NewSigningKeys("1", "not hex", nil)
+	require.Error(t, err)
+
+	_, err = NewSigningKeys("1", seed, map[string]string{"0": "abcd"})
+	require.Error(t, err)
+
+	_, err = NewSigningKeys("1", seed, map[string]string{"1": public})
+	require.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package token
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Scope is a permission an API key can be granted.
+type Scope string
+
+const (
+	ScopeFilesRead  Scope = "files:read"
+	ScopeFilesWrite Scope = "files:write"
+	// ScopeSharesManage is required by the routes publishing, unpublishing
+	// and sharing files, which the proxy does not serve yet.
+	ScopeSharesManage Scope = "shares:manage"
+	ScopeAccountRead  Scope = "account:read"
+)
+
+// Scopes lists all scopes.
+var Scopes = []Scope{ScopeFilesRead, ScopeFilesWrite, ScopeSharesManage, ScopeAccountRead}
+
+// ReadScopes are the scopes of keys created without any, which can only read.
+var ReadScopes = []Scope{ScopeFilesRead, ScopeAccountRead}
+
+// ParseScopes checks that every name is a known scope.
+func ParseScopes(names []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		if !slices.Contains(Scopes, Scope(name)) {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		if !slices.Contains(scopes, Scope(name)) {
+			scopes = append(scopes, Scope(name))
+		}
+	}
+
+	return scopes, nil
+}
+
+// HasScope reports whether the payload grants a scope. Payloads without
+// scopes, i.e. those of login sessions and of keys created before scopes
+// existed, grant all of them.
+func (payload *Payload) HasScope(scope Scope) bool {
+	return payload.Scopes == nil || slices.Contains(payload.Scopes, scope)
+}
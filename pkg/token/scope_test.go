@@ -0,0 +1,41 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"files:write", "files:read", "files:write"})
+	require.NoError(t, err)
+	require.Equal(t, []Scope{ScopeFilesWrite, ScopeFilesRead}, scopes)
+
+	_, err = ParseScopes([]string{"files:delete"})
+	require.Error(t, err)
+
+	scopes, err = ParseScopes([]string{"shares:manage"})
+	require.NoError(t, err)
+	require.Equal(t, []Scope{ScopeSharesManage}, scopes)
+	require.NotContains(t, ReadScopes, ScopeSharesManage)
+}
+
+func TestApiKeyScopes(t *testing.T) {
+	maker := newTestMaker(t, "1", testKey, nil)
+
+	key, _, err := maker.CreateApiKey(1, "u1", "user", time.Hour, []Scope{ScopeFilesWrite})
+	require.NoError(t, err)
+
+	payload, err := maker.VerifyApiKey(key)
+	require.NoError(t, err)
+	require.True(t, payload.HasScope(ScopeFilesWrite))
+	require.False(t, payload.HasScope(ScopeAccountRead))
+
+	session, _, err := maker.CreateAccessToken(1, "u1", "user", time.Hour)
+	require.NoError(t, err)
+
+	payload, err = maker.VerifyAccessToken(session)
+	require.NoError(t, err)
+	require.True(t, payload.HasScope(ScopeAccountRead))
+}
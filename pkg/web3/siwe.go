@@ -0,0 +1,252 @@
+package web3
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const siweHeaderSuffix = " wants you to sign in with your Ethereum account:"
+
+// Errors returned when verifying a Sign-In with Ethereum message.
+var (
+	ErrSiweMalformed   = errors.New("siwe: malformed message")
+	ErrSiweDomain      = errors.New("siwe: domain mismatch")
+	ErrSiweURI         = errors.New("siwe: uri mismatch")
+	ErrSiweChain       = errors.New("siwe: chain not supported")
+	ErrSiweExpired     = errors.New("siwe: message has expired")
+	ErrSiweNotYetValid = errors.New("siwe: message is not yet valid")
+)
+
+// SiweClockSkew is the tolerance for clock differences with the client.
+const SiweClockSkew = time.Minute
+
+// SiweMessage is a Sign-In with Ethereum message as defined by EIP-4361.
+type SiweMessage struct {
+	Scheme         string
+	Domain         string
+	Address        string
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// SiweOptions are the values a message has to match.
+type SiweOptions struct {
+	Domain   string
+	URI      string // messages must have a uri of the same origin
+	ChainIDs []int64
+}
+
+// String formats the message to be signed.
+func (m *SiweMessage) String() string {
+	var b strings.Builder
+
+	if m.Scheme != "" {
+		b.WriteString(m.Scheme + "://")
+	}
+	b.WriteString(m.Domain + siweHeaderSuffix + "\n")
+	b.WriteString(m.Address + "\n\n")
+	if m.Statement != "" {
+		b.WriteString(m.Statement + "\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString("URI: " + m.URI + "\n")
+	b.WriteString("Version: " + m.Version + "\n")
+	b.WriteString("Chain ID: " + strconv.FormatInt(m.ChainID, 10) + "\n")
+	b.WriteString("Nonce: " + m.Nonce + "\n")
+	b.WriteString("Issued At: " + m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		b.WriteString("\nExpiration Time: " + m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.NotBefore != nil {
+		b.WriteString("\nNot Before: " + m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if m.RequestID != "" {
+		b.WriteString("\nRequest ID: " + m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		b.WriteString("\nResources:")
+		for _, r := range m.Resources {
+			b.WriteString("\n- " + r)
+		}
+	}
+
+	return b.String()
+}
+
+// ParseSiweMessage parses a message formatted as defined by EIP-4361.
+func ParseSiweMessage(s string) (*SiweMessage, error) {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if len(lines) < 9 {
+		return nil, ErrSiweMalformed
+	}
+
+	m := &SiweMessage{}
+
+	origin, ok := strings.CutSuffix(lines[0], siweHeaderSuffix)
+	if !ok || origin == "" {
+		return nil, fmt.Errorf("%w: invalid header", ErrSiweMalformed)
+	}
+	if scheme, domain, ok := strings.Cut(origin, "://"); ok {
+		m.Scheme, origin = scheme, domain
+	}
+	m.Domain = origin
+
+	m.Address = lines[1]
+	if !common.IsHexAddress(m.Address) || common.HexToAddress(m.Address).Hex() != m.Address {
+		return nil, fmt.Errorf("%w: address must be checksummed", ErrSiweMalformed)
+	}
+
+	if lines[2] != "" {
+		return nil, ErrSiweMalformed
+	}
+
+	i := 3
+	if lines[i] != "" {
+		m.Statement = lines[i]
+		i++
+	}
+	if lines[i] != "" {
+		return nil, ErrSiweMalformed
+	}
+	i++
+
+	// field returns the value of the field on the current line, if it is the one
+	field := func(name string, required bool) (string, error) {
+		if i < len(lines) {
+			if v, ok := strings.CutPrefix(lines[i], name+": "); ok {
+				i++
+				return v, nil
+			}
+		}
+		if required {
+			return "", fmt.Errorf("%w: missing %s", ErrSiweMalformed, name)
+		}
+		return "", nil
+	}
+
+	timeField := func(name string, required bool) (*time.Time, error) {
+		v, err := field(name, required)
+		if err != nil || v == "" {
+			return nil, err
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s", ErrSiweMalformed, name)
+		}
+		return &t, nil
+	}
+
+	var err error
+	if m.URI, err = field("URI", true); err != nil {
+		return nil, err
+	}
+	if _, err := url.Parse(m.URI); err != nil {
+		return nil, fmt.Errorf("%w: invalid URI", ErrSiweMalformed)
+	}
+
+	if m.Version, err = field("Version", true); err != nil {
+		return nil, err
+	}
+	if m.Version != "1" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrSiweMalformed, m.Version)
+	}
+
+	chainID, err := field("Chain ID", true)
+	if err != nil {
+		return nil, err
+	}
+	if m.ChainID, err = strconv.ParseInt(chainID, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: invalid Chain ID", ErrSiweMalformed)
+	}
+
+	if m.Nonce, err = field("Nonce", true); err != nil {
+		return nil, err
+	}
+	if len(m.Nonce) < 8 || strings.IndexFunc(m.Nonce, func(r rune) bool {
+		return !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	}) >= 0 {
+		return nil, fmt.Errorf("%w: nonce must be at least 8 alphanumeric characters", ErrSiweMalformed)
+	}
+
+	issuedAt, err := timeField("Issued At", true)
+	if err != nil {
+		return nil, err
+	}
+	m.IssuedAt = *issuedAt
+
+	if m.ExpirationTime, err = timeField("Expiration Time", false); err != nil {
+		return nil, err
+	}
+	if m.NotBefore, err = timeField("Not Before", false); err != nil {
+		return nil, err
+	}
+	if m.RequestID, err = field("Request ID", false); err != nil {
+		return nil, err
+	}
+
+	if i < len(lines) && lines[i] == "Resources:" {
+		for i++; i < len(lines); i++ {
+			r, ok := strings.CutPrefix(lines[i], "- ")
+			if !ok {
+				break
+			}
+			m.Resources = append(m.Resources, r)
+		}
+	}
+
+	if i != len(lines) {
+		return nil, fmt.Errorf("%w: unexpected line %q", ErrSiweMalformed, lines[i])
+	}
+
+	return m, nil
+}
+
+// Verify checks that the message is meant for the relying party described by
+// opts and valid at the time now. The nonce is left to the caller.
+func (m *SiweMessage) Verify(opts SiweOptions, now time.Time) error {
+	if m.Domain != opts.Domain {
+		return ErrSiweDomain
+	}
+
+	uri, err := url.Parse(m.URI)
+	if err != nil {
+		return ErrSiweURI
+	}
+	expected, err := url.Parse(opts.URI)
+	if err != nil || uri.Scheme != expected.Scheme || uri.Host != expected.Host {
+		return ErrSiweURI
+	}
+
+	if !slices.Contains(opts.ChainIDs, m.ChainID) {
+		return ErrSiweChain
+	}
+
+	if m.IssuedAt.After(now.Add(SiweClockSkew)) {
+		return ErrSiweNotYetValid
+	}
+	if m.NotBefore != nil && m.NotBefore.After(now.Add(SiweClockSkew)) {
+		return ErrSiweNotYetValid
+	}
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return ErrSiweExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package web3
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func testSiweMessage() *SiweMessage {
+	expires := time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC)
+
+	return &SiweMessage{
+		Domain:         "app.joinhello.app",
+		Address:        "0x2C0b73164AF92a89d30Af163912B38F45b7f7b65",
+		Statement:      "Sign in to hello",
+		URI:            "https://app.joinhello.app/login",
+		Version:        "1",
+		ChainID:        1,
+		Nonce:          "Xy7dKq2pLm9aT4bR",
+		IssuedAt:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpirationTime: &expires,
+		Resources:      []string{"https://app.joinhello.app/terms"},
+	}
+}
+
+func TestParseSiweMessage(t *testing.T) {
+	m := testSiweMessage()
+
+	parsed, err := ParseSiweMessage(m.String())
+	require.NoError(t, err)
+	require.Equal(t, m, parsed)
+
+	m.Statement = ""
+	m.ExpirationTime = nil
+	m.Resources = nil
+	parsed, err = ParseSiweMessage(m.String())
+	require.NoError(t, err)
+	require.Equal(t, m, parsed)
+
+	testCases := []struct {
+		name   string
+		modify func(m *SiweMessage)
+	}{
+		{"address not checksummed", func(m *SiweMessage) { m.Address = "0x2c0b73164af92a89d30af163912b38f45b7f7b65" }},
+		{"unsupported version", func(m *SiweMessage) { m.Version = "2" }},
+		{"short nonce", func(m *SiweMessage) { m.Nonce = "abc" }},
+		{"nonce not alphanumeric", func(m *SiweMessage) { m.Nonce = "Xy7dKq2p-m9aT4bR" }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := testSiweMessage()
+			tc.modify(m)
+
+			_, err := ParseSiweMessage(m.String())
+			require.ErrorIs(t, err, ErrSiweMalformed)
+		})
+	}
+
+	_, err = ParseSiweMessage("Greetings from hello\nSign this message to log into hello\nnonce: abc")
+	require.ErrorIs(t, err, ErrSiweMalformed)
+
+	_, err = ParseSiweMessage(testSiweMessage().String() + "\nInjected: field")
+	require.ErrorIs(t, err, ErrSiweMalformed)
+}
+
+func TestSiweMessageVerify(t *testing.T) {
+	opts := SiweOptions{
+		Domain:   "app.joinhello.app",
+		URI:      "https://app.joinhello.app",
+		ChainIDs: []int64{1, 137},
+	}
+	now := time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name   string
+		modify func(m *SiweMessage)
+		now    time.Time
+		err    error
+	}{
+		{"ok", func(m *SiweMessage) {}, now, nil},
+		{"other chain", func(m *SiweMessage) { m.ChainID = 137 }, now, nil},
+		{"wrong domain", func(m *SiweMessage) { m.Domain = "evil.example" }, now, ErrSiweDomain},
+		{"wrong uri", func(m *SiweMessage) { m.URI = "https://evil.example/login" }, now, ErrSiweURI},
+		{"unsupported chain", func(m *SiweMessage) { m.ChainID = 5 }, now, ErrSiweChain},
+		{"expired", func(m *SiweMessage) {}, now.Add(10 * time.Minute), ErrSiweExpired},
+		{"issued in the future", func(m *SiweMessage) {}, now.Add(-time.Hour), ErrSiweNotYetValid},
+		{"not before", func(m *SiweMessage) {
+			notBefore := now.Add(time.Hour)
+			m.NotBefore = &notBefore
+		}, now, ErrSiweNotYetValid},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := testSiweMessage()
+			tc.modify(m)
+
+			err := m.Verify(opts, tc.now)
+			if tc.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.True(t, errors.Is(err, tc.err), err)
+			}
+		})
+	}
+}
+
+func TestSiweMessageSignature(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	m := testSiweMessage()
+	m.Address = crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	sig, err := crypto.Sign(accounts.TextHash([]byte(m.String())), key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+
+	require.True(t, ValidateMessageSignature(m.Address, hexutil.Encode(sig), []byte(m.String())))
+
+	m.Nonce = "Xy7dKq2pLm9aT4bS"
+	require.False(t, ValidateMessageSignature(m.Address, hexutil.Encode(sig), []byte(m.String())))
+}
@@ -10,7 +10,7 @@ import (
 )
 
 func ValidateMessageSignature(walletAddress, signature string, message []byte) bool {
-	if signature[0:2] != "0x" || len(signature) != 132 {
+	if !strings.HasPrefix(signature, "0x") || len(signature) != 132 {
 		log.Printf("invalid signature: %s", signature)
 		return false
 	}
@@ -0,0 +1,143 @@
+package web3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ErrInvalidSignature is returned when a signature is not valid for a wallet.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Method is how a wallet's signatures are verified. It is stored as the type
+// of the wallet.
+type Method string
+
+const (
+	// MethodEOA recovers the signer of a 65 byte signature.
+	MethodEOA Method = "eth"
+	// MethodEIP1271 asks the wallet contract whether a signature is valid.
+	MethodEIP1271 Method = "eip1271"
+)
+
+// eip1271MagicValue is returned by isValidSignature(bytes32,bytes) for valid
+// signatures; it is also the selector of the function.
+var eip1271MagicValue = []byte{0x16, 0x26, 0xba, 0x7e}
+
+// errCodeReverted is the JSON-RPC error code of calls that reverted with data.
+const errCodeReverted = 3
+
+// SignatureVerifier checks that a wallet signed a message.
+type SignatureVerifier interface {
+	// VerifySignature returns the method the signature of the EIP-191
+	// message for the chain was verified with, or ErrInvalidSignature.
+	VerifySignature(ctx context.Context, chainID int64, address, signature string, message []byte) (Method, error)
+}
+
+// ContractCaller executes eth_call requests. It is implemented by
+// ethclient.Client. Errors of the node are expected to implement rpc.Error.
+type ContractCaller interface {
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// Verifier verifies the signatures of externally owned accounts, and falls
+// back to EIP-1271 for contract wallets such as Safe if it has a caller.
+type Verifier struct {
+	caller  ContractCaller
+	chainID int64
+}
+
+// NewVerifier returns a verifier calling contracts with caller, which may be
+// nil to only verify signatures of externally owned accounts. chainID is the
+// chain caller executes calls on; contract wallets can only sign messages for
+// that chain, as the same address may be another contract on other chains.
+func NewVerifier(caller ContractCaller, chainID int64) *Verifier {
+	return &Verifier{caller: caller, chainID: chainID}
+}
+
+// VerifySignature implements SignatureVerifier.
+func (v *Verifier) VerifySignature(ctx context.Context, chainID int64, address, signature string, message []byte) (Method, error) {
+	if !common.IsHexAddress(address) {
+		return "", ErrInvalidSignature
+	}
+
+	if ValidateMessageSignature(address, signature, message) {
+		return MethodEOA, nil
+	}
+
+	if v.caller == nil {
+		return "", ErrInvalidSignature
+	}
+
+	if chainID != v.chainID {
+		return "", fmt.Errorf("%w: contract wallets can only sign in on chain %d", ErrInvalidSignature, v.chainID)
+	}
+
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+
+	ok, err := IsValidSignature(ctx, v.caller, common.HexToAddress(address), accounts.TextHash(message), sig)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrInvalidSignature
+	}
+
+	return MethodEIP1271, nil
+}
+
+// IsValidSignature calls isValidSignature(bytes32,bytes) of the contract at
+// address, as defined by EIP-1271. Addresses without code return no data and
+// are reported as not valid, and so are calls the node reports as reverted.
+// Other errors, including reverts some nodes report without code or data, are
+// returned, so that an unavailable node is not taken for an invalid signature.
+func IsValidSignature(ctx context.Context, caller ContractCaller, address common.Address, hash []byte, signature []byte) (bool, error) {
+	to := address
+	out, err := caller.CallContract(ctx, ethereum.CallMsg{
+		To:   &to,
+		Data: encodeIsValidSignature(hash, signature),
+	}, nil)
+	if err != nil {
+		// reverting contracts do not implement EIP-1271
+		if isRevert(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return len(out) >= 4 && bytes.Equal(out[:4], eip1271MagicValue), nil
+}
+
+// isRevert reports whether the node failed a call because it reverted: with
+// the error code of reverts, or with revert data attached to the error.
+func isRevert(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == errCodeReverted {
+		return true
+	}
+
+	var dataErr rpc.DataError
+	return errors.As(err, &dataErr) && dataErr.ErrorData() != nil
+}
+
+// encodeIsValidSignature ABI encodes a call of isValidSignature(bytes32,bytes).
+func encodeIsValidSignature(hash []byte, signature []byte) []byte {
+	data := append([]byte{}, eip1271MagicValue...)
+	data = append(data, common.LeftPadBytes(hash, 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(64).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(signature))).Bytes(), 32)...)
+	data = append(data, common.RightPadBytes(signature, (len(signature)+31)/32*32)...)
+
+	return data
+}
@@ -0,0 +1,136 @@
+package web3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	message := []byte("joinhello")
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+
+	sig, err := crypto.Sign(accounts.TextHash(message), key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+
+	// contract wallets sign with their own scheme, e.g. concatenated owner signatures
+	safe := common.HexToAddress("0x5aFE3855358E112B5647B952709E6165e1c1eEEe")
+	safeSig := append(append([]byte{}, sig...), sig...)
+
+	caller := &stubCaller{Valid: map[common.Address][][]byte{safe: {safeSig}}}
+
+	testCases := []struct {
+		name      string
+		verifier  *Verifier
+		chainID   int64
+		address   common.Address
+		signature []byte
+		method    Method
+		err       error
+	}{
+		{"eoa", NewVerifier(caller, 1), 1, owner, sig, MethodEOA, nil},
+		{"eoa on other chain", NewVerifier(caller, 1), 10, owner, sig, MethodEOA, nil},
+		{"eoa without caller", NewVerifier(nil, 0), 1, owner, sig, MethodEOA, nil},
+		{"contract wallet", NewVerifier(caller, 1), 1, safe, safeSig, MethodEIP1271, nil},
+		{"contract wallet on other chain", NewVerifier(caller, 1), 10, safe, safeSig, "", ErrInvalidSignature},
+		{"contract wallet without caller", NewVerifier(nil, 0), 1, safe, safeSig, "", ErrInvalidSignature},
+		{"contract rejects signature", NewVerifier(caller, 1), 1, safe, sig, "", ErrInvalidSignature},
+		{"eoa signature of other wallet", NewVerifier(caller, 1), 1, safe, sig, "", ErrInvalidSignature},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method, err := tc.verifier.VerifySignature(ctx, tc.chainID, tc.address.Hex(), hexutil.Encode(tc.signature), message)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.method, method)
+		})
+	}
+
+	callErrors := []struct {
+		name string
+		err  error
+	}{
+		{"connection refused", errors.New("connection refused")},
+		{"server error", &rpcError{code: -32000, message: "header not found"}},
+		{"revert without code or data", &rpcError{code: -32000, message: "execution reverted"}},
+	}
+
+	for _, tc := range callErrors {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewVerifier(&stubCaller{Err: tc.err}, 1).VerifySignature(ctx, 1, safe.Hex(), hexutil.Encode(safeSig), message)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	reverts := []struct {
+		name string
+		err  error
+	}{
+		{"revert code", &rpcError{code: errCodeReverted, message: "execution reverted", data: "0x08c379a0"}},
+		{"revert data", &rpcError{code: -32015, message: "VM execution error", data: "0x"}},
+	}
+
+	for _, tc := range reverts {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewVerifier(&stubCaller{Err: tc.err}, 1).VerifySignature(ctx, 1, safe.Hex(), hexutil.Encode(safeSig), message)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+		})
+	}
+}
+
+// rpcError is an error of a JSON-RPC node.
+type rpcError struct {
+	code    int
+	message string
+	data    interface{}
+}
+
+func (e *rpcError) Error() string          { return e.message }
+func (e *rpcError) ErrorCode() int         { return e.code }
+func (e *rpcError) ErrorData() interface{} { return e.data }
+
+// stubCaller is a ContractCaller whose contracts in Valid accept the signatures
+// listed for them; every other call returns no data.
+type stubCaller struct {
+	Valid map[common.Address][][]byte
+	Err   error
+}
+
+// CallContract implements ContractCaller.
+func (s *stubCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	if call.To == nil || len(call.Data) < 4+32*3 || !bytes.Equal(call.Data[:4], eip1271MagicValue) {
+		return nil, nil
+	}
+
+	length := new(big.Int).SetBytes(call.Data[4+64 : 4+96]).Int64()
+	if int64(len(call.Data)) < 4+96+length {
+		return nil, nil
+	}
+	signature := call.Data[4+96 : 4+96+length]
+
+	for _, valid := range s.Valid[*call.To] {
+		if bytes.Equal(valid, signature) {
+			return common.RightPadBytes(eip1271MagicValue, 32), nil
+		}
+	}
+
+	return common.LeftPadBytes(nil, 32), nil
+}